@@ -0,0 +1,127 @@
+package epub
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// coverID is the id of the manifest item of a cover added by AddCover, also the name of its file
+const coverID = "dir2opds-cover"
+
+var (
+	metadataEnd = regexp.MustCompile(`</(\w+:)?metadata\s*>`)
+	manifestEnd = regexp.MustCompile(`</(\w+:)?manifest\s*>`)
+)
+
+// AddCover writes to w the epub in filePath with image added as its cover. The image is declared in
+// the package document by a manifest item, with the cover-image property in epub 3, and the cover
+// meta of epub 2. The other files of the epub are copied as they are.
+func AddCover(w io.Writer, filePath string, image []byte, mediaType string) error {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	opfPath, err := packagePath(&r.Reader)
+	if err != nil {
+		return err
+	}
+
+	var pkg packageDocument
+	if err := decode(&r.Reader, opfPath, &pkg); err != nil {
+		return err
+	}
+
+	opf, err := readFile(&r.Reader, opfPath)
+	if err != nil {
+		return err
+	}
+
+	href := coverID + coverExtension(mediaType)
+	opf, err = declareCover(opf, href, mediaType, strings.HasPrefix(strings.TrimSpace(pkg.Version), "3"))
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range r.File {
+		if f.Name != opfPath {
+			// the mimetype file is kept first and stored
+			if err := zw.Copy(f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: f.Modified})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, opf); err != nil {
+			return err
+		}
+	}
+
+	// images are already compressed
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: path.Join(path.Dir(opfPath), href), Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(image); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// declareCover adds to the package document opf the manifest item of the cover in href and the cover meta
+func declareCover(opf, href, mediaType string, epub3 bool) (string, error) {
+	metadata := metadataEnd.FindStringSubmatchIndex(opf)
+	if metadata == nil {
+		return "", errors.New("epub: no metadata in the package document")
+	}
+	prefix := ""
+	if metadata[2] >= 0 {
+		prefix = opf[metadata[2]:metadata[3]]
+	}
+
+	item := `<` + prefix + `item id="` + coverID + `" href="` + href + `" media-type="` + mediaType + `"`
+	if epub3 {
+		item += ` properties="cover-image"`
+	}
+	item += `/>`
+
+	manifest := manifestEnd.FindStringIndex(opf)
+	if manifest == nil {
+		opf = opf[:metadata[1]] + `<` + prefix + `manifest>` + item + `</` + prefix + `manifest>` + opf[metadata[1]:]
+	} else {
+		opf = opf[:manifest[0]] + item + opf[manifest[0]:]
+	}
+
+	// the end of the metadata moved when the manifest is before it
+	if manifest != nil && manifest[0] < metadata[0] {
+		metadata[0] += len(item)
+	}
+	return opf[:metadata[0]] + `<` + prefix + `meta name="cover" content="` + coverID + `"/>` + opf[metadata[0]:], nil
+}
+
+// coverExtension returns the extension of the file of a cover image of mediaType
+func coverExtension(mediaType string) string {
+	switch mediaType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	}
+	if extensions, err := mime.ExtensionsByType(mediaType); err == nil && len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package epub_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/epub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddCover(t *testing.T) {
+	tests := map[string]struct {
+		opf  string
+		want epub.Resource
+	}{
+		"epub 3": {
+			opf:  `<package version="3.0"><metadata><title>A</title></metadata><manifest><item id="c1" href="chapter1.xhtml" media-type="application/xhtml+xml"/></manifest></package>`,
+			want: epub.Resource{Href: "OEBPS/dir2opds-cover.png", MediaType: "image/png"},
+		},
+		"epub 2 with prefixed elements": {
+			opf:  `<opf:package version="2.0" xmlns:opf="http://www.idpf.org/2007/opf"><opf:metadata></opf:metadata><opf:manifest></opf:manifest></opf:package>`,
+			want: epub.Resource{Href: "OEBPS/dir2opds-cover.png", MediaType: "image/png"},
+		},
+		"without manifest": {
+			opf:  `<package><metadata></metadata></package>`,
+			want: epub.Resource{Href: "OEBPS/dir2opds-cover.png", MediaType: "image/png"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// setup
+			dir := t.TempDir()
+			writeZip(t, filepath.Join(dir, "book.epub"), map[string]string{"META-INF/container.xml": containerXML, "OEBPS/content.opf": tc.opf})
+			var buf bytes.Buffer
+
+			// act
+			err := epub.AddCover(&buf, filepath.Join(dir, "book.epub"), []byte("PNG"), "image/png")
+
+			// verify
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "with-cover.epub"), buf.Bytes(), 0o644))
+			pkg, err := epub.ReadPackage(filepath.Join(dir, "with-cover.epub"))
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, pkg.Cover)
+			assert.Contains(t, pkg.Resources, tc.want)
+		})
+	}
+
+	t.Run("without metadata", func(t *testing.T) {
+		// setup
+		dir := t.TempDir()
+		writeZip(t, filepath.Join(dir, "book.epub"), map[string]string{"META-INF/container.xml": containerXML, "OEBPS/content.opf": `<package></package>`})
+
+		// act
+		err := epub.AddCover(new(bytes.Buffer), filepath.Join(dir, "book.epub"), []byte("PNG"), "image/png")
+
+		// verify
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,302 @@
+// Package epub reads the metadata of epub files from their package document (the .opf file)
+// https://www.w3.org/TR/epub-33/#sec-package-doc
+package epub
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const containerPath = "META-INF/container.xml"
+
+// Metadata is the publication metadata of an epub
+type Metadata struct {
+	// Creators are the dc:creator of the book in document order
+	Creators []string
+	// Titles are the dc:title of the book in document order with their xml:lang
+	Titles []Title
+	// Series is the name of the series of the book from the calibre:series meta
+	Series string
+	// SeriesIndex is the position of the book in its series from the calibre:series_index meta,
+	// like 3 or 1.5, empty when it is missing or not a number
+	SeriesIndex string
+	// Rights is the first dc:rights of the book, its license, empty when it is missing
+	Rights string
+	// Identifier is the dc:identifier named by the unique-identifier of the package, or the first
+	// dc:identifier when it names none, like urn:uuid:... or an ISBN. Empty when it is missing.
+	Identifier string
+	// Subjects are the dc:subject of the book in document order, like the tags of calibre
+	Subjects []string
+}
+
+// Title is a dc:title in the language of Lang, empty when it is not declared
+type Title struct {
+	Lang  string
+	Value string
+}
+
+type container struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// Package is the package document of an epub, its metadata and the resources it is made of
+type Package struct {
+	Metadata Metadata
+	// Language is the first dc:language of the book, empty when it is missing
+	Language string
+	// Resources are the items of the manifest in document order
+	Resources []Resource
+	// ReadingOrder are the resources of the spine, the order the book is read in
+	ReadingOrder []Resource
+	// Cover is the item with the cover-image property or, in epub 2, the item of the cover meta.
+	// Its Href is empty when the book has no cover.
+	Cover Resource
+}
+
+// Resource is an item of the manifest, its Href is the path of the file in the epub
+type Resource struct {
+	Href      string
+	MediaType string
+}
+
+type packageDocument struct {
+	Version          string `xml:"version,attr"`
+	UniqueIdentifier string `xml:"unique-identifier,attr"`
+	Metadata         struct {
+		Creators []string `xml:"creator"`
+		Titles   []struct {
+			Lang  string `xml:"lang,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"title"`
+		Metas []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"meta"`
+		Languages   []string `xml:"language"`
+		Rights      []string `xml:"rights"`
+		Subjects    []string `xml:"subject"`
+		Identifiers []struct {
+			ID    string `xml:"id,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"identifier"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// ReadMetadata reads the metadata of the epub in filePath
+func ReadMetadata(filePath string) (Metadata, error) {
+	pkg, err := ReadPackage(filePath)
+	return pkg.Metadata, err
+}
+
+// ReadPackage reads the metadata, the manifest and the spine of the epub in filePath
+func ReadPackage(filePath string) (Package, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return Package{}, err
+	}
+	defer r.Close()
+
+	opfPath, err := packagePath(&r.Reader)
+	if err != nil {
+		return Package{}, err
+	}
+
+	var pkg packageDocument
+	if err := decode(&r.Reader, opfPath, &pkg); err != nil {
+		return Package{}, err
+	}
+
+	return Package{
+		Metadata:     readMetadata(pkg),
+		Language:     firstLanguage(pkg),
+		Resources:    resources(pkg, path.Dir(opfPath)),
+		ReadingOrder: readingOrder(pkg, path.Dir(opfPath)),
+		Cover:        cover(pkg, path.Dir(opfPath)),
+	}, nil
+}
+
+// packagePath returns the path in the zip of the package document declared by the container
+func packagePath(r *zip.Reader) (string, error) {
+	var c container
+	if err := decode(r, containerPath, &c); err != nil {
+		return "", err
+	}
+
+	for _, rootfile := range c.Rootfiles {
+		if rootfile.MediaType == "" || rootfile.MediaType == "application/oebps-package+xml" {
+			return path.Clean(rootfile.FullPath), nil
+		}
+	}
+	return "", errors.New("epub: no package document in " + containerPath)
+}
+
+func readMetadata(pkg packageDocument) Metadata {
+	var meta Metadata
+	for _, creator := range pkg.Metadata.Creators {
+		if creator = strings.TrimSpace(creator); creator != "" {
+			meta.Creators = append(meta.Creators, creator)
+		}
+	}
+	for _, title := range pkg.Metadata.Titles {
+		if value := strings.TrimSpace(title.Value); value != "" {
+			meta.Titles = append(meta.Titles, Title{Lang: strings.TrimSpace(title.Lang), Value: value})
+		}
+	}
+	for _, rights := range pkg.Metadata.Rights {
+		if rights = strings.TrimSpace(rights); rights != "" {
+			meta.Rights = rights
+			break
+		}
+	}
+	for _, subject := range pkg.Metadata.Subjects {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			meta.Subjects = append(meta.Subjects, subject)
+		}
+	}
+	for _, identifier := range pkg.Metadata.Identifiers {
+		value := strings.TrimSpace(identifier.Value)
+		if value == "" {
+			continue
+		}
+		if identifier.ID == pkg.UniqueIdentifier {
+			meta.Identifier = value
+			break
+		}
+		if meta.Identifier == "" {
+			meta.Identifier = value
+		}
+	}
+	for _, m := range pkg.Metadata.Metas {
+		switch m.Name {
+		case "calibre:series":
+			meta.Series = strings.TrimSpace(m.Content)
+		case "calibre:series_index":
+			if index := strings.TrimSpace(m.Content); isNumber(index) {
+				meta.SeriesIndex = index
+			}
+		}
+	}
+	return meta
+}
+
+func firstLanguage(pkg packageDocument) string {
+	for _, language := range pkg.Metadata.Languages {
+		if language = strings.TrimSpace(language); language != "" {
+			return language
+		}
+	}
+	return ""
+}
+
+// resources returns the items of the manifest in document order
+func resources(pkg packageDocument, dir string) []Resource {
+	var items []Resource
+	for _, item := range pkg.Manifest {
+		if item.Href != "" {
+			items = append(items, Resource{Href: resolve(dir, item.Href), MediaType: item.MediaType})
+		}
+	}
+	return items
+}
+
+// readingOrder returns the items of the manifest referenced by the spine in its order
+func readingOrder(pkg packageDocument, dir string) []Resource {
+	byID := map[string]Resource{}
+	for _, item := range pkg.Manifest {
+		if item.Href != "" {
+			byID[item.ID] = Resource{Href: resolve(dir, item.Href), MediaType: item.MediaType}
+		}
+	}
+
+	var order []Resource
+	for _, itemref := range pkg.Spine {
+		if item, ok := byID[itemref.IDRef]; ok {
+			order = append(order, item)
+		}
+	}
+	return order
+}
+
+// cover returns the item of the manifest that is the cover image of the book
+func cover(pkg packageDocument, dir string) Resource {
+	for _, item := range pkg.Manifest {
+		if item.Href != "" && slices.Contains(strings.Fields(item.Properties), "cover-image") {
+			return Resource{Href: resolve(dir, item.Href), MediaType: item.MediaType}
+		}
+	}
+
+	for _, m := range pkg.Metadata.Metas {
+		if m.Name != "cover" {
+			continue
+		}
+		for _, item := range pkg.Manifest {
+			if item.ID == strings.TrimSpace(m.Content) && item.Href != "" {
+				return Resource{Href: resolve(dir, item.Href), MediaType: item.MediaType}
+			}
+		}
+	}
+	return Resource{}
+}
+
+// resolve returns the path in the epub of an href relative to the package document in dir
+func resolve(dir, href string) string {
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Join(dir, href)
+}
+
+// isNumber reports if s is a non negative decimal number like 3 or 1.5
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil && s != "" && strings.Trim(s, "0123456789.") == ""
+}
+
+// readFile returns the content of the file in name of the zip
+func readFile(r *zip.Reader, name string) (string, error) {
+	f, err := r.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("epub: %w", err)
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return "", fmt.Errorf("epub: read %s: %w", name, err)
+	}
+	return string(content), nil
+}
+
+// decode unmarshals the xml file in name of the zip into v
+func decode(r *zip.Reader, name string, v any) error {
+	f, err := r.Open(name)
+	if err != nil {
+		return fmt.Errorf("epub: %w", err)
+	}
+	defer f.Close()
+
+	if err := xml.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("epub: decode %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package epub_test
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/epub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const containerXML = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+func TestReadMetadata(t *testing.T) {
+	tests := map[string]struct {
+		files   map[string]string
+		want    epub.Metadata
+		wantErr bool
+	}{
+		"several creators": {
+			files: map[string]string{
+				"META-INF/container.xml": containerXML,
+				"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Good Omens</dc:title>
+    <dc:creator id="a1">Terry Pratchett</dc:creator>
+    <dc:creator id="a2"> Neil Gaiman </dc:creator>
+  </metadata>
+</package>`,
+			},
+			want: epub.Metadata{Creators: []string{"Terry Pratchett", "Neil Gaiman"}, Titles: []epub.Title{{Value: "Good Omens"}}},
+		},
+		"titles in several languages": {
+			files: map[string]string{
+				"META-INF/container.xml": containerXML,
+				"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title xml:lang="en">The Little Prince</dc:title>
+    <dc:title xml:lang="fr">Le Petit Prince</dc:title>
+  </metadata>
+</package>`,
+			},
+			want: epub.Metadata{Titles: []epub.Title{{Lang: "en", Value: "The Little Prince"}, {Lang: "fr", Value: "Le Petit Prince"}}},
+		},
+		"calibre series index": {
+			files: map[string]string{
+				"META-INF/container.xml": containerXML,
+				"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:creator>Frank Herbert</dc:creator>
+    <meta name="calibre:series" content="Dune"/>
+    <meta name="calibre:series_index" content="2.0"/>
+  </metadata>
+</package>`,
+			},
+			want: epub.Metadata{Creators: []string{"Frank Herbert"}, Series: "Dune", SeriesIndex: "2.0"},
+		},
+		"invalid series index": {
+			files: map[string]string{
+				"META-INF/container.xml": containerXML,
+				"OEBPS/content.opf":      `<package><metadata><meta name="calibre:series_index" content="two"/></metadata></package>`,
+			},
+			want: epub.Metadata{},
+		},
+		"without creators": {
+			files: map[string]string{
+				"META-INF/container.xml": containerXML,
+				"OEBPS/content.opf":      `<package><metadata><dc:title>Anonymous</dc:title></metadata></package>`,
+			},
+			want: epub.Metadata{Titles: []epub.Title{{Value: "Anonymous"}}},
+		},
+		"rights": {
+			files: map[string]string{
+				"META-INF/container.xml": containerXML,
+				"OEBPS/content.opf":      `<package><metadata><dc:rights> </dc:rights><dc:rights>Public domain in the USA.</dc:rights></metadata></package>`,
+			},
+			want: epub.Metadata{Rights: "Public domain in the USA."},
+		},
+		"subjects": {
+			files: map[string]string{
+				"META-INF/container.xml": containerXML,
+				"OEBPS/content.opf":      `<package><metadata><dc:subject>Fantasy</dc:subject><dc:subject> </dc:subject><dc:subject>Classics</dc:subject></metadata></package>`,
+			},
+			want: epub.Metadata{Subjects: []string{"Fantasy", "Classics"}},
+		},
+		"unique identifier": {
+			files: map[string]string{
+				"META-INF/container.xml": containerXML,
+				"OEBPS/content.opf":      `<package unique-identifier="uid"><metadata><dc:identifier id="isbn">9780000000002</dc:identifier><dc:identifier id="uid">urn:uuid:1b4e28ba-2fa1-11d2-883f-0016d3cca427</dc:identifier></metadata></package>`,
+			},
+			want: epub.Metadata{Identifier: "urn:uuid:1b4e28ba-2fa1-11d2-883f-0016d3cca427"},
+		},
+		"first identifier": {
+			files: map[string]string{
+				"META-INF/container.xml": containerXML,
+				"OEBPS/content.opf":      `<package><metadata><dc:identifier> </dc:identifier><dc:identifier>9780000000002</dc:identifier><dc:identifier>urn:uuid:1b4e28ba-2fa1-11d2-883f-0016d3cca427</dc:identifier></metadata></package>`,
+			},
+			want: epub.Metadata{Identifier: "9780000000002"},
+		},
+		"without container": {
+			files:   map[string]string{"OEBPS/content.opf": `<package></package>`},
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// setup
+			fPath := filepath.Join(t.TempDir(), "book.epub")
+			writeZip(t, fPath, tc.files)
+
+			// act
+			got, err := epub.ReadMetadata(fPath)
+
+			// verify
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestReadPackage(t *testing.T) {
+	// setup
+	fPath := filepath.Join(t.TempDir(), "book.epub")
+	writeZip(t, fPath, map[string]string{
+		"META-INF/container.xml": containerXML,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Good Omens</dc:title>
+    <dc:language>en</dc:language>
+  </metadata>
+  <manifest>
+    <item id="css" href="style.css" media-type="text/css"/>
+    <item id="c2" href="text/chapter%202.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c1" href="text/chapter%201.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="c1"/>
+    <itemref idref="c2"/>
+    <itemref idref="missing"/>
+  </spine>
+</package>`,
+	})
+
+	// act
+	got, err := epub.ReadPackage(fPath)
+
+	// verify the hrefs are the paths in the epub and the reading order is the spine
+	require.NoError(t, err)
+	assert.Equal(t, epub.Package{
+		Metadata: epub.Metadata{Titles: []epub.Title{{Value: "Good Omens"}}},
+		Language: "en",
+		Resources: []epub.Resource{
+			{Href: "OEBPS/style.css", MediaType: "text/css"},
+			{Href: "OEBPS/text/chapter 2.xhtml", MediaType: "application/xhtml+xml"},
+			{Href: "OEBPS/text/chapter 1.xhtml", MediaType: "application/xhtml+xml"},
+		},
+		ReadingOrder: []epub.Resource{
+			{Href: "OEBPS/text/chapter 1.xhtml", MediaType: "application/xhtml+xml"},
+			{Href: "OEBPS/text/chapter 2.xhtml", MediaType: "application/xhtml+xml"},
+		},
+	}, got)
+}
+
+func TestReadPackageCover(t *testing.T) {
+	tests := map[string]struct {
+		opf  string
+		want epub.Resource
+	}{
+		"cover-image property": {
+			opf:  `<package><manifest><item id="c" href="images/front.png" media-type="image/png" properties="cover-image"/></manifest></package>`,
+			want: epub.Resource{Href: "OEBPS/images/front.png", MediaType: "image/png"},
+		},
+		"epub 2 cover meta": {
+			opf:  `<package><metadata><meta name="cover" content="front"/></metadata><manifest><item id="front" href="front.jpg" media-type="image/jpeg"/></manifest></package>`,
+			want: epub.Resource{Href: "OEBPS/front.jpg", MediaType: "image/jpeg"},
+		},
+		"without cover": {
+			opf:  `<package><manifest><item id="c1" href="chapter1.xhtml" media-type="application/xhtml+xml"/></manifest></package>`,
+			want: epub.Resource{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// setup
+			fPath := filepath.Join(t.TempDir(), "book.epub")
+			writeZip(t, fPath, map[string]string{"META-INF/container.xml": containerXML, "OEBPS/content.opf": tc.opf})
+
+			// act
+			got, err := epub.ReadPackage(fPath)
+
+			// verify
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got.Cover)
+		})
+	}
+}
+
+func writeZip(t *testing.T, fPath string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(fPath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"archive/zip"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+type archiveFormats struct {
+	size    int64
+	modTime time.Time
+	types   []string
+}
+
+var (
+	archivesMu sync.Mutex
+	// archives caches the types of the books inside the zips by path, an entry is replaced when the file changes
+	archives = map[string]archiveFormats{}
+)
+
+// addArchiveFormats adds to the acquisition link of a zip holding books the types of the books
+// inside it with opds:indirectAcquisition when ArchiveFormats is enabled
+func (s OPDS) addArchiveFormats(filePath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	types := s.archiveTypes(filePath)
+	if len(types) == 0 {
+		return builder
+	}
+
+	indirect := make([]opds.IndirectAcquisition, 0, len(types))
+	for _, t := range types {
+		indirect = append(indirect, opds.IndirectAcquisition{Type: t})
+	}
+	return builder.AddIndirectAcquisition(s.acquisitionRel(), indirect...)
+}
+
+// archiveTypes returns the types of the books inside the zip in filePath in the order they are
+// found, none for the comic zips and the zips without books
+func (s OPDS) archiveTypes(filePath string) []string {
+	if !s.ArchiveFormats || strings.ToLower(filepath.Ext(filePath)) != ".zip" || s.isComicZip(filePath) {
+		return nil
+	}
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return nil
+	}
+
+	archivesMu.Lock()
+	cached, ok := archives[filePath]
+	archivesMu.Unlock()
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached.types
+	}
+
+	types := bookTypes(filePath)
+
+	archivesMu.Lock()
+	archives[filePath] = archiveFormats{size: fi.Size(), modTime: fi.ModTime(), types: types}
+	archivesMu.Unlock()
+	return types
+}
+
+// bookTypes returns the types of the books inside the zip in filePath without repeating them
+func bookTypes(filePath string) []string {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+
+	var types []string
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !isEbook(f.Name) {
+			continue
+		}
+		t := getType(path.Base(f.Name), pathTypeFile)
+		if t != "" && !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	return types
+}
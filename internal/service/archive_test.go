@@ -0,0 +1,76 @@
+package service_test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerArchiveFormats(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	writeZipFiles(t, filepath.Join(dir, "book.zip"), "book/", "book/book.epub", "book/book.pdf", "book/book (large print).EPUB", "book/notes.txt")
+	writeZipFiles(t, filepath.Join(dir, "comic.zip"), "001.jpg", "002.jpg")
+	writeZipFiles(t, filepath.Join(dir, "notes.zip"), "notes.txt")
+
+	type indirect struct {
+		Type string `xml:"type,attr"`
+	}
+	type feed struct {
+		Entries []struct {
+			Title string `xml:"title"`
+			Links []struct {
+				Rel      string     `xml:"rel,attr"`
+				Type     string     `xml:"type,attr"`
+				Indirect []indirect `xml:"http://opds-spec.org/2010/catalog indirectAcquisition"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+
+	tests := map[string]struct {
+		archiveFormats bool
+		want           map[string][]indirect
+	}{
+		"archive formats": {archiveFormats: true, want: map[string][]indirect{
+			"book.zip":  {{Type: "application/epub+zip"}, {Type: "application/pdf"}},
+			"comic.zip": nil,
+			"notes.zip": nil,
+		}},
+		"without archive formats": {archiveFormats: false, want: map[string][]indirect{
+			"book.zip":  nil,
+			"comic.zip": nil,
+			"notes.zip": nil,
+		}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, ArchiveFormats: tc.archiveFormats, ComicZips: true}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+			// act
+			err := s.Handler(w, req)
+
+			// verify
+			require.NoError(t, err)
+			var got feed
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &got))
+			formats := map[string][]indirect{}
+			for _, entry := range got.Entries {
+				for _, link := range entry.Links {
+					if link.Rel == "http://opds-spec.org/acquisition" {
+						formats[entry.Title] = link.Indirect
+					}
+				}
+			}
+			assert.Equal(t, tc.want, formats)
+		})
+	}
+}
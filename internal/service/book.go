@@ -0,0 +1,177 @@
+package service
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+const bookPathPrefix = "/book/"
+
+const facetRel = "http://opds-spec.org/facet"
+
+// bookFormat is the format of a book file used by the format facets, its lower case extension
+func bookFormat(name string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+}
+
+// bookFolderFiles returns the names of the books in dirPath when the folder is one book
+// in several formats: it has no subfolders and all its books share the same name,
+// e.g. mybook.epub, mybook.mobi and mybook.pdf, or it only holds audio files like
+// the chapters of an audiobook. It returns nil otherwise.
+func (s OPDS) bookFolderFiles(dirPath string) []string {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		log.Printf("bookFolderFiles: readDir err: %s", err)
+		return nil
+	}
+
+	var names []string
+	var bookName string
+	sameName, allAudio := true, true
+	for _, entry := range dirEntries {
+		_, pathRelativeToContentRoot, _ := strings.Cut(filepath.Join(dirPath, entry.Name()), s.TrustedRoot+"/")
+		if s.fileShouldBeIgnored(pathRelativeToContentRoot) || isCover(entry.Name()) {
+			continue
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		if !s.included(entry.Name()) || s.tooSmall(filepath.Join(dirPath, entry.Name())) {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if bookName != "" && name != bookName {
+			sameName = false
+		}
+		bookName = name
+		allAudio = allAudio && isAudio(entry.Name())
+		names = append(names, entry.Name())
+	}
+
+	if len(names) < 2 || !(sameName || allAudio) {
+		return nil
+	}
+	return names
+}
+
+// bookTitle is the title of the book in a folder, the name of its files
+// or the name of the folder for the chapters of an audiobook
+func bookTitle(dirPath string, names []string) string {
+	title := strings.TrimSuffix(names[0], filepath.Ext(names[0]))
+	for _, name := range names[1:] {
+		if strings.TrimSuffix(name, filepath.Ext(name)) != title {
+			return filepath.Base(dirPath)
+		}
+	}
+	return title
+}
+
+// serveBook serves the folder in urlPath as a single book with one acquisition link per format
+func (s OPDS) serveBook(w http.ResponseWriter, req *http.Request, urlPath string) error {
+	dirPath := filepath.Join(s.TrustedRoot, strings.TrimPrefix(urlPath, bookPathPrefix))
+
+	dirPath, err := verifyPath(dirPath, s.TrustedRoot)
+	if err != nil {
+		log.Printf("book %q err: %s", dirPath, err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	_, pathRelativeToContentRoot, _ := strings.Cut(dirPath, s.TrustedRoot+"/")
+	if !s.BookFolders || s.fileShouldBeIgnored(pathRelativeToContentRoot) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	if s.accessDenied(w, req, pathRelativeToContentRoot) {
+		return nil
+	}
+
+	names := s.bookFolderFiles(dirPath)
+	if names == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	if format := strings.ToLower(req.URL.Query().Get("format")); s.BookFormatFacets && format != "" && !slices.ContainsFunc(names, func(name string) bool {
+		return bookFormat(name) == format
+	}) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	return s.serveBuiltFeed(w, req, acquisitionType, func(req *http.Request) (any, error) {
+		feed := s.makeFeedBook(req, dirPath, names)
+		return &opds.AcquisitionFeed{Feed: &feed, Dc: "http://purl.org/dc/terms/", Opds: "http://opds-spec.org/2010/catalog"}, nil
+	})
+}
+
+// makeFeedBook returns an acquisition feed with a single entry holding every format of the book.
+// With BookFormatFacets the feed links a facet per format, selected with the format query param,
+// whose entry only holds the acquisition link of that format.
+func (s OPDS) makeFeedBook(req *http.Request, dirPath string, names []string) opds.Feed {
+	_, pathRelativeToContentRoot, _ := strings.Cut(dirPath, s.TrustedRoot+"/")
+	title := bookTitle(dirPath, names)
+
+	feedBuilder := opds.FeedBuilder.
+		ID(req.URL.Path).
+		Title(title).
+		Updated(TimeNow()).
+		AddLink(s.startLink())
+
+	if !s.DisableSearch {
+		feedBuilder = feedBuilder.AddLink(s.searchLink())
+	}
+
+	builder := opds.EntryBuilder{}.
+		ID(bookPathPrefix + pathRelativeToContentRoot).
+		Title(title)
+
+	var format string
+	if s.BookFormatFacets {
+		format = strings.ToLower(req.URL.Query().Get("format"))
+
+		var formats []string
+		for _, name := range names {
+			if !slices.Contains(formats, bookFormat(name)) {
+				formats = append(formats, bookFormat(name))
+			}
+		}
+		for _, f := range formats {
+			feedBuilder = feedBuilder.AddLink(opds.LinkBuilder.
+				Rel(facetRel).
+				Title(strings.ToUpper(f)).
+				Href(s.href(bookPathPrefix + escapePath(pathRelativeToContentRoot) + "?format=" + url.QueryEscape(f))).
+				Type(acquisitionType).
+				Build())
+		}
+	}
+
+	for _, name := range names {
+		if format != "" && bookFormat(name) != format {
+			continue
+		}
+		_, fileRelativeToContentRoot, _ := strings.Cut(filepath.Join(dirPath, name), s.TrustedRoot+"/")
+
+		link := opds.LinkBuilder.
+			Rel(s.acquisitionRel()).
+			Title(name).
+			Href(s.href(filepath.Join("/shelf", escapePath(fileRelativeToContentRoot)))).
+			Type(s.linkType(filepath.Join(dirPath, name), pathTypeFile))
+		if fi, err := os.Stat(filepath.Join(dirPath, name)); err == nil && s.BookFormatFacets {
+			link = link.Length(uint(fi.Size()))
+		}
+		builder = builder.AddLink(link.Build())
+	}
+
+	builder = addCoverIfExists(filepath.Join(dirPath, names[0]), builder, s, req)
+
+	return feedBuilder.AddEntry(builder.Build()).Build()
+}
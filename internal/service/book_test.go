@@ -0,0 +1,160 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerBookFolders(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "my book"), 0o755))
+	for _, name := range []string{"my book.epub", "my book.mobi", "my book.pdf"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "my book", name), []byte("Fixture"), 0o644))
+	}
+	writeJPEG(t, filepath.Join(dir, "my book", "cover.jpg"), 10, 15)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "anthology"), 0o755))
+	for _, name := range []string{"first.epub", "second.epub"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "anthology", name), []byte("Fixture"), 0o644))
+	}
+	s := service.OPDS{TrustedRoot: dir, HideCalibreFiles: true, UseCalibreCovers: true, BookFolders: true}
+
+	// act
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+	require.NoError(t, s.Handler(w, req))
+
+	// verify the parent links to the book instead of descending
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<link rel="subsection" href="/book/my%20book" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="my book"></link>`)
+	assert.Contains(t, w.Body.String(), `<link rel="subsection" href="/shelf/anthology" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="anthology"></link>`)
+
+	// act
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/book/my%20book", nil)
+	require.NoError(t, s.Handler(w, req))
+
+	// verify a single entry holds every format and the shared cover
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"my book"}, entryTitles(t, w.Body.Bytes()))
+	for _, link := range []string{
+		`<link rel="http://opds-spec.org/acquisition" href="/shelf/my%20book/my%20book.epub" type="application/epub+zip" title="my book.epub"></link>`,
+		`<link rel="http://opds-spec.org/acquisition" href="/shelf/my%20book/my%20book.mobi" type="application/x-mobipocket-ebook" title="my book.mobi"></link>`,
+		`<link rel="http://opds-spec.org/acquisition" href="/shelf/my%20book/my%20book.pdf" type="application/pdf" title="my book.pdf"></link>`,
+		`<link rel="http://opds-spec.org/image" href="/shelf/my%20book/cover.jpg" type="image/jpeg"></link>`,
+	} {
+		assert.Contains(t, w.Body.String(), link)
+	}
+
+	// a folder with different books is not a book
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/book/anthology", nil)
+	require.NoError(t, s.Handler(w, req))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// without the option the folder is a folder
+	s.BookFolders = false
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/book/my%20book", nil)
+	require.NoError(t, s.Handler(w, req))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandlerAudiobooks(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "my audiobook"), 0o755))
+	for _, name := range []string{"01 chapter.mp3", "02 chapter.mp3"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "my audiobook", name), []byte("Fixture"), 0o644))
+	}
+	for _, name := range []string{"book.m4b", "book.m4a", "book.mp3"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("Fixture"), 0o644))
+	}
+
+	// act
+	s := service.OPDS{TrustedRoot: dir}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+	require.NoError(t, s.Handler(w, req))
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	for _, link := range []string{
+		`<link rel="http://opds-spec.org/acquisition" href="/shelf/book.m4a" type="audio/mp4" title="book.m4a"></link>`,
+		`<link rel="http://opds-spec.org/acquisition" href="/shelf/book.m4b" type="audio/mp4" title="book.m4b"></link>`,
+		`<link rel="http://opds-spec.org/acquisition" href="/shelf/book.mp3" type="audio/mpeg" title="book.mp3"></link>`,
+	} {
+		assert.Contains(t, w.Body.String(), link)
+	}
+
+	// act
+	s.BookFolders = true
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/book/my%20audiobook", nil)
+	require.NoError(t, s.Handler(w, req))
+
+	// verify the chapters are one audiobook named after its folder
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"my audiobook"}, entryTitles(t, w.Body.Bytes()))
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/acquisition" href="/shelf/my%20audiobook/01%20chapter.mp3" type="audio/mpeg" title="01 chapter.mp3"></link>`)
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/acquisition" href="/shelf/my%20audiobook/02%20chapter.mp3" type="audio/mpeg" title="02 chapter.mp3"></link>`)
+}
+
+func TestHandlerBookFormatFacets(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "my book"), 0o755))
+	for _, name := range []string{"my book.epub", "my book.pdf"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "my book", name), []byte("Fixture"), 0o644))
+	}
+	s := service.OPDS{TrustedRoot: dir, BookFolders: true, BookFormatFacets: true}
+	facets := []string{
+		`<link rel="http://opds-spec.org/facet" href="/book/my%20book?format=epub" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="EPUB"></link>`,
+		`<link rel="http://opds-spec.org/facet" href="/book/my%20book?format=pdf" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="PDF"></link>`,
+	}
+	epub := `<link rel="http://opds-spec.org/acquisition" href="/shelf/my%20book/my%20book.epub" type="application/epub+zip" title="my book.epub" length="7"></link>`
+	pdf := `<link rel="http://opds-spec.org/acquisition" href="/shelf/my%20book/my%20book.pdf" type="application/pdf" title="my book.pdf" length="7"></link>`
+
+	tests := map[string]struct {
+		input            string
+		wantedStatusCode int
+		want             []string
+		notWant          []string
+	}{
+		"every format":   {input: "/book/my%20book", wantedStatusCode: 200, want: []string{epub, pdf}},
+		"epub facet":     {input: "/book/my%20book?format=epub", wantedStatusCode: 200, want: []string{epub}, notWant: []string{pdf}},
+		"pdf facet":      {input: "/book/my%20book?format=PDF", wantedStatusCode: 200, want: []string{pdf}, notWant: []string{epub}},
+		"missing format": {input: "/book/my%20book?format=mobi", wantedStatusCode: 404},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify there is one facet per format whatever the selected one
+			require.Equal(t, tc.wantedStatusCode, w.Code)
+			if tc.wantedStatusCode != http.StatusOK {
+				return
+			}
+			assert.Equal(t, len(facets), strings.Count(w.Body.String(), `rel="http://opds-spec.org/facet"`))
+			for _, link := range append(facets, tc.want...) {
+				assert.Contains(t, w.Body.String(), link)
+			}
+			for _, link := range tc.notWant {
+				assert.NotContains(t, w.Body.String(), link)
+			}
+		})
+	}
+}
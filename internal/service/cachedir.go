@@ -0,0 +1,100 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the kinds of artifacts stored in the CacheDir, each one in a folder of its own
+const (
+	checksumArtifacts  = "checksums"
+	coverArtifacts     = "covers"
+	thumbnailArtifacts = "thumbnails"
+	mosaicArtifacts    = "mosaics"
+	firstSeenArtifacts = "firstseen"
+)
+
+// artifactKey hashes the parts identifying an artifact into the name of its file
+func artifactKey(parts ...any) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%v\x00", part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fileKey identifies the artifacts made from a file by its path, size and modification time,
+// they are replaced when the file changes
+func fileKey(filePath string, fi os.FileInfo) string {
+	return artifactKey(filePath, fi.Size(), fi.ModTime().UnixNano())
+}
+
+// readArtifact returns the artifact of kind named key stored in the CacheDir,
+// false when the CacheDir is not set or it was not stored yet
+func (s OPDS) readArtifact(kind, key string) ([]byte, bool) {
+	if s.CacheDir == "" {
+		return nil, false
+	}
+
+	content, err := os.ReadFile(filepath.Join(s.CacheDir, kind, key))
+	if err != nil {
+		return nil, false
+	}
+	return content, true
+}
+
+// writeArtifact stores the artifact of kind named key in the CacheDir when it is set,
+// it is written to a temporary file first so it is never read half written
+func (s OPDS) writeArtifact(kind, key string, content []byte) {
+	if s.CacheDir == "" {
+		return
+	}
+
+	dir := filepath.Join(s.CacheDir, kind)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("writeArtifact %s err: %s", dir, err)
+		return
+	}
+
+	f, err := os.CreateTemp(dir, key+".*.tmp")
+	if err != nil {
+		log.Printf("writeArtifact %s err: %s", dir, err)
+		return
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, key))
+	}
+	if err != nil {
+		log.Printf("writeArtifact %s err: %s", f.Name(), err)
+		os.Remove(f.Name())
+	}
+}
+
+// validateCacheDir creates the CacheDir, it can't be in the TrustedRoot where its files would be listed
+func (s OPDS) validateCacheDir() error {
+	if err := os.MkdirAll(s.CacheDir, 0o755); err != nil {
+		return fmt.Errorf("cache dir %s: %w", s.CacheDir, err)
+	}
+
+	root, err := canonicalPath(s.TrustedRoot)
+	if err != nil {
+		return err
+	}
+	dir, err := canonicalPath(s.CacheDir)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(root, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("cache dir %s is in the trusted root %s", s.CacheDir, s.TrustedRoot)
+	}
+	return nil
+}
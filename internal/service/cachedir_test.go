@@ -0,0 +1,138 @@
+package service_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerCacheDir(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	cacheDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "series", "mybook"), 0o755))
+	writeEPUB(t, filepath.Join(dir, "series", "mybook", "mybook.epub"), `<dc:title>My book</dc:title>`)
+	writeJPEG(t, filepath.Join(dir, "series", "mybook", "cover.jpg"), 600, 900)
+	opts := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true, Thumbnails: true, Mosaics: true, IncludeChecksums: true, CacheDir: cacheDir}
+	require.NoError(t, opts.Validate())
+
+	serve := func(input string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		require.NoError(t, opts.Handler(w, httptest.NewRequest(http.MethodGet, input, nil)))
+		require.Equal(t, http.StatusOK, w.Code, input)
+		return w
+	}
+
+	// act
+	serve("/shelf/series/mybook")
+	thumbnail := serve("/thumbnail/series/mybook/mybook.epub").Body.Bytes()
+	mosaic := serve("/mosaic/series").Body.Bytes()
+
+	// verify
+	for kind, want := range map[string]int{"checksums": 2, "thumbnails": 1, "mosaics": 1} {
+		artifacts, err := os.ReadDir(filepath.Join(cacheDir, kind))
+		require.NoError(t, err, kind)
+		assert.Len(t, artifacts, want, kind)
+	}
+	stored, err := os.ReadDir(filepath.Join(cacheDir, "thumbnails"))
+	require.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(cacheDir, "thumbnails", stored[0].Name()))
+	require.NoError(t, err)
+	assert.Equal(t, thumbnail, content)
+
+	// after a restart the stored thumbnail is served instead of resizing the cover again
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "thumbnails", stored[0].Name()), []byte("stored thumbnail"), 0o644))
+	assert.Equal(t, "stored thumbnail", serve("/thumbnail/series/mybook/mybook.epub").Body.String())
+	assert.Equal(t, mosaic, serve("/mosaic/series").Body.Bytes())
+}
+
+func TestHandlerCacheDirEmbeddedCover(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	cacheDir := t.TempDir()
+	var cover bytes.Buffer
+	require.NoError(t, png.Encode(&cover, gradient(60, 90)))
+
+	f, err := os.Create(filepath.Join(dir, "book.epub"))
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for name, content := range map[string][]byte{
+		"META-INF/container.xml": []byte(`<container><rootfiles><rootfile full-path="content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`),
+		"content.opf":            []byte(`<package><manifest><item id="front" href="front.png" media-type="image/png" properties="cover-image"/></manifest></package>`),
+		"front.png":              cover.Bytes(),
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+	opts := service.OPDS{TrustedRoot: dir, EpubMetadata: true, CacheDir: cacheDir}
+
+	// act
+	w := httptest.NewRecorder()
+	require.NoError(t, opts.Handler(w, httptest.NewRequest(http.MethodGet, "/cover/book.epub", nil)))
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, cover.Bytes(), w.Body.Bytes())
+	stored, err := os.ReadDir(filepath.Join(cacheDir, "covers"))
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	content, err := os.ReadFile(filepath.Join(cacheDir, "covers", stored[0].Name()))
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte("image/png\n"), cover.Bytes()...), content)
+
+	// act
+	var stale bytes.Buffer
+	require.NoError(t, png.Encode(&stale, gradient(30, 45)))
+	require.NoError(t, os.WriteFile(filepath.Join(cacheDir, "covers", stored[0].Name()), append([]byte("image/png\n"), stale.Bytes()...), 0o644))
+	w = httptest.NewRecorder()
+	require.NoError(t, opts.Handler(w, httptest.NewRequest(http.MethodGet, "/cover/book.epub", nil)))
+
+	// verify the cover is served from the stored one, not from a copy in memory
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, stale.Bytes(), w.Body.Bytes())
+}
+
+func TestValidateCacheDir(t *testing.T) {
+	// setup
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "books")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+
+	tests := map[string]struct {
+		cacheDir string
+		wantErr  bool
+	}{
+		"outside the root": {cacheDir: filepath.Join(parent, "cache")},
+		"inside the root":  {cacheDir: filepath.Join(dir, "cache"), wantErr: true},
+		"the root":         {cacheDir: dir, wantErr: true},
+		"next to the root": {cacheDir: dir + "-cache"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// act
+			err := service.OPDS{TrustedRoot: dir, CacheDir: tc.cacheDir}.Validate()
+
+			// verify
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.DirExists(t, tc.cacheDir)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dubyte/dir2opds/opds"
+	"golang.org/x/tools/blog/atom"
+)
+
+const calendarPath = "/calendar"
+
+// serveCalendar serves the books grouped by the year and month they were modified:
+// /calendar lists the years, /calendar/2023 its months and /calendar/2023/03 the books.
+func (s OPDS) serveCalendar(w http.ResponseWriter, req *http.Request, urlPath string) error {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(urlPath, calendarPath), "/"), "/")
+	if parts[0] == "" {
+		parts = nil
+	}
+
+	var year, month int
+	var err error
+	if len(parts) > 0 {
+		year, err = strconv.Atoi(parts[0])
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return nil
+		}
+	}
+	if len(parts) > 1 {
+		month, err = strconv.Atoi(parts[1])
+		if err != nil || month < 1 || month > 12 {
+			w.WriteHeader(http.StatusNotFound)
+			return nil
+		}
+	}
+	if len(parts) > 2 {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	built, err := s.buildFeed(req, func(req *http.Request) (any, error) {
+		return s.makeFeedCalendar(req, year, month), nil
+	})
+	if err != nil {
+		log.Printf("building %q: %s", req.URL.Path, err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return nil
+	}
+
+	feed := built.(opds.Feed)
+	if len(feed.Entry) == 0 && year != 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	if month != 0 {
+		acFeed := &opds.AcquisitionFeed{Feed: &feed, Dc: "http://purl.org/dc/terms/", Opds: "http://opds-spec.org/2010/catalog"}
+		return s.serveFeed(w, req, acFeed, acquisitionType)
+	}
+	return s.serveFeed(w, req, feed, navigationType)
+}
+
+// makeFeedCalendar lists the years with books when year is zero, the months of the year
+// with books when month is zero or the books modified in that month, newest first.
+// Empty years and months are omitted.
+func (s OPDS) makeFeedCalendar(req *http.Request, year, month int) opds.Feed {
+	title := translate(req, "Books by date")
+	id := calendarPath
+	if year != 0 {
+		title = strconv.Itoa(year)
+		id = fmt.Sprintf("%s/%d", calendarPath, year)
+	}
+	if month != 0 {
+		title = fmt.Sprintf("%s %d", translate(req, time.Month(month).String()), year)
+		id = fmt.Sprintf("%s/%d/%02d", calendarPath, year, month)
+	}
+
+	feedBuilder := opds.FeedBuilder.
+		ID(id).
+		Title(title).
+		Updated(TimeNow()).
+		AddLink(s.startLink())
+
+	if !s.DisableSearch {
+		feedBuilder = feedBuilder.AddLink(s.searchLink())
+	}
+
+	// files are sorted newest first so the buckets are too
+	var buckets []string
+	counts := map[string]int{}
+	for _, file := range s.walkBooks(req) {
+		modTime := file.fileInfo.ModTime()
+
+		var bucket string
+		switch {
+		case year == 0:
+			bucket = strconv.Itoa(modTime.Year())
+		case modTime.Year() != year:
+			continue
+		case month == 0:
+			bucket = fmt.Sprintf("%d/%02d", year, modTime.Month())
+		case int(modTime.Month()) != month:
+			continue
+		default:
+			feedBuilder = feedBuilder.AddEntry(s.makeFileEntry(file, req).Build())
+			continue
+		}
+
+		if counts[bucket] == 0 {
+			buckets = append(buckets, bucket)
+		}
+		counts[bucket]++
+	}
+
+	for _, bucket := range buckets {
+		bucketTitle := bucket
+		linkType := navigationType
+		if year != 0 {
+			m, _ := strconv.Atoi(bucket[strings.Index(bucket, "/")+1:])
+			bucketTitle = translate(req, time.Month(m).String())
+			linkType = acquisitionType
+		}
+		href := calendarPath + "/" + bucket
+		content := atom.Text{Type: "text", Body: translate(req, "%d books.", counts[bucket])}
+
+		builder := opds.EntryBuilder{}.
+			Title(bucketTitle).
+			ID(href).
+			AddLink(opds.LinkBuilder.Rel("subsection").Href(s.href(href)).Type(linkType).Build()).
+			Content(&content)
+
+		feedBuilder = feedBuilder.AddEntry(builder.Build())
+	}
+
+	return feedBuilder.Build()
+}
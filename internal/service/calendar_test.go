@@ -0,0 +1,71 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerCalendar(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	books := map[string]time.Time{
+		"early march.epub": time.Date(2023, time.March, 10, 12, 0, 0, 0, time.Local),
+		"late march.epub":  time.Date(2023, time.March, 20, 12, 0, 0, 0, time.Local),
+		"january.epub":     time.Date(2023, time.January, 5, 12, 0, 0, 0, time.Local),
+		"old.epub":         time.Date(2021, time.July, 1, 12, 0, 0, 0, time.Local),
+	}
+	for name, modTime := range books {
+		fPath := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(fPath, []byte("Fixture"), 0o644))
+		require.NoError(t, os.Chtimes(fPath, modTime, modTime))
+	}
+	s := service.OPDS{TrustedRoot: dir}
+
+	tests := map[string]struct {
+		input            string
+		want             []string
+		wantContentType  string
+		wantedStatusCode int
+	}{
+		"years":       {input: "/calendar", want: []string{"2023", "2021"}, wantContentType: "application/atom+xml;profile=opds-catalog;kind=navigation", wantedStatusCode: 200},
+		"months":      {input: "/calendar/2023", want: []string{"March", "January"}, wantContentType: "application/atom+xml;profile=opds-catalog;kind=navigation", wantedStatusCode: 200},
+		"books":       {input: "/calendar/2023/03", want: []string{"late march.epub", "early march.epub"}, wantContentType: "application/atom+xml;profile=opds-catalog;kind=acquisition", wantedStatusCode: 200},
+		"empty year":  {input: "/calendar/2022", wantedStatusCode: 404},
+		"empty month": {input: "/calendar/2023/02", wantedStatusCode: 404},
+		"not a month": {input: "/calendar/2023/13", wantedStatusCode: 404},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			err := s.Handler(w, req)
+			require.NoError(t, err)
+
+			// verify
+			require.Equal(t, tc.wantedStatusCode, w.Code)
+			if tc.wantedStatusCode != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tc.wantContentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, tc.want, entryTitles(t, w.Body.Bytes()))
+		})
+	}
+
+	t.Run("month link", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/calendar/2023", nil)
+		require.NoError(t, s.Handler(w, req))
+		assert.Contains(t, w.Body.String(), `<link rel="subsection" href="/calendar/2023/03" type="application/atom+xml;profile=opds-catalog;kind=acquisition"></link>`)
+	})
+}
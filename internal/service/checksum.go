@@ -0,0 +1,83 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+type checksum struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+var (
+	checksumsMu sync.Mutex
+	// checksums caches the sha-256 of the books by path, an entry is replaced when the file changes
+	checksums = map[string]checksum{}
+)
+
+// addChecksum adds the sha-256 of the book as an urn:sha256 dc:identifier of the entry
+// when IncludeChecksums is enabled
+func (s OPDS) addChecksum(filePath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	if !s.IncludeChecksums {
+		return builder
+	}
+
+	fi, err := os.Stat(filePath)
+	if err != nil || fi.IsDir() {
+		return builder
+	}
+
+	sum, err := s.fileChecksum(filePath, fi)
+	if err != nil {
+		log.Printf("addChecksum %s err: %s", filePath, err)
+		return builder
+	}
+	return builder.AddIdentifier("urn:sha256:" + sum)
+}
+
+// fileChecksum returns the hex encoded sha-256 of a file, computed once until the file changes
+// and kept in the CacheDir when it is set
+func (s OPDS) fileChecksum(filePath string, fi os.FileInfo) (string, error) {
+	checksumsMu.Lock()
+	cached, ok := checksums[filePath]
+	checksumsMu.Unlock()
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached.sum, nil
+	}
+
+	key := fileKey(filePath, fi)
+	if stored, ok := s.readArtifact(checksumArtifacts, key); ok {
+		sum := string(stored)
+		checksumsMu.Lock()
+		checksums[filePath] = checksum{size: fi.Size(), modTime: fi.ModTime(), sum: sum}
+		checksumsMu.Unlock()
+		return sum, nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	s.writeArtifact(checksumArtifacts, key, []byte(sum))
+
+	checksumsMu.Lock()
+	checksums[filePath] = checksum{size: fi.Size(), modTime: fi.ModTime(), sum: sum}
+	checksumsMu.Unlock()
+	return sum, nil
+}
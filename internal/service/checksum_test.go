@@ -0,0 +1,39 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerChecksums(t *testing.T) {
+	// the sha-256 of testdata/mybook/mybook.epub
+	const want = `<identifier xmlns="http://purl.org/dc/terms/">urn:sha256:42f08e893c697799769816eabfdf5b6b05b0f362e92bb3ba747b8cc2a5a65770</identifier>`
+
+	for name, includeChecksums := range map[string]bool{"disabled": false, "enabled": true} {
+		t.Run(name, func(t *testing.T) {
+			// setup
+			s := service.OPDS{TrustedRoot: "testdata", IncludeChecksums: includeChecksums}
+
+			for _, input := range []string{"/shelf/mybook", "/new"} {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, input, nil)
+
+				// act
+				require.NoError(t, s.Handler(w, req))
+
+				// verify
+				require.Equal(t, http.StatusOK, w.Code)
+				if includeChecksums {
+					assert.Contains(t, w.Body.String(), want)
+				} else {
+					assert.NotContains(t, w.Body.String(), "<identifier")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"archive/zip"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// comicType is the type of the comic books, also of the zips of images with ComicZips
+const comicType = "application/x-cbz"
+
+type comicZip struct {
+	size    int64
+	modTime time.Time
+	comic   bool
+}
+
+var (
+	comicZipsMu sync.Mutex
+	// comicZips caches if the zips are comics by path, an entry is replaced when the file changes
+	comicZips = map[string]comicZip{}
+)
+
+// isComicZip reports if the file in filePath is a zip holding only images when ComicZips is enabled
+func (s OPDS) isComicZip(filePath string) bool {
+	if !s.ComicZips || strings.ToLower(filepath.Ext(filePath)) != ".zip" {
+		return false
+	}
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return false
+	}
+
+	comicZipsMu.Lock()
+	cached, ok := comicZips[filePath]
+	comicZipsMu.Unlock()
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached.comic
+	}
+
+	comic := onlyImages(filePath)
+
+	comicZipsMu.Lock()
+	comicZips[filePath] = comicZip{size: fi.Size(), modTime: fi.ModTime(), comic: comic}
+	comicZipsMu.Unlock()
+	return comic
+}
+
+// onlyImages reports if the zip in filePath holds at least an image and nothing else but folders
+func onlyImages(filePath string) bool {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+
+	images := 0
+	for _, f := range r.File {
+		switch {
+		case f.FileInfo().IsDir():
+		case isImage(strings.ToLower(path.Ext(f.Name))):
+			images++
+		default:
+			return false
+		}
+	}
+	return images > 0
+}
+
+// linkType is the type of the links to the file or folder in filePath, a zip of images is a comic with ComicZips
+// and a file without extension has the type given by Extensionless
+func (s OPDS) linkType(filePath string, pathType int) string {
+	if pathType == pathTypeFile && s.isComicZip(filePath) {
+		return comicType
+	}
+	if pathType == pathTypeFile {
+		if fileType := s.extensionlessType(filePath); fileType != "" {
+			return fileType
+		}
+	}
+	return getType(filePath, pathType)
+}
@@ -0,0 +1,68 @@
+package service_test
+
+import (
+	"archive/zip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerComicZips(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	writeZipFiles(t, filepath.Join(dir, "comic.zip"), "pages/", "pages/001.jpg", "pages/002.PNG")
+	writeZipFiles(t, filepath.Join(dir, "mixed.zip"), "001.jpg", "readme.txt")
+
+	tests := map[string]struct {
+		comicZips bool
+		want      map[string]string
+	}{
+		"enabled":  {comicZips: true, want: map[string]string{"comic.zip": "application/x-cbz", "mixed.zip": "application/zip"}},
+		"disabled": {comicZips: false, want: map[string]string{"comic.zip": "application/zip", "mixed.zip": "application/zip"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, ComicZips: tc.comicZips}
+
+			// act
+			w := httptest.NewRecorder()
+			require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf", nil)))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			for file, wantType := range tc.want {
+				assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/acquisition" href="/shelf/`+file+`" type="`+wantType+`" title="`+file+`"></link>`)
+
+				// act
+				w := httptest.NewRecorder()
+				require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf/"+file, nil)))
+
+				// verify the download has the same type
+				require.Equal(t, http.StatusOK, w.Code)
+				assert.Equal(t, wantType, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// writeZipFiles writes a zip in fPath with empty files of names, the names ending with a slash are folders
+func writeZipFiles(t *testing.T, fPath string, names ...string) {
+	t.Helper()
+	f, err := os.Create(fPath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, name := range names {
+		_, err := zw.Create(name)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+}
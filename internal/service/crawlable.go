@@ -0,0 +1,62 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+const crawlablePath = "/crawlable"
+
+const crawlableRel = "http://opds-spec.org/crawlable"
+
+// crawlablePageSize is the number of books in each page of /crawlable
+const crawlablePageSize = 100
+
+// makeFeedCrawlable lists every book by pages of crawlablePageSize linked with rel="next",
+// for clients syncing the whole catalog. The books are sorted by path so a page only
+// changes when books are added or removed before it.
+func (s OPDS) makeFeedCrawlable(req *http.Request, page int) opds.Feed {
+	feedBuilder := opds.FeedBuilder.
+		ID(req.URL.Path).
+		Title(translate(req, "All books")).
+		Updated(TimeNow()).
+		AddLink(s.startLink())
+
+	if !s.DisableSearch {
+		feedBuilder = feedBuilder.AddLink(s.searchLink())
+	}
+
+	files := s.walkBooks(req)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].filePath < files[j].filePath
+	})
+
+	start := min((page-1)*crawlablePageSize, len(files))
+	end := min(start+crawlablePageSize, len(files))
+
+	if page > 1 {
+		feedBuilder = feedBuilder.AddLink(opds.LinkBuilder.Rel("previous").Href(s.href(fmt.Sprintf("%s?page=%d", crawlablePath, page-1))).Type(acquisitionType).Build())
+	}
+	if end < len(files) {
+		feedBuilder = feedBuilder.AddLink(opds.LinkBuilder.Rel("next").Href(s.href(fmt.Sprintf("%s?page=%d", crawlablePath, page+1))).Type(acquisitionType).Build())
+	}
+
+	for _, file := range files[start:end] {
+		entry := s.makeFileEntry(file, req).
+			Updated(file.fileInfo.ModTime().UTC()).
+			Build()
+
+		for i := range entry.Link {
+			if entry.Link[i].Rel == s.acquisitionRel() {
+				entry.Link[i].Length = uint(file.fileInfo.Size())
+			}
+		}
+
+		feedBuilder = feedBuilder.AddEntry(entry)
+	}
+
+	return feedBuilder.Build()
+}
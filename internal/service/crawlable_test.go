@@ -0,0 +1,64 @@
+package service_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerCrawlable(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "series"), 0o755))
+	var titles []string
+	for i := 0; i < 101; i++ {
+		name := fmt.Sprintf("book %03d.epub", i)
+		titles = append(titles, name)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "series", name), []byte("Fixture"), 0o644))
+	}
+	modTime := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "series", "book 100.epub"), modTime, modTime))
+	s := service.OPDS{TrustedRoot: dir}
+
+	// act
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.NoError(t, s.Handler(w, req))
+
+	// verify the root links to the crawlable feed
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/crawlable" href="/crawlable" type="application/atom+xml;profile=opds-catalog;kind=acquisition"></link>`)
+
+	// act
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/crawlable", nil)
+	require.NoError(t, s.Handler(w, req))
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/atom+xml;profile=opds-catalog;kind=acquisition", w.Header().Get("Content-Type"))
+	assert.Equal(t, titles[:100], entryTitles(t, w.Body.Bytes()))
+	assert.Contains(t, w.Body.String(), `<link rel="next" href="/crawlable?page=2" type="application/atom+xml;profile=opds-catalog;kind=acquisition"></link>`)
+	assert.NotContains(t, w.Body.String(), `rel="previous"`)
+
+	// act
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/crawlable?page=2", nil)
+	require.NoError(t, s.Handler(w, req))
+
+	// verify the books have their size and modification time
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, titles[100:], entryTitles(t, w.Body.Bytes()))
+	assert.Contains(t, w.Body.String(), `<link rel="previous" href="/crawlable?page=1" type="application/atom+xml;profile=opds-catalog;kind=acquisition"></link>`)
+	assert.NotContains(t, w.Body.String(), `rel="next"`)
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/acquisition" href="/shelf/series/book%20100.epub" type="application/epub+zip" title="book 100.epub" length="7"></link>`)
+	assert.Contains(t, w.Body.String(), `<updated>2023-03-01T12:00:00+00:00</updated>`)
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dubyte/dir2opds/internal/epub"
+)
+
+// Report lists the problems found in a catalog by Diagnose
+type Report struct {
+	// Issues are the problems in walk order, empty for a healthy catalog
+	Issues []Issue
+}
+
+// Issue is a problem of a file or folder of the catalog
+type Issue struct {
+	// Path is relative to the TrustedRoot, "" is the root
+	Path    string
+	Problem string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: %s", i.Path, i.Problem)
+}
+
+// Diagnose walks the TrustedRoot of opts building the feed of every folder and
+// reading every book, its epub metadata and its cover like the Handler does.
+// It returns the files that fail, the error is for a root that can't be walked.
+func Diagnose(opts OPDS) (Report, error) {
+	var report Report
+	opts, err := NewOPDS(opts)
+	if err != nil {
+		return report, err
+	}
+
+	err = filepath.WalkDir(opts.TrustedRoot, func(path string, d fs.DirEntry, err error) error {
+		pathRelativeToContentRoot := opts.relativePath(path)
+		issue := func(format string, a ...any) {
+			report.Issues = append(report.Issues, Issue{Path: pathRelativeToContentRoot, Problem: fmt.Sprintf(format, a...)})
+		}
+
+		if err != nil {
+			issue("unreadable: %s", err)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// skip the files the feeds don't list
+		if d.IsDir() && opts.fileShouldBeIgnored(pathRelativeToContentRoot) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && (opts.fileShouldBeIgnored(d.Name()) || !opts.included(d.Name())) {
+			return nil
+		}
+
+		if d.IsDir() {
+			if err := opts.diagnoseFeed(path, pathRelativeToContentRoot); err != nil {
+				issue("feed: %s", err)
+			}
+			return nil
+		}
+
+		if isImage(strings.ToLower(filepath.Ext(d.Name()))) || opts.isBookCover(path) {
+			return nil
+		}
+
+		if err := readable(path); err != nil {
+			issue("unreadable: %s", err)
+			return nil
+		}
+
+		if strings.ToLower(filepath.Ext(d.Name())) == ".epub" {
+			if _, err := epub.ReadMetadata(path); err != nil {
+				issue("malformed epub: %s", err)
+			}
+		}
+
+		if opts.UseCalibreCovers || opts.SidecarMetadata {
+			if c, ok := opts.resolveCover(path); !ok {
+				issue("missing cover")
+			} else if c.localPath != "" {
+				if err := decodableImage(c.localPath); err != nil {
+					issue("unreadable cover: %s", err)
+				}
+			}
+		}
+		return nil
+	})
+	return report, err
+}
+
+// diagnoseFeed builds and marshals the feed of the folder in dirPath
+func (s OPDS) diagnoseFeed(dirPath, pathRelativeToContentRoot string) error {
+	req, err := http.NewRequest(http.MethodGet, "/shelf/"+escapePath(pathRelativeToContentRoot), nil)
+	if err != nil {
+		return err
+	}
+	req.URL.Path = strings.TrimSuffix(req.URL.Path, "/")
+
+	feed, err := s.makeFeedPath(dirPath, req)
+	if err != nil {
+		return err
+	}
+	_, err = xml.Marshal(feed)
+	return err
+}
+
+// readable reports an error when the file in filePath can't be read
+func readable(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// decodableImage reports an error when the image in filePath can't be decoded
+func decodableImage(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, _, err = image.DecodeConfig(f)
+	return err
+}
@@ -0,0 +1,39 @@
+package service_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnose(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "good"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "corrupt"), 0o755))
+	writeEPUB(t, filepath.Join(dir, "good", "good.epub"), `<dc:creator>Someone</dc:creator>`)
+	writeJPEG(t, filepath.Join(dir, "good", "cover.jpg"), 60, 90)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "corrupt", "corrupt.epub"), []byte("Fixture"), 0o644))
+
+	// act
+	report, err := service.Diagnose(service.OPDS{TrustedRoot: dir, UseCalibreCovers: true})
+
+	// verify
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 2)
+	assert.Equal(t, "corrupt/corrupt.epub", report.Issues[0].Path)
+	assert.Contains(t, report.Issues[0].Problem, "malformed epub")
+	assert.Equal(t, service.Issue{Path: "corrupt/corrupt.epub", Problem: "missing cover"}, report.Issues[1])
+}
+
+func TestDiagnoseMissingRoot(t *testing.T) {
+	// act
+	_, err := service.Diagnose(service.OPDS{TrustedRoot: filepath.Join(t.TempDir(), "missing")})
+
+	// verify
+	assert.Error(t, err)
+}
@@ -0,0 +1,427 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dubyte/dir2opds/internal/epub"
+	"github.com/dubyte/dir2opds/opds"
+	"golang.org/x/tools/blog/atom"
+)
+
+// embeddedCoverPathPrefix serves in /cover/<path of the epub> the cover image inside an epub
+const embeddedCoverPathPrefix = "/cover/"
+
+type epubMetadata struct {
+	size     int64
+	modTime  time.Time
+	metadata epub.Metadata
+	// hasCover is true when the package document declares a cover image
+	hasCover bool
+	// readable is false for the epubs whose package document can't be read
+	readable bool
+}
+
+var (
+	epubMetadatasMu sync.Mutex
+	// epubMetadatas caches the metadata of the epubs by path, an entry is replaced when the file changes
+	epubMetadatas = map[string]epubMetadata{}
+)
+
+// readEpubMetadata returns the metadata of the epub in filePath, it returns false when
+// EpubMetadata is disabled, the file is not an epub or its metadata can't be read.
+func (s OPDS) readEpubMetadata(filePath string) (epub.Metadata, bool) {
+	if !s.EpubMetadata || strings.ToLower(filepath.Ext(filePath)) != ".epub" {
+		return epub.Metadata{}, false
+	}
+
+	cached, ok := cachedEpubMetadata(filePath)
+	return cached.metadata, ok
+}
+
+// cachedEpubMetadata returns the metadata of the epub in filePath read from its package document
+// once until the file changes, it returns false when it can't be read
+func cachedEpubMetadata(filePath string) (epubMetadata, bool) {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return epubMetadata{}, false
+	}
+
+	epubMetadatasMu.Lock()
+	cached, ok := epubMetadatas[filePath]
+	epubMetadatasMu.Unlock()
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached, cached.readable
+	}
+
+	pkg, err := epub.ReadPackage(filePath)
+	if err != nil {
+		log.Printf("readEpubMetadata %s err: %s", filePath, err)
+	}
+	cached = epubMetadata{size: fi.Size(), modTime: fi.ModTime(), metadata: pkg.Metadata, hasCover: pkg.Cover.Href != "", readable: err == nil}
+
+	epubMetadatasMu.Lock()
+	epubMetadatas[filePath] = cached
+	epubMetadatasMu.Unlock()
+	return cached, cached.readable
+}
+
+// epubAuthors returns the creators of the epub in filePath, a single creator
+// like "A & B" is split on the AuthorSeparator.
+func (s OPDS) epubAuthors(filePath string) []string {
+	meta, ok := s.readEpubMetadata(filePath)
+	if !ok {
+		return nil
+	}
+
+	if len(meta.Creators) != 1 || s.AuthorSeparator == "" {
+		return meta.Creators
+	}
+
+	var authors []string
+	for _, author := range strings.Split(meta.Creators[0], s.AuthorSeparator) {
+		if author = strings.TrimSpace(author); author != "" {
+			authors = append(authors, author)
+		}
+	}
+	return authors
+}
+
+// addTranslatedTitles adds the titles of a bilingual epub with their language after the main title,
+// an epub with titles in less than two languages keeps only the main title.
+func (s OPDS) addTranslatedTitles(filePath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	meta, ok := s.readEpubMetadata(filePath)
+	if !ok {
+		return builder
+	}
+
+	languages := map[string]bool{}
+	for _, title := range meta.Titles {
+		if title.Lang != "" {
+			languages[title.Lang] = true
+		}
+	}
+	if len(languages) < 2 {
+		return builder
+	}
+
+	for _, title := range meta.Titles {
+		if title.Lang != "" {
+			builder = builder.AddTitle(title.Lang, title.Value)
+		}
+	}
+	return builder
+}
+
+// addRights sets the license of a book from the dc:rights of its epub metadata or the DefaultRights
+func (s OPDS) addRights(filePath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	rights := s.DefaultRights
+	if meta, ok := s.readEpubMetadata(filePath); ok && meta.Rights != "" {
+		rights = meta.Rights
+	}
+	if rights == "" {
+		return builder
+	}
+	return builder.Rights(&atom.Text{Type: "text", Body: rights})
+}
+
+// addEpubID sets the id of the entry of an epub to its dc:identifier so readers keep tracking
+// the book when its file moves, the path based id is kept when the identifier is not an IRI.
+func (s OPDS) addEpubID(filePath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	meta, ok := s.readEpubMetadata(filePath)
+	if !ok {
+		return builder
+	}
+	if id, ok := identifierIRI(meta.Identifier); ok {
+		return builder.ID(id)
+	}
+	return builder
+}
+
+var (
+	uuidPattern = regexp.MustCompile(`^(?i)(urn:)?(uuid:)?([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})$`)
+	isbnPattern = regexp.MustCompile(`^(?i)(urn:)?(isbn:?)?\s*([0-9][0-9-]{8,15}[0-9x])$`)
+	iriPattern  = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:\S+$`)
+)
+
+// identifierIRI returns an identifier as an IRI usable as an atom id: a bare uuid or ISBN is
+// prefixed with urn:uuid: or urn:isbn:, an identifier with a scheme like urn: or http: is kept.
+// It returns false for the other identifiers, like a number of a database.
+func identifierIRI(identifier string) (string, bool) {
+	if m := uuidPattern.FindStringSubmatch(identifier); m != nil {
+		return "urn:uuid:" + strings.ToLower(m[3]), true
+	}
+	if m := isbnPattern.FindStringSubmatch(identifier); m != nil {
+		isbn := strings.ToUpper(strings.ReplaceAll(m[3], "-", ""))
+		if validISBN(isbn) {
+			return "urn:isbn:" + isbn, true
+		}
+	}
+	if iriPattern.MatchString(identifier) {
+		return identifier, true
+	}
+	return "", false
+}
+
+// validISBN reports if isbn, without hyphens, is an ISBN-10 or ISBN-13 with a valid check digit
+func validISBN(isbn string) bool {
+	sum := 0
+	switch len(isbn) {
+	case 10:
+		for i, c := range isbn {
+			digit := int(c - '0')
+			if c == 'X' && i == 9 {
+				digit = 10
+			} else if c < '0' || c > '9' {
+				return false
+			}
+			sum += digit * (10 - i)
+		}
+		return sum%11 == 0
+	case 13:
+		for i, c := range isbn {
+			if c < '0' || c > '9' {
+				return false
+			}
+			sum += int(c-'0') * (1 + 2*(i%2))
+		}
+		return sum%10 == 0
+	}
+	return false
+}
+
+type embeddedCover struct {
+	size     int64
+	modTime  time.Time
+	mimeType string
+	// sum is the sha-256 of the image, empty until it is computed
+	sum string
+}
+
+var (
+	embeddedCoversMu sync.Mutex
+	// embeddedCovers caches the type of the cover inside the epubs by path, "" for the epubs without
+	// one, and not their image, an entry is replaced when the file changes
+	embeddedCovers = map[string]embeddedCover{}
+)
+
+const (
+	// maxEmbeddedCoverBytes is the size of the largest cover image read from an epub
+	maxEmbeddedCoverBytes = 20 << 20
+	// embeddedCoverCacheBytes is the size of the cover images kept in memory without a CacheDir
+	embeddedCoverCacheBytes = 64 << 20
+)
+
+// embeddedCoverContents caches the cover images read from the epubs by their fileKey when the
+// CacheDir is not set, the least recently used are evicted over embeddedCoverCacheBytes
+var embeddedCoverContents = newLRUCache(0, embeddedCoverCacheBytes, func(content []byte) int { return len(content) })
+
+// readEmbeddedCover returns the type of the cover image inside the epub in filePath, it returns false
+// when EpubMetadata is disabled, the file is not an epub or its package document declares no cover.
+// The image is read by embeddedCoverContent.
+func (s OPDS) readEmbeddedCover(filePath string) (string, bool) {
+	if !s.EpubMetadata || strings.ToLower(filepath.Ext(filePath)) != ".epub" {
+		return "", false
+	}
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return "", false
+	}
+
+	embeddedCoversMu.Lock()
+	cached, ok := embeddedCovers[filePath]
+	embeddedCoversMu.Unlock()
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached.mimeType, cached.mimeType != ""
+	}
+
+	mimeType, err := coverType(filePath)
+	if err != nil {
+		log.Printf("readEmbeddedCover %s err: %s", filePath, err)
+	}
+
+	embeddedCoversMu.Lock()
+	embeddedCovers[filePath] = embeddedCover{size: fi.Size(), modTime: fi.ModTime(), mimeType: mimeType}
+	embeddedCoversMu.Unlock()
+	return mimeType, mimeType != ""
+}
+
+// embeddedCoverContent returns the cover image inside the epub in filePath, it is kept in the
+// CacheDir when it is set and in memory otherwise until the epub changes
+func (s OPDS) embeddedCoverContent(filePath string) ([]byte, error) {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fileKey(filePath, fi)
+	if content, _, ok := s.storedCover(key); ok {
+		return content, nil
+	}
+	if s.CacheDir == "" {
+		if content, ok := embeddedCoverContents.get(key); ok {
+			return content, nil
+		}
+	}
+
+	content, mimeType, err := extractCover(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if content == nil {
+		return nil, fmt.Errorf("epub %s has no cover", filePath)
+	}
+	if s.CacheDir == "" {
+		embeddedCoverContents.add(key, content)
+		return content, nil
+	}
+	// the type goes in the first line of the stored cover
+	s.writeArtifact(coverArtifacts, key, append([]byte(mimeType+"\n"), content...))
+	return content, nil
+}
+
+// embeddedCoverChecksum returns the hex encoded sha-256 of the cover image inside the epub in filePath,
+// it is kept with the type of the cover so the image is not read again until the epub changes
+func (s OPDS) embeddedCoverChecksum(filePath string) (string, error) {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	embeddedCoversMu.Lock()
+	cached, ok := embeddedCovers[filePath]
+	embeddedCoversMu.Unlock()
+	if ok && cached.sum != "" && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached.sum, nil
+	}
+
+	content, err := s.embeddedCoverContent(filePath)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		cached.sum = hex.EncodeToString(sum[:])
+		embeddedCoversMu.Lock()
+		embeddedCovers[filePath] = cached
+		embeddedCoversMu.Unlock()
+	}
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// storedCover returns the cover and its type stored in the CacheDir under key
+func (s OPDS) storedCover(key string) ([]byte, string, bool) {
+	stored, ok := s.readArtifact(coverArtifacts, key)
+	if !ok {
+		return nil, "", false
+	}
+	mimeType, content, ok := bytes.Cut(stored, []byte("\n"))
+	return content, string(mimeType), ok
+}
+
+// coverType returns the type of the cover image declared by the package document of the epub
+// in filePath, "" when it declares none
+func coverType(filePath string) (string, error) {
+	pkg, err := epub.ReadPackage(filePath)
+	if err != nil || pkg.Cover.Href == "" {
+		return "", err
+	}
+	return packageCoverType(pkg), nil
+}
+
+func packageCoverType(pkg epub.Package) string {
+	if pkg.Cover.MediaType != "" {
+		return pkg.Cover.MediaType
+	}
+	return mime.TypeByExtension(strings.ToLower(path.Ext(pkg.Cover.Href)))
+}
+
+// extractCover reads the cover image declared by the package document of the epub in filePath,
+// nil when it declares none. Covers over maxEmbeddedCoverBytes are not read.
+func extractCover(filePath string) ([]byte, string, error) {
+	pkg, err := epub.ReadPackage(filePath)
+	if err != nil || pkg.Cover.Href == "" {
+		return nil, "", err
+	}
+
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+
+	f, err := r.Open(pkg.Cover.Href)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(io.LimitReader(f, maxEmbeddedCoverBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(content) > maxEmbeddedCoverBytes {
+		return nil, "", fmt.Errorf("cover %s is larger than %d bytes", pkg.Cover.Href, maxEmbeddedCoverBytes)
+	}
+	return content, packageCoverType(pkg), nil
+}
+
+// embeddedCover returns the cover of mimeType inside the epub in bookPath
+func (s OPDS) embeddedCover(bookPath, mimeType string) cover {
+	_, pathRelativeToContentRoot, _ := strings.Cut(bookPath, s.TrustedRoot+"/")
+	return cover{
+		href:     s.href(embeddedCoverPathPrefix + escapePath(pathRelativeToContentRoot)),
+		mimeType: mimeType,
+		epubPath: bookPath,
+		content:  func() ([]byte, error) { return s.embeddedCoverContent(bookPath) },
+	}
+}
+
+// serveEmbeddedCover serves the cover image inside the epub in urlPath
+func (s OPDS) serveEmbeddedCover(w http.ResponseWriter, req *http.Request, urlPath string) error {
+	bookPath, err := verifyPath(filepath.Join(s.TrustedRoot, strings.TrimPrefix(urlPath, embeddedCoverPathPrefix)), s.TrustedRoot)
+	if err != nil {
+		log.Printf("embedded cover %q err: %s", bookPath, err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	_, pathRelativeToContentRoot, _ := strings.Cut(bookPath, s.TrustedRoot+"/")
+	if s.fileShouldBeIgnored(pathRelativeToContentRoot) || !s.included(filepath.Base(bookPath)) || s.tooSmall(bookPath) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	if s.accessDenied(w, req, pathRelativeToContentRoot) {
+		return nil
+	}
+
+	mimeType, ok := s.readEmbeddedCover(bookPath)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	content, err := s.embeddedCoverContent(bookPath)
+	if err != nil {
+		log.Printf("embedded cover %q err: %s", bookPath, err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	return serveCover(w, req, cover{mimeType: mimeType, content: func() ([]byte, error) { return content, nil }})
+}
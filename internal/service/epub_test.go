@@ -0,0 +1,306 @@
+package service_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerEpubAuthors(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	writeEPUB(t, filepath.Join(dir, "good omens.epub"), `<dc:creator>Terry Pratchett</dc:creator><dc:creator>Neil Gaiman</dc:creator>`)
+	writeEPUB(t, filepath.Join(dir, "joined.epub"), `<dc:creator>Terry Pratchett &amp; Neil Gaiman</dc:creator>`)
+
+	tests := map[string]struct {
+		book      string
+		separator string
+		want      []string
+	}{
+		"several creators":              {book: "good omens.epub", separator: "&", want: []string{"Terry Pratchett", "Neil Gaiman"}},
+		"joined creators":               {book: "joined.epub", separator: "&", want: []string{"Terry Pratchett", "Neil Gaiman"}},
+		"joined creators without split": {book: "joined.epub", separator: "", want: []string{"Terry Pratchett &amp; Neil Gaiman"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, EpubMetadata: true, AuthorSeparator: tc.separator}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/search?q="+strings.TrimSuffix(tc.book, ".epub")[:4], nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify there is an author element per author
+			require.Equal(t, http.StatusOK, w.Code)
+			var authors []string
+			for _, author := range tc.want {
+				authors = append(authors, fmt.Sprintf("<author>\n              <name>%s</name>\n          </author>", author))
+			}
+			assert.Contains(t, w.Body.String(), strings.Join(authors, "\n          "))
+			assert.Equal(t, len(tc.want), strings.Count(w.Body.String(), "<author>"))
+		})
+	}
+}
+
+func TestHandlerEpubTranslatedTitles(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	writeEPUB(t, filepath.Join(dir, "prince.epub"), `<dc:title xml:lang="en">The Little Prince</dc:title><dc:title xml:lang="fr">Le Petit Prince</dc:title>`)
+	writeEPUB(t, filepath.Join(dir, "english.epub"), `<dc:title xml:lang="en">Only English</dc:title>`)
+
+	tests := map[string]struct {
+		epubMetadata bool
+		book         string
+		want         string
+	}{
+		"bilingual": {epubMetadata: true, book: "prince.epub", want: `<title>prince.epub</title>
+          <title xml:lang="en">The Little Prince</title>
+          <title xml:lang="fr">Le Petit Prince</title>`},
+		"one language":   {epubMetadata: true, book: "english.epub", want: `<title>english.epub</title>`},
+		"metadata unset": {epubMetadata: false, book: "prince.epub", want: `<title>prince.epub</title>`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, EpubMetadata: tc.epubMetadata}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/search?q="+strings.TrimSuffix(tc.book, ".epub"), nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), tc.want)
+			assert.Equal(t, strings.Count(tc.want, "<title"), strings.Count(w.Body.String(), "<title")-1, "titles of the entry, without the title of the feed")
+		})
+	}
+}
+
+func TestHandlerEpubRights(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	writeEPUB(t, filepath.Join(dir, "licensed.epub"), `<dc:rights>CC BY 4.0</dc:rights>`)
+	writeEPUB(t, filepath.Join(dir, "unlicensed.epub"), `<dc:title>Unlicensed</dc:title>`)
+
+	tests := map[string]struct {
+		s    service.OPDS
+		want map[string]string
+	}{
+		"from the metadata": {
+			s:    service.OPDS{TrustedRoot: dir, EpubMetadata: true},
+			want: map[string]string{"licensed.epub": `<rights type="text">CC BY 4.0</rights>`, "unlicensed.epub": ""},
+		},
+		"from the default": {
+			s:    service.OPDS{TrustedRoot: dir, EpubMetadata: true, DefaultRights: "Public domain"},
+			want: map[string]string{"licensed.epub": `<rights type="text">CC BY 4.0</rights>`, "unlicensed.epub": `<rights type="text">Public domain</rights>`},
+		},
+		"without metadata": {
+			s:    service.OPDS{TrustedRoot: dir, DefaultRights: "Public domain"},
+			want: map[string]string{"licensed.epub": `<rights type="text">Public domain</rights>`, "unlicensed.epub": `<rights type="text">Public domain</rights>`},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+			// act
+			require.NoError(t, tc.s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			for _, entry := range strings.Split(w.Body.String(), "<entry>")[1:] {
+				for book, want := range tc.want {
+					if !strings.Contains(entry, "<id>/shelf/"+book+"</id>") {
+						continue
+					}
+					if want == "" {
+						assert.NotContains(t, entry, "<rights")
+					} else {
+						assert.Contains(t, entry, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerEpubEmbeddedCover(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	var cover bytes.Buffer
+	require.NoError(t, png.Encode(&cover, gradient(600, 900)))
+
+	f, err := os.Create(filepath.Join(dir, "book.epub"))
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for name, content := range map[string][]byte{
+		"META-INF/container.xml": []byte(`<container><rootfiles><rootfile full-path="content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`),
+		"content.opf":            []byte(`<package><manifest><item id="front" href="images/front.png" media-type="image/png" properties="cover-image"/></manifest></package>`),
+		"images/front.png":       cover.Bytes(),
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	s := service.OPDS{TrustedRoot: dir, EpubMetadata: true, Thumbnails: true}
+
+	// act
+	w := httptest.NewRecorder()
+	require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf", nil)))
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/image" href="/cover/book.epub" type="image/png"></link>`)
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/image/thumbnail" href="/thumbnail/book.epub" type="image/jpeg"></link>`)
+
+	// act
+	w = httptest.NewRecorder()
+	require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/cover/book.epub", nil)))
+
+	// verify the cover is served from the epub
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, cover.Bytes(), w.Body.Bytes())
+
+	// act
+	w = httptest.NewRecorder()
+	require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/thumbnail/book.epub", nil)))
+
+	// verify the thumbnail is resized from it
+	require.Equal(t, http.StatusOK, w.Code)
+	img, err := jpeg.Decode(w.Body)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 200, 300), img.Bounds())
+
+	// act
+	fi, err := os.Stat(filepath.Join(dir, "book.epub"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "book.epub"), make([]byte, fi.Size()), 0o644))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "book.epub"), fi.ModTime(), fi.ModTime()))
+	w = httptest.NewRecorder()
+	require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/cover/book.epub", nil)))
+
+	// verify the cover is kept in memory until the size or the modification time of the epub change
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, cover.Bytes(), w.Body.Bytes())
+}
+
+func TestHandlerEpubEmbeddedCoverTooLarge(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "book.epub"))
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for name, content := range map[string][]byte{
+		"META-INF/container.xml": []byte(`<container><rootfiles><rootfile full-path="content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`),
+		"content.opf":            []byte(`<package><manifest><item id="front" href="front.png" media-type="image/png" properties="cover-image"/></manifest></package>`),
+		// compressed to a few kilobytes, the cover is larger than the limit once read
+		"front.png": make([]byte, 21<<20),
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+	s := service.OPDS{TrustedRoot: dir, EpubMetadata: true}
+
+	// act
+	w := httptest.NewRecorder()
+	require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/cover/book.epub", nil)))
+
+	// verify
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandlerEpubIdentifier(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	writeEPUB(t, filepath.Join(dir, "uuid.epub"), `<dc:identifier>urn:uuid:1b4e28ba-2fa1-11d2-883f-0016d3cca427</dc:identifier>`)
+	writeEPUB(t, filepath.Join(dir, "bare uuid.epub"), `<dc:identifier>1B4E28BA-2FA1-11D2-883F-0016D3CCA428</dc:identifier>`)
+	writeEPUB(t, filepath.Join(dir, "isbn.epub"), `<dc:identifier>978-0-306-40615-7</dc:identifier>`)
+	writeEPUB(t, filepath.Join(dir, "url.epub"), `<dc:identifier>https://example.com/books/1</dc:identifier>`)
+	writeEPUB(t, filepath.Join(dir, "number.epub"), `<dc:identifier>1234567890</dc:identifier>`)
+	writeEPUB(t, filepath.Join(dir, "none.epub"), `<dc:title>None</dc:title>`)
+
+	tests := map[string]struct {
+		s    service.OPDS
+		want map[string]string
+	}{
+		"with metadata": {
+			s: service.OPDS{TrustedRoot: dir, EpubMetadata: true},
+			want: map[string]string{
+				"uuid.epub":      "urn:uuid:1b4e28ba-2fa1-11d2-883f-0016d3cca427",
+				"bare uuid.epub": "urn:uuid:1b4e28ba-2fa1-11d2-883f-0016d3cca428",
+				"isbn.epub":      "urn:isbn:9780306406157",
+				"url.epub":       "https://example.com/books/1",
+				"number.epub":    "/shelf/number.epub",
+				"none.epub":      "/shelf/none.epub",
+			},
+		},
+		"without metadata": {
+			s:    service.OPDS{TrustedRoot: dir},
+			want: map[string]string{"uuid.epub": "/shelf/uuid.epub", "isbn.epub": "/shelf/isbn.epub"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, input := range []string{"/shelf", "/new"} {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, input, nil)
+
+				// act
+				require.NoError(t, tc.s.Handler(w, req))
+
+				// verify
+				require.Equal(t, http.StatusOK, w.Code)
+				for book, want := range tc.want {
+					assert.Contains(t, w.Body.String(), "<title>"+book+"</title>\n          <id>"+want+"</id>", input)
+				}
+			}
+		})
+	}
+}
+
+// writeEPUB writes an epub in fPath with the given elements in the metadata of its package document
+func writeEPUB(t *testing.T, fPath string, metadata string) {
+	t.Helper()
+	f, err := os.Create(fPath)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, content := range map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`,
+		"content.opf":            `<package xmlns:dc="http://purl.org/dc/elements/1.1/"><metadata>` + metadata + `</metadata></package>`,
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+}
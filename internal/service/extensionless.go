@@ -0,0 +1,69 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// the values of Extensionless
+const (
+	extensionlessSkip    = "skip"
+	extensionlessDefault = "default"
+	extensionlessSniff   = "sniff"
+)
+
+// defaultExtensionlessType is the type of the files without extension when ExtensionlessType is empty
+const defaultExtensionlessType = "application/octet-stream"
+
+// sniffLength is the number of bytes read to detect the type of a file, see http.DetectContentType
+const sniffLength = 512
+
+// hasExtension reports if the file name has an extension, "README" or ".profile" have none
+func hasExtension(name string) bool {
+	name = filepath.Base(name)
+	ext := filepath.Ext(name)
+	return ext != "" && ext != name
+}
+
+// extensionlessType returns the type of the file without extension in filePath according to
+// Extensionless, "" when the file has an extension or they are listed without type
+func (s OPDS) extensionlessType(filePath string) string {
+	if hasExtension(filePath) {
+		return ""
+	}
+
+	fallback := s.ExtensionlessType
+	if fallback == "" {
+		fallback = defaultExtensionlessType
+	}
+
+	switch s.Extensionless {
+	case extensionlessDefault:
+		return fallback
+	case extensionlessSniff:
+		if t := sniffType(filePath); t != defaultExtensionlessType {
+			return t
+		}
+		return fallback
+	}
+	return ""
+}
+
+// sniffType detects the type of the file in filePath from its first bytes,
+// application/octet-stream when it is unknown or the file can't be read
+func sniffType(filePath string) string {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return defaultExtensionlessType
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLength)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return defaultExtensionlessType
+	}
+	return http.DetectContentType(buf[:n])
+}
@@ -0,0 +1,88 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerExtensionless(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "manual"), []byte("%PDF-1.4\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "blob"), []byte{0x00, 0x01, 0x02, 0xff}, 0o644))
+
+	tests := map[string]struct {
+		s      service.OPDS
+		titles []string
+		// types are the types of the links and downloads of the files without extension
+		types map[string]string
+	}{
+		"listed without type": {
+			s:      service.OPDS{TrustedRoot: dir},
+			titles: []string{"blob", "manual", "mybook.epub"},
+			types:  map[string]string{"blob": "", "manual": ""},
+		},
+		"skip": {
+			s:      service.OPDS{TrustedRoot: dir, Extensionless: "skip"},
+			titles: []string{"mybook.epub"},
+		},
+		"default type": {
+			s:      service.OPDS{TrustedRoot: dir, Extensionless: "default"},
+			titles: []string{"blob", "manual", "mybook.epub"},
+			types:  map[string]string{"blob": "application/octet-stream", "manual": "application/octet-stream"},
+		},
+		"custom default type": {
+			s:      service.OPDS{TrustedRoot: dir, Extensionless: "default", ExtensionlessType: "application/x-mobipocket-ebook"},
+			titles: []string{"blob", "manual", "mybook.epub"},
+			types:  map[string]string{"blob": "application/x-mobipocket-ebook", "manual": "application/x-mobipocket-ebook"},
+		},
+		"sniff": {
+			s:      service.OPDS{TrustedRoot: dir, Extensionless: "sniff", ExtensionlessType: "application/x-mobipocket-ebook"},
+			titles: []string{"blob", "manual", "mybook.epub"},
+			types:  map[string]string{"blob": "application/x-mobipocket-ebook", "manual": "application/pdf"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+			// act
+			err := tc.s.Handler(w, req)
+
+			// verify
+			require.NoError(t, err)
+			assert.Equal(t, tc.titles, entryTitles(t, w.Body.Bytes()))
+			for file, wantType := range tc.types {
+				if wantType == "" {
+					assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/acquisition" href="/shelf/`+file+`" title="`+file+`"></link>`)
+					continue
+				}
+				assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/acquisition" href="/shelf/`+file+`" type="`+wantType+`" title="`+file+`"></link>`)
+
+				w := httptest.NewRecorder()
+				require.NoError(t, tc.s.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf/"+file, nil)))
+				assert.Equal(t, wantType, w.Header().Get("Content-Type"), "the download has the same type")
+			}
+
+			if tc.s.Extensionless == "skip" {
+				w := httptest.NewRecorder()
+				require.NoError(t, tc.s.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf/manual", nil)))
+				assert.Equal(t, http.StatusNotFound, w.Code)
+			}
+		})
+	}
+
+	t.Run("unknown policy", func(t *testing.T) {
+		assert.Error(t, service.OPDS{TrustedRoot: dir, Extensionless: "guess"}.Validate())
+	})
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+// externalReaderHref is the placeholder of the ExternalReaderTemplate replaced with the download url
+const externalReaderHref = "{href}"
+
+// addExternalReader links the entry of a book to the ExternalReaderTemplate opening its download url
+func (s OPDS) addExternalReader(req *http.Request, filePath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	if s.ExternalReaderTemplate == "" {
+		return builder
+	}
+
+	rel := s.ExternalReaderRel
+	if rel == "" {
+		rel = "alternate"
+	}
+
+	download := absoluteURL(req, s.href("/shelf/"+escapePath(s.relativePath(filePath))))
+	return builder.AddLink(opds.LinkBuilder.
+		Rel(rel).
+		Title(translate(req, "Read online")).
+		Href(strings.ReplaceAll(s.ExternalReaderTemplate, externalReaderHref, url.QueryEscape(download))).
+		Type("text/html").
+		Build())
+}
+
+// absoluteURL returns href with the scheme and host of req when it is a path
+func absoluteURL(req *http.Request, href string) string {
+	if !strings.HasPrefix(href, "/") {
+		return href
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + href
+}
@@ -0,0 +1,64 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerExternalReader(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "my folder"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "my folder", "my book.epub"), []byte("Fixture"), 0o644))
+
+	tests := map[string]struct {
+		s     service.OPDS
+		input string
+		want  string
+	}{
+		"external reader": {
+			s:     service.OPDS{TrustedRoot: dir, ExternalReaderTemplate: "https://reader.example/open?url={href}"},
+			input: "/shelf/my%20folder",
+			want:  `<link rel="alternate" href="https://reader.example/open?url=http%3A%2F%2Fexample.com%2Fshelf%2Fmy%2520folder%2Fmy%2520book.epub" type="text/html" title="Read online"></link>`,
+		},
+		"custom rel and base path": {
+			s:     service.OPDS{TrustedRoot: dir, BasePath: "/opds", ExternalReaderTemplate: "https://reader.example/#{href}", ExternalReaderRel: "http://example.com/rel/reader"},
+			input: "/opds/shelf/my%20folder",
+			want:  `<link rel="http://example.com/rel/reader" href="https://reader.example/#http%3A%2F%2Fexample.com%2Fopds%2Fshelf%2Fmy%2520folder%2Fmy%2520book.epub" type="text/html" title="Read online"></link>`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			err := tc.s.Handler(w, req)
+
+			// verify
+			require.NoError(t, err)
+			assert.Contains(t, w.Body.String(), tc.want)
+		})
+	}
+
+	t.Run("without external reader", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/shelf/my%20folder", nil)
+
+		// act
+		err := s.Handler(w, req)
+
+		// verify
+		require.NoError(t, err)
+		assert.NotContains(t, w.Body.String(), "text/html")
+	})
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// FeedCache keeps the feeds of the folders last served, the least recently used are evicted
+// when it holds more than its maximum number of feeds or bytes. A feed is served from the cache
+// while the listing of its folder, the names, sizes and modification times, doesn't change.
+type FeedCache struct {
+	maxEntries int
+	maxBytes   int
+
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cachedFeed struct {
+	key      string
+	contents string
+	header   http.Header
+	body     []byte
+}
+
+// NewFeedCache returns a cache of at most maxEntries feeds and maxBytes bytes of feeds,
+// zero means no limit
+func NewFeedCache(maxEntries, maxBytes int) *FeedCache {
+	return &FeedCache{maxEntries: maxEntries, maxBytes: maxBytes, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get returns the feed of key if it was cached with the same contents
+func (c *FeedCache) get(key, contents string) (cachedFeed, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return cachedFeed{}, false
+	}
+	feed := e.Value.(cachedFeed)
+	if feed.contents != contents {
+		c.remove(e)
+		return cachedFeed{}, false
+	}
+	c.order.MoveToFront(e)
+	return feed, true
+}
+
+// add caches the feed, evicting the least recently used feeds over the limits
+func (c *FeedCache) add(feed cachedFeed) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[feed.key]; ok {
+		c.remove(e)
+	}
+	c.entries[feed.key] = c.order.PushFront(feed)
+	c.size += len(feed.body)
+
+	for c.order.Len() > 0 && ((c.maxEntries > 0 && c.order.Len() > c.maxEntries) || (c.maxBytes > 0 && c.size > c.maxBytes)) {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *FeedCache) remove(e *list.Element) {
+	feed := c.order.Remove(e).(cachedFeed)
+	delete(c.entries, feed.key)
+	c.size -= len(feed.body)
+}
+
+// feedCacheKey identifies the feed served for req, the request headers changing the feed are part of it
+func (s OPDS) feedCacheKey(req *http.Request) string {
+	user, _, _ := req.BasicAuth()
+	return fmt.Sprintf("%s?%s\x00%s\x00%+v\x00%t\x00%s\x00%s", req.URL.EscapedPath(), req.URL.RawQuery, language(req), s.quirks(req), s.HTML && wantsHTML(req), s.thumbnailType(req), user)
+}
+
+// folderContents hashes the names, sizes and modification times of the files in dirPath and in its
+// subfolders up to depth levels down, in all of them when depth is negative. It changes when a file
+// is added, removed or modified
+func folderContents(dirPath string, depth int) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if path == dirPath {
+			return err
+		}
+		// the feeds leave out the subfolders that can't be read as well
+		if err != nil {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dirPath, path)
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", rel, info.Size(), info.ModTime().UnixNano())
+
+		if d.IsDir() && depth >= 0 && strings.Count(rel, string(filepath.Separator)) >= depth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(h.Sum(nil)), nil
+}
+
+// feedDepth is how many levels of subfolders the feed of a folder is built from: the updated times
+// of its subfolders come from their contents, or from every level below with FolderUpdatedDeep,
+// and their mosaics from the covers of the folders they hold
+func (s OPDS) feedDepth() int {
+	switch {
+	case s.FolderUpdated && s.FolderUpdatedDeep:
+		return -1
+	case s.mosaicsEnabled():
+		return 2
+	case s.FolderUpdated:
+		return 1
+	}
+	return 0
+}
+
+// serveFolderFeed serves the feed of the folder in fPath from the FeedCache, it is built and
+// cached when it is missing or the folder changed
+func (s OPDS) serveFolderFeed(w http.ResponseWriter, req *http.Request, fPath, contentType string, build func(req *http.Request) (any, error)) error {
+	if s.FeedCache == nil || s.NoCache || s.BlockFunc != nil {
+		return s.serveBuiltFeed(w, req, contentType, build)
+	}
+
+	contents, err := folderContents(fPath, s.feedDepth())
+	if err != nil {
+		return s.serveBuiltFeed(w, req, contentType, build)
+	}
+
+	key := s.feedCacheKey(req)
+	if feed, ok := s.FeedCache.get(key, contents); ok {
+		for name, values := range feed.header {
+			w.Header()[name] = slices.Clone(values)
+		}
+		http.ServeContent(w, req, "", TimeNow(), bytes.NewReader(feed.body))
+		return nil
+	}
+
+	rec := &feedRecorder{ResponseWriter: w, status: http.StatusOK}
+	if err := s.serveBuiltFeed(rec, req, contentType, build); err != nil {
+		return err
+	}
+
+	// only the whole feeds are cached, not the answers to HEAD, conditional or range requests
+	if rec.status == http.StatusOK && req.Method == http.MethodGet && req.Header.Get("Range") == "" && req.Header.Get("If-Modified-Since") == "" && req.Header.Get("If-None-Match") == "" {
+		header := http.Header{}
+		for _, name := range []string{"Content-Type", "Vary"} {
+			if values := w.Header().Values(name); len(values) > 0 {
+				header[name] = values
+			}
+		}
+		s.FeedCache.add(cachedFeed{key: key, contents: contents, header: header, body: rec.body.Bytes()})
+	}
+	return nil
+}
+
+// feedRecorder writes a response and keeps a copy of its status and body
+type feedRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *feedRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *feedRecorder) Write(p []byte) (int, error) {
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
@@ -0,0 +1,178 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerFeedCache(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for _, folder := range []string{"a", "b"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, folder), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, folder, "mybook.epub"), []byte("Fixture"), 0o644))
+	}
+
+	// the feeds served from the cache don't check the authorization of their entries again
+	var builds atomic.Int32
+	authorize := func(user, relPath string) bool {
+		if filepath.Ext(relPath) == ".epub" {
+			builds.Add(1)
+		}
+		return true
+	}
+	get := func(t *testing.T, s service.OPDS, urlPath, acceptLanguage string) (string, bool) {
+		t.Helper()
+		before := builds.Load()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, urlPath, nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		require.NoError(t, s.Handler(w, req))
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String(), builds.Load() == before
+	}
+
+	t.Run("hit and miss", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir, Authorize: authorize, FeedCache: service.NewFeedCache(10, 0)}
+
+		// act
+		first, hit := get(t, s, "/shelf/a", "")
+		assert.False(t, hit)
+		second, hit := get(t, s, "/shelf/a", "")
+
+		// verify
+		assert.True(t, hit)
+		assert.Equal(t, first, second)
+
+		_, hit = get(t, s, "/shelf/a", "fr")
+		assert.False(t, hit, "the feed in another language is another feed")
+	})
+
+	t.Run("unknown query params", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir, Authorize: authorize, FeedCache: service.NewFeedCache(10, 0)}
+		first, _ := get(t, s, "/shelf/a", "")
+
+		// act
+		second, hit := get(t, s, "/shelf/a?_=12345", "")
+
+		// verify
+		assert.True(t, hit, "the unknown params are dropped from the cache key")
+		assert.Equal(t, first, second)
+
+		_, hit = get(t, s, "/shelf/a?group=EPUB", "")
+		assert.False(t, hit, "the feed of a group is another feed")
+
+		s.QueryParams = []string{"_"}
+		_, hit = get(t, s, "/shelf/a?_=12345", "")
+		assert.False(t, hit, "the configured params are kept")
+	})
+
+	t.Run("invalidated when the folder changes", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook.epub"), []byte("Fixture"), 0o644))
+		s := service.OPDS{TrustedRoot: dir, Authorize: authorize, FeedCache: service.NewFeedCache(10, 0)}
+		_, _ = get(t, s, "/shelf", "")
+
+		// act
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "other.epub"), []byte("Fixture"), 0o644))
+		body, hit := get(t, s, "/shelf", "")
+
+		// verify
+		assert.False(t, hit)
+		assert.Contains(t, body, "other.epub")
+
+		// act
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "other.epub"), []byte("Fixture, second edition"), 0o644))
+		_, hit = get(t, s, "/shelf", "")
+
+		// verify
+		assert.False(t, hit)
+	})
+
+	t.Run("invalidated when a subfolder changes", func(t *testing.T) {
+		tests := map[string]struct {
+			opds   service.OPDS
+			change string
+		}{
+			"updated time":      {opds: service.OPDS{FolderUpdated: true}, change: "series/mybook.epub"},
+			"deep updated time": {opds: service.OPDS{FolderUpdated: true, FolderUpdatedDeep: true}, change: "series/volume/mybook.epub"},
+			"mosaic":            {opds: service.OPDS{UseCalibreCovers: true, Mosaics: true}, change: "series/volume/cover.jpg"},
+		}
+
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				// setup
+				dir := t.TempDir()
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, "series", "volume"), 0o755))
+				old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+				for _, path := range []string{"series/mybook.epub", "series/volume/mybook.epub", "series/volume", "series"} {
+					if filepath.Ext(path) == ".epub" {
+						require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte("Fixture"), 0o644))
+					}
+					require.NoError(t, os.Chtimes(filepath.Join(dir, path), old, old))
+				}
+				s := tc.opds
+				s.TrustedRoot, s.FeedCache = dir, service.NewFeedCache(10, 0)
+				before, _ := get(t, s, "/shelf", "")
+
+				// act
+				if filepath.Ext(tc.change) == ".jpg" {
+					writeJPEG(t, filepath.Join(dir, tc.change), 60, 90)
+				} else {
+					require.NoError(t, os.WriteFile(filepath.Join(dir, tc.change), []byte("Fixture, second edition"), 0o644))
+				}
+				changed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+				require.NoError(t, os.Chtimes(filepath.Join(dir, tc.change), changed, changed))
+				after, _ := get(t, s, "/shelf", "")
+
+				// verify
+				assert.NotEqual(t, before, after)
+			})
+		}
+	})
+
+	t.Run("least recently used evicted", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir, Authorize: authorize, FeedCache: service.NewFeedCache(1, 0)}
+		_, _ = get(t, s, "/shelf/a", "")
+		_, _ = get(t, s, "/shelf/b", "")
+
+		// act
+		_, hitB := get(t, s, "/shelf/b", "")
+		_, hitA := get(t, s, "/shelf/a", "")
+
+		// verify
+		assert.True(t, hitB)
+		assert.False(t, hitA)
+	})
+
+	t.Run("memory budget", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir, Authorize: authorize, FeedCache: service.NewFeedCache(0, 10)}
+		_, _ = get(t, s, "/shelf/a", "")
+
+		// act
+		_, hit := get(t, s, "/shelf/a", "")
+
+		// verify a feed larger than the budget is not kept
+		assert.False(t, hit)
+	})
+
+	t.Run("bypassed with no cache", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir, Authorize: authorize, NoCache: true, FeedCache: service.NewFeedCache(10, 0)}
+		_, _ = get(t, s, "/shelf/a", "")
+
+		// act
+		_, hit := get(t, s, "/shelf/a", "")
+
+		// verify
+		assert.False(t, hit)
+	})
+}
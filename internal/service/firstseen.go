@@ -0,0 +1,45 @@
+package service
+
+import (
+	"os"
+	"time"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+// addFirstSeen sets the modification time of the book as the updated date of the entry and
+// the time it was first listed as its published date when FirstSeen is enabled.
+// The first listings are kept in the CacheDir, without it both dates are the modification time.
+func (s OPDS) addFirstSeen(filePath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	if !s.FirstSeen {
+		return builder
+	}
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return builder
+	}
+
+	return builder.
+		Published(s.firstSeen(filePath, fi.ModTime()).UTC()).
+		Updated(fi.ModTime().UTC())
+}
+
+// firstSeen returns the time the book in filePath was first listed, recording now when it is new.
+// It is keyed by the path of the book alone so the date survives the changes of the file.
+func (s OPDS) firstSeen(filePath string, modTime time.Time) time.Time {
+	if s.CacheDir == "" {
+		return modTime
+	}
+
+	key := artifactKey(s.relativePath(filePath))
+	if content, ok := s.readArtifact(firstSeenArtifacts, key); ok {
+		if seen, err := time.Parse(time.RFC3339Nano, string(content)); err == nil {
+			return seen
+		}
+	}
+
+	seen := time.Now()
+	s.writeArtifact(firstSeenArtifacts, key, []byte(seen.Format(time.RFC3339Nano)))
+	return seen
+}
@@ -0,0 +1,104 @@
+package service_test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerFirstSeen(t *testing.T) {
+	// setup
+	modified := time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC)
+	library := func(t *testing.T) string {
+		t.Helper()
+		dir := t.TempDir()
+		book := filepath.Join(dir, "mybook.epub")
+		require.NoError(t, os.WriteFile(book, []byte("Fixture"), 0o644))
+		require.NoError(t, os.Chtimes(book, modified, modified))
+		return dir
+	}
+
+	// bookDates returns the published and updated dates of the books by title
+	bookDates := func(t *testing.T, s service.OPDS) map[string][2]string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf", nil)))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var feed struct {
+			Entry []struct {
+				Title     string `xml:"title"`
+				Published string `xml:"published"`
+				Updated   string `xml:"updated"`
+			} `xml:"entry"`
+		}
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &feed))
+		dates := map[string][2]string{}
+		for _, entry := range feed.Entry {
+			dates[entry.Title] = [2]string{entry.Published, entry.Updated}
+		}
+		return dates
+	}
+
+	t.Run("first seen index", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: library(t), FirstSeen: true, CacheDir: t.TempDir()}
+		before := time.Now().Truncate(time.Second)
+		first := bookDates(t, s)
+
+		// act
+		second := bookDates(t, s)
+
+		// verify
+		seen, err := time.Parse(time.RFC3339, second["mybook.epub"][0])
+		require.NoError(t, err)
+		assert.Equal(t, first["mybook.epub"][0], second["mybook.epub"][0], "published is the first time the book was listed")
+		assert.False(t, seen.Before(before), "the book was listed at %s, after %s", seen, before)
+		assert.Equal(t, "2023-05-06T07:08:09+00:00", second["mybook.epub"][1], "updated is the modification time")
+	})
+
+	t.Run("books found later", func(t *testing.T) {
+		dir := library(t)
+		s := service.OPDS{TrustedRoot: dir, FirstSeen: true, CacheDir: t.TempDir()}
+		bookDates(t, s)
+		// the dates of the feeds have a precision of a second
+		time.Sleep(1100 * time.Millisecond)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "other.epub"), []byte("Fixture"), 0o644))
+		require.NoError(t, os.Chtimes(filepath.Join(dir, "other.epub"), modified, modified))
+
+		// act
+		dates := bookDates(t, s)
+
+		// verify
+		first, err := time.Parse(time.RFC3339, dates["mybook.epub"][0])
+		require.NoError(t, err)
+		later, err := time.Parse(time.RFC3339, dates["other.epub"][0])
+		require.NoError(t, err)
+		assert.True(t, later.After(first), "%s is not after %s", later, first)
+	})
+
+	t.Run("without index", func(t *testing.T) {
+		// act
+		dates := bookDates(t, service.OPDS{TrustedRoot: library(t), FirstSeen: true})
+
+		// verify
+		require.Contains(t, dates, "mybook.epub")
+		assert.Equal(t, [2]string{"2023-05-06T07:08:09+00:00", "2023-05-06T07:08:09+00:00"}, dates["mybook.epub"])
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		// act
+		dates := bookDates(t, service.OPDS{TrustedRoot: library(t), CacheDir: t.TempDir()})
+
+		// verify
+		require.Contains(t, dates, "mybook.epub")
+		assert.Equal(t, [2]string{}, dates["mybook.epub"])
+	})
+}
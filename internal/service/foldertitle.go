@@ -0,0 +1,27 @@
+package service
+
+import "path/filepath"
+
+// the values of FolderInTitles
+const (
+	folderInTitlesPrefix = "prefix"
+	folderInTitlesSuffix = "suffix"
+)
+
+// folderTitleSeparator separates the title of a book and the name of its folder
+const folderTitleSeparator = " — "
+
+// folderTitle returns the title of the book in filePath qualified with the name of its folder
+// according to FolderInTitles, like "Tolkien — The Hobbit.epub". The books directly in the
+// TrustedRoot keep their title.
+func (s OPDS) folderTitle(filePath, title string) string {
+	if s.FolderInTitles == "" || filepath.Dir(filePath) == s.TrustedRoot {
+		return title
+	}
+
+	folder := filepath.Base(filepath.Dir(filePath))
+	if s.FolderInTitles == folderInTitlesSuffix {
+		return title + folderTitleSeparator + folder
+	}
+	return folder + folderTitleSeparator + title
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/dubyte/dir2opds/opds"
+	"golang.org/x/tools/blog/atom"
+)
+
+// the values of GroupBy
+const (
+	groupByFormat = "format"
+	groupByLetter = "letter"
+)
+
+// collectionRel is the rel of the link of an entry to the feed of its group, see
+// https://specs.opds.io/opds-1.2#grouping-entries
+const collectionRel = "collection"
+
+// groupParam is the query param of the feed of a group
+const groupParam = "group"
+
+// groupKey returns the group of the book named name with the entry according to GroupBy:
+// its format like "EPUB" or the first letter of its title, "#" for a title not starting with a letter.
+// It returns "" for a book without group.
+func (s OPDS) groupKey(name string, entry opds.Entry) string {
+	switch s.GroupBy {
+	case groupByFormat:
+		return strings.ToUpper(bookFormat(name))
+	case groupByLetter:
+		for _, r := range entry.Title {
+			if unicode.IsLetter(r) {
+				return string(unicode.ToUpper(r))
+			}
+			return "#"
+		}
+	}
+	return ""
+}
+
+// addGroup links the entry of the book named name in the folder in fpath to the feed of its group
+func (s OPDS) addGroup(fpath, name string, entry opds.Entry) opds.Entry {
+	key := s.groupKey(name, entry)
+	if key == "" {
+		return entry
+	}
+
+	href := strings.TrimSuffix("/shelf/"+escapePath(s.relativePath(fpath)), "/") + "?" + groupParam + "=" + url.QueryEscape(key)
+	entry.Link = append(entry.Link, opds.Link{Link: atom.Link{Rel: collectionRel, Href: s.href(href), Type: acquisitionType, Title: key}})
+	return entry
+}
+
+// entryGroup returns the group of an entry, the title of its collection link
+func entryGroup(entry *opds.Entry) string {
+	if entry == nil {
+		return ""
+	}
+	for _, link := range entry.Link {
+		if link.Rel == collectionRel {
+			return link.Title
+		}
+	}
+	return ""
+}
+
+// groupEntries keeps together the books of each group, ordered by group, the folders before them
+// are kept first. The feed of a group, requested with the group query param, only lists its books.
+func groupEntries(req *http.Request, entries []*opds.Entry, books int) {
+	if group := req.URL.Query().Get(groupParam); group != "" {
+		for i := range entries {
+			if i < books || entryGroup(entries[i]) != group {
+				entries[i] = nil
+			}
+		}
+		return
+	}
+
+	sort.SliceStable(entries[books:], func(i, j int) bool {
+		return entryGroup(entries[books+i]) < entryGroup(entries[books+j])
+	})
+}
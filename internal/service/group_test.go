@@ -0,0 +1,101 @@
+package service_test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/blog/atom"
+)
+
+func TestHandlerGroupBy(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "library"), 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "library", "series"), 0o755))
+	for _, name := range []string{"alice.epub", "bob.pdf", "carol.epub", "1984.pdf"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "library", name), []byte("Fixture"), 0o644))
+	}
+
+	// group is an entry and the title and href of its collection link
+	type group struct {
+		Entry string
+		Group string
+		Href  string
+	}
+
+	tests := map[string]struct {
+		groupBy string
+		input   string
+		want    []group
+	}{
+		"by format": {groupBy: "format", input: "/shelf/library", want: []group{
+			{Entry: "series"},
+			{Entry: "alice.epub", Group: "EPUB", Href: "/shelf/library?group=EPUB"},
+			{Entry: "carol.epub", Group: "EPUB", Href: "/shelf/library?group=EPUB"},
+			{Entry: "1984.pdf", Group: "PDF", Href: "/shelf/library?group=PDF"},
+			{Entry: "bob.pdf", Group: "PDF", Href: "/shelf/library?group=PDF"},
+		}},
+		"by letter": {groupBy: "letter", input: "/shelf/library", want: []group{
+			{Entry: "series"},
+			{Entry: "1984.pdf", Group: "#", Href: "/shelf/library?group=%23"},
+			{Entry: "alice.epub", Group: "A", Href: "/shelf/library?group=A"},
+			{Entry: "bob.pdf", Group: "B", Href: "/shelf/library?group=B"},
+			{Entry: "carol.epub", Group: "C", Href: "/shelf/library?group=C"},
+		}},
+		"feed of a group": {groupBy: "format", input: "/shelf/library?group=PDF", want: []group{
+			{Entry: "1984.pdf", Group: "PDF", Href: "/shelf/library?group=PDF"},
+			{Entry: "bob.pdf", Group: "PDF", Href: "/shelf/library?group=PDF"},
+		}},
+		"without groups": {input: "/shelf/library", want: []group{
+			{Entry: "series"},
+			{Entry: "1984.pdf"},
+			{Entry: "alice.epub"},
+			{Entry: "bob.pdf"},
+			{Entry: "carol.epub"},
+		}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, GroupBy: tc.groupBy}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			err := s.Handler(w, req)
+
+			// verify
+			require.NoError(t, err)
+			var feed struct {
+				Entries []struct {
+					Title string      `xml:"title"`
+					Links []atom.Link `xml:"link"`
+				} `xml:"entry"`
+			}
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &feed))
+
+			var got []group
+			for _, entry := range feed.Entries {
+				g := group{Entry: entry.Title}
+				for _, link := range entry.Links {
+					if link.Rel == "collection" {
+						g.Group, g.Href = link.Title, link.Href
+					}
+				}
+				got = append(got, g)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	t.Run("unknown grouping", func(t *testing.T) {
+		assert.Error(t, service.OPDS{TrustedRoot: dir, GroupBy: "size"}.Validate())
+	})
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"container/list"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hashedCoverPathPrefix serves in /covers/<sha-256 of the image><extension> the covers linked
+// from the feeds, the url changes with the image so clients can cache it forever
+const hashedCoverPathPrefix = "/covers/"
+
+// hashedCoverRebuildInterval is how often at most the HashedCoverIndex is rebuilt walking the
+// TrustedRoot for the covers it misses
+const hashedCoverRebuildInterval = 10 * time.Minute
+
+// HashedCoverIndex keeps the books whose covers were linked at their hashed urls to serve them,
+// the least recently used are forgotten when it holds more than its maximum number of covers.
+type HashedCoverIndex struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	// rebuilt is when the last rebuild started, zero before the first one
+	rebuilt time.Time
+}
+
+type hashedCoverBook struct {
+	name string
+	// pathRelativeToContentRoot is the book whose cover is hashed as name
+	pathRelativeToContentRoot string
+}
+
+// NewHashedCoverIndex returns an index of at most maxEntries covers, zero means no limit
+func NewHashedCoverIndex(maxEntries int) *HashedCoverIndex {
+	return &HashedCoverIndex{maxEntries: maxEntries, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get returns the book whose cover is hashed as name
+func (i *HashedCoverIndex) get(name string) (string, bool) {
+	if i == nil {
+		return "", false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	e, ok := i.entries[name]
+	if !ok {
+		return "", false
+	}
+	i.order.MoveToFront(e)
+	return e.Value.(hashedCoverBook).pathRelativeToContentRoot, true
+}
+
+// add keeps the book whose cover is hashed as name, forgetting the least recently used covers over the limit
+func (i *HashedCoverIndex) add(name, pathRelativeToContentRoot string) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if e, ok := i.entries[name]; ok {
+		i.order.Remove(e)
+	}
+	i.entries[name] = i.order.PushFront(hashedCoverBook{name: name, pathRelativeToContentRoot: pathRelativeToContentRoot})
+
+	for i.maxEntries > 0 && i.order.Len() > i.maxEntries {
+		delete(i.entries, i.order.Remove(i.order.Back()).(hashedCoverBook).name)
+	}
+}
+
+// startRebuild reports if a rebuild can start, at most one every hashedCoverRebuildInterval
+func (i *HashedCoverIndex) startRebuild() bool {
+	if i == nil {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if !i.rebuilt.IsZero() && time.Since(i.rebuilt) < hashedCoverRebuildInterval {
+		return false
+	}
+	i.rebuilt = time.Now()
+	return true
+}
+
+// hashedCoverHref returns the href of the cover of a book at the url made of its sha-256 when HashedCovers
+// is enabled, false for the covers that aren't local like the ones of the metadata sidecars
+func (s OPDS) hashedCoverHref(bookPath string, c cover) (string, bool) {
+	if !s.HashedCovers || !c.local() {
+		return "", false
+	}
+
+	name, err := s.hashedCoverName(c)
+	if err != nil {
+		log.Printf("hashedCoverHref %s err: %s", c.href, err)
+		return "", false
+	}
+
+	s.HashedCoverIndex.add(name, s.relativePath(bookPath))
+	return s.href(hashedCoverPathPrefix + name), true
+}
+
+// hashedCoverName returns the name of a local cover in its hashed url
+func (s OPDS) hashedCoverName(c cover) (string, error) {
+	sum, err := s.coverChecksum(c)
+	if err != nil {
+		return "", err
+	}
+	return sum + imageExtension(c.mimeType), nil
+}
+
+// coverChecksum returns the hex encoded sha-256 of the image of a local cover
+func (s OPDS) coverChecksum(c cover) (string, error) {
+	if c.epubPath != "" {
+		return s.embeddedCoverChecksum(c.epubPath)
+	}
+
+	fi, err := os.Stat(c.localPath)
+	if err != nil {
+		return "", err
+	}
+	return s.fileChecksum(c.localPath, fi)
+}
+
+// imageExtension returns the extension of the files of the images of mimeType, "" when it is unknown
+func imageExtension(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	}
+	return ""
+}
+
+// serveHashedCover serves the cover named in urlPath to the users allowed to see its book,
+// it is not found when the HashedCoverIndex doesn't hold it or its image changed
+func (s OPDS) serveHashedCover(w http.ResponseWriter, req *http.Request, urlPath string) error {
+	name := strings.TrimPrefix(urlPath, hashedCoverPathPrefix)
+	if !s.HashedCovers {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	pathRelativeToContentRoot, c, ok := s.indexedHashedCover(name)
+	if !ok {
+		// the index is empty after a restart and forgets the covers, it is rebuilt in the background
+		// and not on every miss, anyone can ask for unknown covers
+		if s.HashedCoverIndex.startRebuild() {
+			go s.rebuildHashedCovers()
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	if s.accessDenied(w, req, pathRelativeToContentRoot) {
+		return nil
+	}
+
+	w.Header().Add("Cache-Control", "public, max-age=31536000, immutable")
+	return serveCover(w, req, c)
+}
+
+// indexedHashedCover returns the book and the cover of the HashedCoverIndex named name,
+// false when it isn't indexed or the image of the book changed since
+func (s OPDS) indexedHashedCover(name string) (string, cover, bool) {
+	pathRelativeToContentRoot, ok := s.HashedCoverIndex.get(name)
+	if !ok {
+		return "", cover{}, false
+	}
+
+	c, ok := s.resolveCover(filepath.Join(s.TrustedRoot, pathRelativeToContentRoot))
+	if !ok || !c.local() {
+		return "", cover{}, false
+	}
+	if got, err := s.hashedCoverName(c); err != nil || got != name {
+		return "", cover{}, false
+	}
+	return pathRelativeToContentRoot, c, true
+}
+
+// rebuildHashedCovers walks the TrustedRoot adding the covers of the books to the HashedCoverIndex
+func (s OPDS) rebuildHashedCovers() {
+	err := filepath.WalkDir(s.TrustedRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		pathRelativeToContentRoot := s.relativePath(path)
+
+		// skip the files the feeds don't list
+		if d.IsDir() {
+			if s.fileShouldBeIgnored(pathRelativeToContentRoot) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if s.fileShouldBeIgnored(d.Name()) || !s.included(d.Name()) || isImage(strings.ToLower(filepath.Ext(d.Name()))) {
+			return nil
+		}
+
+		c, ok := s.resolveCover(path)
+		if !ok || !c.local() {
+			return nil
+		}
+		if name, err := s.hashedCoverName(c); err == nil {
+			s.HashedCoverIndex.add(name, pathRelativeToContentRoot)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("rebuildHashedCovers err: %s", err)
+	}
+}
@@ -0,0 +1,132 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerHashedCovers(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for _, folder := range []string{"mybook", "moved"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, folder), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, folder, "mybook.epub"), []byte("Fixture"), 0o644))
+		writeJPEG(t, filepath.Join(dir, folder, "cover.jpg"), 60, 90)
+	}
+	image, err := os.ReadFile(filepath.Join(dir, "mybook", "cover.jpg"))
+	require.NoError(t, err)
+	index := service.NewHashedCoverIndex(1)
+	s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true, HashedCovers: true, HashedCoverIndex: index}
+	imageLink := regexp.MustCompile(`<link rel="http://opds-spec.org/image" href="(/covers/[0-9a-f]{64}\.jpg)" type="image/jpeg"></link>`)
+
+	hrefs := map[string]string{}
+	for _, folder := range []string{"mybook", "moved"} {
+		// act
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf/"+folder, nil)))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		match := imageLink.FindStringSubmatch(w.Body.String())
+		require.NotNil(t, match, w.Body.String())
+		hrefs[folder] = match[1]
+	}
+	assert.Equal(t, hrefs["mybook"], hrefs["moved"], "the same image has the same url wherever it is")
+
+	// act
+	w := httptest.NewRecorder()
+	require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, hrefs["mybook"], nil)))
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+	assert.Equal(t, image, w.Body.Bytes())
+
+	t.Run("after a restart", func(t *testing.T) {
+		// setup
+		restarted := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true, HashedCovers: true, HashedCoverIndex: service.NewHashedCoverIndex(1)}
+		get := func() *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			require.NoError(t, restarted.Handler(w, httptest.NewRequest(http.MethodGet, hrefs["mybook"], nil)))
+			return w
+		}
+
+		// act
+		missed := get()
+
+		// verify the cover is found once the index is rebuilt in the background
+		assert.Equal(t, http.StatusNotFound, missed.Code)
+		assert.Eventually(t, func() bool { return get().Code == http.StatusOK }, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, image, get().Body.Bytes())
+	})
+
+	t.Run("without index", func(t *testing.T) {
+		// setup
+		s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true, HashedCovers: true}
+
+		// act
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, hrefs["mybook"], nil)))
+
+		// verify
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("hidden books", func(t *testing.T) {
+		tests := map[string]struct {
+			opds     service.OPDS
+			wantCode int
+		}{
+			"blocked":      {opds: service.OPDS{BlockFunc: func(string, *http.Request) bool { return true }}, wantCode: http.StatusUnavailableForLegalReasons},
+			"unauthorized": {opds: service.OPDS{Authorize: func(string, string) bool { return false }}, wantCode: http.StatusForbidden},
+		}
+
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				// setup
+				s := tc.opds
+				s.TrustedRoot, s.UseCalibreCovers, s.HashedCovers, s.HashedCoverIndex = dir, true, true, index
+
+				// act
+				w := httptest.NewRecorder()
+				require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, hrefs["mybook"], nil)))
+
+				// verify
+				assert.Equal(t, tc.wantCode, w.Code)
+				assert.Empty(t, w.Body.Bytes())
+			})
+		}
+	})
+
+	t.Run("changed image", func(t *testing.T) {
+		// setup
+		writeJPEG(t, filepath.Join(dir, "mybook", "cover.jpg"), 30, 45)
+		writeJPEG(t, filepath.Join(dir, "moved", "cover.jpg"), 30, 45)
+
+		// act
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, hrefs["mybook"], nil)))
+
+		// verify
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("unknown hash", func(t *testing.T) {
+		// act
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/covers/0000.jpg", nil)))
+
+		// verify
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+var htmlFeed = template.Must(template.New("feed").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em auto; max-width: 50em; }
+li { list-style: none; display: flex; align-items: center; gap: 1em; margin: .5em 0; min-height: 3em; }
+img { max-width: 4em; max-height: 6em; }
+</style>
+</head>
+<body>
+<nav>{{if .Start}}<a href="{{.Start}}">Home</a>{{end}}</nav>
+<h1>{{.Title}}</h1>
+<ul>
+{{- range .Entries}}
+<li>
+{{- if .Image}}<img src="{{.Image}}" alt="">{{end}}
+<div>
+{{- if .Href}}<a href="{{.Href}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}
+{{- range .Downloads}} <a href="{{.Href}}" download>{{.Title}}</a>{{end}}
+{{- if .Summary}}<br><small>{{.Summary}}</small>{{end}}
+</div>
+</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+type htmlPage struct {
+	Title   string
+	Start   string
+	Entries []htmlEntry
+}
+
+type htmlEntry struct {
+	Title string
+	// Href is the link to browse a folder, empty for books
+	Href      string
+	Image     string
+	Downloads []htmlLink
+	Summary   string
+}
+
+type htmlLink struct {
+	Title string
+	Href  string
+}
+
+// wantsHTML reports if the request explicitly accepts html, like browsers do
+func wantsHTML(req *http.Request) bool {
+	for _, accepted := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accepted, ";")
+		if strings.TrimSpace(mediaType) == "text/html" {
+			return true
+		}
+	}
+	return false
+}
+
+// serveHTML renders a feed as a page for browsers
+func (s OPDS) serveHTML(w http.ResponseWriter, req *http.Request, feed any) error {
+	buf := cappedBuffer{max: s.MaxFeedBytes}
+	if err := htmlFeed.Execute(&buf, makeHTMLPage(feedOf(feed))); errors.Is(err, errFeedTooLarge) {
+		s.serveFeedTooLarge(w, req)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	w.Header().Add("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, req, "feed.html", TimeNow(), bytes.NewReader(buf.Bytes()))
+	return nil
+}
+
+func makeHTMLPage(feed *opds.Feed) htmlPage {
+	var page htmlPage
+	if feed == nil {
+		return page
+	}
+
+	page.Title = feed.Title
+	for _, link := range feed.Link {
+		if link.Rel == "start" && link.Href != feed.ID {
+			page.Start = link.Href
+		}
+	}
+
+	for _, entry := range feed.Entry {
+		e := htmlEntry{Title: entry.Title}
+		if entry.Summary != nil {
+			e.Summary = entry.Summary.Body
+		}
+
+		for _, link := range entry.Link {
+			switch {
+			case link.Type == webpubType:
+				// the manifest is for the readers streaming the book, not a download
+			case strings.HasPrefix(link.Rel, "http://opds-spec.org/acquisition"):
+				e.Downloads = append(e.Downloads, htmlLink{Title: link.Title, Href: link.Href})
+			case link.Rel == "http://opds-spec.org/image/thumbnail":
+				e.Image = link.Href
+			case link.Rel == "http://opds-spec.org/image":
+				if e.Image == "" {
+					e.Image = link.Href
+				}
+			case link.Rel == "related":
+				// the books of the same author or series are not the target of the entry
+			case e.Href == "":
+				e.Href = link.Href
+			}
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	return page
+}
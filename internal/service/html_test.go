@@ -0,0 +1,65 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerHTML(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "my <folder>"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "my <folder>", "mybook.epub"), []byte("Fixture"), 0o644))
+	writeJPEG(t, filepath.Join(dir, "my <folder>", "cover.jpg"), 10, 15)
+
+	tests := map[string]struct {
+		html     bool
+		accept   string
+		wantType string
+	}{
+		"browser":              {html: true, accept: "text/html,application/xhtml+xml,*/*;q=0.8", wantType: "text/html; charset=utf-8"},
+		"opds client":          {html: true, accept: "application/atom+xml", wantType: "application/atom+xml;profile=opds-catalog;kind=navigation"},
+		"browser without html": {html: false, accept: "text/html", wantType: "application/atom+xml;profile=opds-catalog;kind=navigation"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, HideCalibreFiles: true, UseCalibreCovers: true, HTML: tc.html}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+			req.Header.Set("Accept", tc.accept)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.wantType, w.Header().Get("Content-Type"))
+			if tc.html {
+				assert.Equal(t, "Accept", w.Header().Get("Vary"))
+			}
+			if tc.wantType == "text/html; charset=utf-8" {
+				assert.Contains(t, w.Body.String(), `<a href="/shelf/my%20%3Cfolder%3E">my &lt;folder&gt;</a>`)
+			}
+		})
+	}
+
+	// act
+	s := service.OPDS{TrustedRoot: dir, HideCalibreFiles: true, UseCalibreCovers: true, HTML: true}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf/my%20%3Cfolder%3E", nil)
+	req.Header.Set("Accept", "text/html")
+	require.NoError(t, s.Handler(w, req))
+
+	// verify the books have their cover and a download link
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<img src="/shelf/my%20%3Cfolder%3E/cover.jpg" alt="">`)
+	assert.Contains(t, w.Body.String(), `<a href="/shelf/my%20%3Cfolder%3E/mybook.epub" download>mybook.epub</a>`)
+}
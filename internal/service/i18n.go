@@ -0,0 +1,138 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// messages translates the built-in strings of the feeds, keyed by their English text, to the
+// languages with messages by their primary language subtag. Missing strings are kept in English.
+var messages = map[string]map[string]string{
+	"es": {
+		"Home":         "Inicio",
+		"Newest books": "Libros más recientes",
+		"The 15 latest modified books, most-recently-modified first.": "Los 15 últimos libros modificados, los más recientes primero.",
+		"All books":                   "Todos los libros",
+		"All books.":                  "Todos los libros.",
+		"The page %s does not exist.": "La página %s no existe.",
+		"Catalog in %s":               "Catálogo en %s",
+		"Folders containing files matching query %s": "Carpetas con archivos que coinciden con la búsqueda %s",
+		"More from %s":            "Más de %s",
+		"More from the series %s": "Más de la serie %s",
+		"Books by date":           "Libros por fecha",
+		"%d books.":               "%d libros.",
+		"%s books · %s":           "%s libros · %s",
+		"Not found":               "No encontrado",
+		"%s with cover":           "%s con portada",
+		"Random books":            "Libros al azar",
+		"Read online":             "Leer en línea",
+		"Authors":                 "Autores",
+		"Series":                  "Series",
+		"The books by author.":    "Los libros por autor.",
+		"The books by series.":    "Los libros por serie.",
+		"The books by the year and month they were modified.":        "Los libros por el año y el mes en que se modificaron.",
+		"%d books picked at random, new ones every day.":             "%d libros elegidos al azar, nuevos cada día.",
+		"This is an OPDS catalog, add this URL to your OPDS reader:": "Este es un catálogo OPDS, añada esta URL a su lector OPDS:",
+		"January":   "Enero",
+		"February":  "Febrero",
+		"March":     "Marzo",
+		"April":     "Abril",
+		"May":       "Mayo",
+		"June":      "Junio",
+		"July":      "Julio",
+		"August":    "Agosto",
+		"September": "Septiembre",
+		"October":   "Octubre",
+		"November":  "Noviembre",
+		"December":  "Diciembre",
+	},
+	"fr": {
+		"Home":         "Accueil",
+		"Newest books": "Livres les plus récents",
+		"The 15 latest modified books, most-recently-modified first.": "Les 15 derniers livres modifiés, les plus récents en premier.",
+		"All books":                   "Tous les livres",
+		"All books.":                  "Tous les livres.",
+		"The page %s does not exist.": "La page %s n'existe pas.",
+		"Catalog in %s":               "Catalogue dans %s",
+		"Folders containing files matching query %s": "Dossiers contenant des fichiers correspondant à la recherche %s",
+		"More from %s":            "Plus de %s",
+		"More from the series %s": "Plus de la série %s",
+		"Books by date":           "Livres par date",
+		"%d books.":               "%d livres.",
+		"%s books · %s":           "%s livres · %s",
+		"Not found":               "Introuvable",
+		"%s with cover":           "%s avec couverture",
+		"Random books":            "Livres au hasard",
+		"Read online":             "Lire en ligne",
+		"Authors":                 "Auteurs",
+		"Series":                  "Séries",
+		"The books by author.":    "Les livres par auteur.",
+		"The books by series.":    "Les livres par série.",
+		"The books by the year and month they were modified.":        "Les livres par l'année et le mois de leur modification.",
+		"%d books picked at random, new ones every day.":             "%d livres choisis au hasard, nouveaux chaque jour.",
+		"This is an OPDS catalog, add this URL to your OPDS reader:": "Ceci est un catalogue OPDS, ajoutez cette URL à votre lecteur OPDS :",
+		"January":   "Janvier",
+		"February":  "Février",
+		"March":     "Mars",
+		"April":     "Avril",
+		"May":       "Mai",
+		"June":      "Juin",
+		"July":      "Juillet",
+		"August":    "Août",
+		"September": "Septembre",
+		"October":   "Octobre",
+		"November":  "Novembre",
+		"December":  "Décembre",
+	},
+}
+
+// language returns the language of the messages for req, the most preferred language of its
+// Accept-Language header that has messages or "" for English
+func language(req *http.Request) string {
+	type accepted struct {
+		lang string
+		q    float64
+	}
+
+	var langs []accepted
+	for _, part := range strings.Split(req.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				q = f
+			}
+		}
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if lang != "" && q > 0 {
+			langs = append(langs, accepted{lang: lang, q: q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+
+	for _, accepted := range langs {
+		if accepted.lang == "en" {
+			return ""
+		}
+		if _, ok := messages[accepted.lang]; ok {
+			return accepted.lang
+		}
+	}
+	return ""
+}
+
+// translate returns msg in the language negotiated for req formatted with args
+func translate(req *http.Request, msg string, args ...any) string {
+	if translated, ok := messages[language(req)][msg]; ok {
+		msg = translated
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
@@ -0,0 +1,73 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerAcceptLanguage(t *testing.T) {
+	// setup
+	s := service.OPDS{TrustedRoot: "testdata", HideDotFiles: true}
+
+	tests := map[string]struct {
+		acceptLanguage string
+		want           []string
+	}{
+		"spanish":                 {acceptLanguage: "es", want: []string{"<title>Inicio</title>", "<title>Libros más recientes</title>", "<title>Todos los libros</title>"}},
+		"spanish region":          {acceptLanguage: "es-MX,en;q=0.5", want: []string{"<title>Inicio</title>"}},
+		"by quality":              {acceptLanguage: "fr;q=0.5, es;q=0.8", want: []string{"<title>Inicio</title>"}},
+		"english preferred":       {acceptLanguage: "en-US,es;q=0.9", want: []string{"<title>Home</title>", "<title>Newest books</title>"}},
+		"language without titles": {acceptLanguage: "ja", want: []string{"<title>Home</title>", "<title>All books</title>"}},
+		"without accept-language": {acceptLanguage: "", want: []string{"<title>Home</title>"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Language", tc.acceptLanguage)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Header().Values("Vary"), "Accept-Language")
+			for _, want := range tc.want {
+				assert.Contains(t, w.Body.String(), want)
+			}
+		})
+	}
+
+	t.Run("folder", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/shelf/mybook", nil)
+		req.Header.Set("Accept-Language", "es")
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "<title>Catálogo en /shelf/mybook</title>")
+	})
+
+	t.Run("configured shelf title is not translated", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: "testdata", ShelfTitle: "Library"}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Language", "es")
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "<title>Library</title>")
+	})
+}
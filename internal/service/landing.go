@@ -0,0 +1,51 @@
+package service
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+)
+
+var htmlLanding = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em auto; max-width: 50em; }
+code { font-size: 1.2em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Instructions}}</p>
+<p><code>{{.URL}}</code></p>
+</body>
+</html>
+`))
+
+type landingPage struct {
+	Title        string
+	Instructions string
+	URL          string
+}
+
+// serveLanding serves to the browsers opening the root feed without HTML a page telling
+// to add its url to an OPDS reader, instead of the xml they don't render
+func (s OPDS) serveLanding(w http.ResponseWriter, req *http.Request) error {
+	var buf bytes.Buffer
+	err := htmlLanding.Execute(&buf, landingPage{
+		Title:        s.title(),
+		Instructions: translate(req, "This is an OPDS catalog, add this URL to your OPDS reader:"),
+		URL:          absoluteURL(req, s.href("/")),
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Add("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, req, "landing.html", TimeNow(), bytes.NewReader(buf.Bytes()))
+	return nil
+}
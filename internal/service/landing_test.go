@@ -0,0 +1,49 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerLanding(t *testing.T) {
+	// setup
+	tests := map[string]struct {
+		opds     service.OPDS
+		accept   string
+		wantType string
+		wantURL  string
+	}{
+		"browser":             {opds: service.OPDS{TrustedRoot: "testdata"}, accept: "text/html,application/xhtml+xml,*/*;q=0.8", wantType: "text/html; charset=utf-8", wantURL: "http://example.com/"},
+		"browser at basepath": {opds: service.OPDS{TrustedRoot: "testdata", BasePath: "/opds"}, accept: "text/html", wantType: "text/html; charset=utf-8", wantURL: "http://example.com/opds/"},
+		"opds client":         {opds: service.OPDS{TrustedRoot: "testdata"}, accept: "application/atom+xml", wantType: "application/atom+xml;profile=opds-catalog;kind=navigation"},
+		"any type":            {opds: service.OPDS{TrustedRoot: "testdata"}, accept: "*/*", wantType: "application/atom+xml;profile=opds-catalog;kind=navigation"},
+		"browser with html":   {opds: service.OPDS{TrustedRoot: "testdata", HTML: true}, accept: "text/html", wantType: "text/html; charset=utf-8"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.opds.BasePath+"/", nil)
+			req.Header.Set("Accept", tc.accept)
+
+			// act
+			require.NoError(t, tc.opds.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.wantType, w.Header().Get("Content-Type"))
+			assert.Subset(t, w.Header().Values("Vary"), []string{"Accept", "Accept-Language"})
+			if tc.wantURL != "" {
+				assert.Contains(t, w.Body.String(), "add this URL to your OPDS reader")
+				assert.Contains(t, w.Body.String(), "<code>"+tc.wantURL+"</code>")
+			} else {
+				assert.NotContains(t, w.Body.String(), "add this URL to your OPDS reader")
+			}
+		})
+	}
+}
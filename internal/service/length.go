@@ -0,0 +1,130 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dubyte/dir2opds/opds"
+	"golang.org/x/tools/blog/atom"
+)
+
+type bookLength struct {
+	size    int64
+	modTime time.Time
+	summary string
+}
+
+var (
+	bookLengthsMu sync.Mutex
+	// bookLengths caches the length of the books by path, an entry is replaced when the file changes
+	bookLengths = map[string]bookLength{}
+)
+
+// pdfPage matches the page objects of a pdf, not the /Type /Pages tree nodes
+var pdfPage = regexp.MustCompile(`/Type\s*/Page\b`)
+
+// htmlTag matches the markup removed before counting the words of an epub
+var htmlTag = regexp.MustCompile(`(?s)<[^>]*>`)
+
+// addLength sets the length of the book as the summary of the entry when BookLength is enabled
+func (s OPDS) addLength(filePath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	if !s.BookLength {
+		return builder
+	}
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return builder
+	}
+
+	summary := lengthSummary(filePath, fi)
+	if summary == "" {
+		return builder
+	}
+	return builder.Summary(&atom.Text{Type: "text", Body: summary})
+}
+
+// lengthSummary returns the page count of a pdf or the approximate word count of an epub,
+// "" when it can't be computed.
+func lengthSummary(filePath string, fi os.FileInfo) string {
+	bookLengthsMu.Lock()
+	cached, ok := bookLengths[filePath]
+	bookLengthsMu.Unlock()
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached.summary
+	}
+
+	var summary string
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".pdf":
+		if pages, err := pdfPageCount(filePath); err != nil {
+			log.Printf("lengthSummary %s err: %s", filePath, err)
+		} else if pages > 0 {
+			summary = fmt.Sprintf("%d pages", pages)
+		}
+	case ".epub":
+		if words, err := epubWordCount(filePath); err != nil {
+			log.Printf("lengthSummary %s err: %s", filePath, err)
+		} else if words > 0 {
+			summary = fmt.Sprintf("about %d words", words)
+		}
+	}
+
+	bookLengthsMu.Lock()
+	bookLengths[filePath] = bookLength{size: fi.Size(), modTime: fi.ModTime(), summary: summary}
+	bookLengthsMu.Unlock()
+	return summary
+}
+
+// pdfPageCount counts the page objects of a pdf,
+// compressed object streams are not inflated so it may return 0
+func pdfPageCount(filePath string) (int, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.HasPrefix(content, []byte("%PDF-")) {
+		return 0, fmt.Errorf("not a pdf")
+	}
+	return len(pdfPage.FindAllIndex(content, -1)), nil
+}
+
+// epubWordCount counts the words of the html documents of an epub
+func epubWordCount(filePath string) (int, error) {
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	var words int
+	for _, f := range r.File {
+		switch strings.ToLower(filepath.Ext(f.Name)) {
+		case ".html", ".htm", ".xhtml":
+		default:
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return 0, err
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return 0, err
+		}
+
+		words += len(strings.Fields(string(htmlTag.ReplaceAll(content, []byte(" ")))))
+	}
+	return words, nil
+}
@@ -0,0 +1,60 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tinyPDF has a page tree with two pages
+const tinyPDF = `%PDF-1.4
+1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj
+2 0 obj << /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >> endobj
+3 0 obj << /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >> endobj
+4 0 obj << /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >> endobj
+trailer << /Root 1 0 R >>
+%%EOF
+`
+
+func TestHandlerBookLength(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook.pdf"), []byte(tinyPDF), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.epub"), []byte("Fixture"), 0o644))
+
+	tests := map[string]struct {
+		bookLength bool
+		want       bool
+	}{
+		"disabled": {bookLength: false, want: false},
+		"enabled":  {bookLength: true, want: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, BookLength: tc.bookLength}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			if tc.want {
+				assert.Contains(t, w.Body.String(), `<summary type="text">2 pages</summary>`)
+				// the length of the broken epub can't be computed so it has no summary
+				assert.Equal(t, 1, strings.Count(w.Body.String(), "<summary"))
+			} else {
+				assert.NotContains(t, w.Body.String(), "<summary")
+			}
+		})
+	}
+}
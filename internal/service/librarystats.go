@@ -0,0 +1,92 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// libraryStatsMaxAge is how long the totals of the library are kept before walking it again
+const libraryStatsMaxAge = 10 * time.Minute
+
+// LibraryStats keeps the totals of the library of an OPDS by user, each user counts the books
+// they are authorized to
+type LibraryStats struct {
+	mu     sync.Mutex
+	totals map[string]libraryTotals
+	maxAge time.Duration
+}
+
+type libraryTotals struct {
+	books   int
+	bytes   int64
+	counted time.Time
+}
+
+// NewLibraryStats returns the totals of a library, counted when they are first shown
+func NewLibraryStats() *LibraryStats {
+	return &LibraryStats{totals: map[string]libraryTotals{}, maxAge: libraryStatsMaxAge}
+}
+
+// librarySummary returns the totals of the library for req like "1,234 books · 45 GB" when
+// LibraryStats is set, they are counted walking the library at most every libraryStatsMaxAge
+func (s OPDS) librarySummary(req *http.Request) (string, bool) {
+	if s.LibraryStats == nil {
+		return "", false
+	}
+
+	user, _, _ := req.BasicAuth()
+
+	s.LibraryStats.mu.Lock()
+	totals, ok := s.LibraryStats.totals[user]
+	s.LibraryStats.mu.Unlock()
+
+	now := time.Now()
+	if !ok || now.Sub(totals.counted) >= s.LibraryStats.maxAge || now.Before(totals.counted) {
+		totals = libraryTotals{counted: now}
+		for _, file := range s.walkBooks(req) {
+			totals.books++
+			totals.bytes += file.fileInfo.Size()
+		}
+		// a walk stopped with its request counts only part of the library
+		if req.Context().Err() != nil {
+			return "", false
+		}
+
+		s.LibraryStats.mu.Lock()
+		s.LibraryStats.totals[user] = totals
+		s.LibraryStats.mu.Unlock()
+	}
+
+	return translate(req, "%s books · %s", groupThousands(totals.books), byteSize(totals.bytes)), true
+}
+
+// groupThousands formats n with its thousands separated by commas, like 1,234
+func groupThousands(n int) string {
+	digits := strconv.Itoa(n)
+	for i := len(digits) - 3; i > 0 && digits[i-1] != '-'; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return digits
+}
+
+// byteSize formats a size in decimal units, like 45 GB or 1.5 MB
+func byteSize(n int64) string {
+	if n < 1000 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	size := float64(n)
+	for _, unit := range []string{"kB", "MB", "GB", "TB"} {
+		size /= 1000
+		if size < 1000 || unit == "TB" {
+			if size < 10 {
+				return fmt.Sprintf("%.1f %s", size, unit)
+			}
+			return fmt.Sprintf("%.0f %s", size, unit)
+		}
+	}
+	return ""
+}
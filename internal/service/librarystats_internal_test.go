@@ -0,0 +1,70 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibrarySummaryRecounted(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook.epub"), make([]byte, 1500), 0o644))
+	stats := NewLibraryStats()
+	stats.maxAge = 100 * time.Millisecond
+	s := OPDS{TrustedRoot: dir, LibraryStats: stats}
+	summary := func() string {
+		got, ok := s.librarySummary(httptest.NewRequest(http.MethodGet, "/", nil))
+		require.True(t, ok)
+		return got
+	}
+	summary()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.epub"), make([]byte, 1000), 0o644))
+
+	// act
+	cached := summary()
+	time.Sleep(stats.maxAge)
+	counted := summary()
+
+	// verify
+	assert.Equal(t, "1 books · 1.5 kB", cached)
+	assert.Equal(t, "2 books · 2.5 kB", counted)
+}
+
+func TestGroupThousands(t *testing.T) {
+	tests := map[int]string{
+		0:        "0",
+		999:      "999",
+		1000:     "1,000",
+		1234:     "1,234",
+		1234567:  "1,234,567",
+		-1234567: "-1,234,567",
+	}
+
+	for n, want := range tests {
+		assert.Equal(t, want, groupThousands(n), n)
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	tests := map[int64]string{
+		0:                     "0 B",
+		999:                   "999 B",
+		1000:                  "1.0 kB",
+		1500:                  "1.5 kB",
+		12_345_678:            "12 MB",
+		45_000_000_000:        "45 GB",
+		2_000_000_000_000:     "2.0 TB",
+		5_000_000_000_000_000: "5000 TB",
+	}
+
+	for n, want := range tests {
+		assert.Equal(t, want, byteSize(n), n)
+	}
+}
@@ -0,0 +1,89 @@
+package service_test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerLibraryStats(t *testing.T) {
+	// setup
+	library := func(t *testing.T) string {
+		t.Helper()
+		dir := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "novels"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "novels", "mybook.epub"), make([]byte, 1500), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "novels", "other.epub"), make([]byte, 1000), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook.pdf"), make([]byte, 500), 0o644))
+		return dir
+	}
+
+	subtitle := func(t *testing.T, s service.OPDS, acceptLanguage string) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		require.NoError(t, s.Handler(w, req))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var feed struct {
+			Subtitle string `xml:"subtitle"`
+		}
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &feed))
+		return feed.Subtitle
+	}
+
+	t.Run("totals", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: library(t), LibraryStats: service.NewLibraryStats()}
+
+		// act
+		got := subtitle(t, s, "")
+
+		// verify
+		assert.Equal(t, "3 books · 3.0 kB", got)
+		assert.Equal(t, "3 livres · 3.0 kB", subtitle(t, s, "fr"))
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		dir := library(t)
+		s := service.OPDS{TrustedRoot: dir, LibraryStats: service.NewLibraryStats()}
+		subtitle(t, s, "")
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "novels", "third.epub"), make([]byte, 1000), 0o644))
+
+		// act
+		cached := subtitle(t, s, "")
+
+		// verify
+		assert.Equal(t, "3 books · 3.0 kB", cached)
+		assert.Equal(t, "4 books · 4.0 kB", subtitle(t, service.OPDS{TrustedRoot: dir, LibraryStats: service.NewLibraryStats()}, ""))
+	})
+
+	t.Run("filtered by each catalog", func(t *testing.T) {
+		dir := library(t)
+		all := service.OPDS{TrustedRoot: dir, LibraryStats: service.NewLibraryStats()}
+		epubs := service.OPDS{TrustedRoot: dir, IncludeOnly: regexp.MustCompile(`\.epub$`), LibraryStats: service.NewLibraryStats()}
+		large := service.OPDS{TrustedRoot: dir, MinFileSize: 1000, LibraryStats: service.NewLibraryStats()}
+
+		// act
+		gotAll := subtitle(t, all, "")
+		gotEpubs := subtitle(t, epubs, "")
+		gotLarge := subtitle(t, large, "")
+
+		// verify
+		assert.Equal(t, "3 books · 3.0 kB", gotAll)
+		assert.Equal(t, "2 books · 2.5 kB", gotEpubs)
+		assert.Equal(t, "2 books · 2.5 kB", gotLarge)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		assert.Empty(t, subtitle(t, service.OPDS{TrustedRoot: library(t)}, ""))
+	})
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache keeps the values last used by key, the least recently used are evicted when it holds
+// more than maxEntries values or maxBytes bytes of values, zero means no limit
+type lruCache[V any] struct {
+	maxEntries int
+	maxBytes   int
+	// size returns the bytes of a value counted against maxBytes
+	size func(V) int
+
+	mu      sync.Mutex
+	bytes   int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRUCache[V any](maxEntries, maxBytes int, size func(V) int) *lruCache[V] {
+	return &lruCache[V]{maxEntries: maxEntries, maxBytes: maxBytes, size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get returns the value of key
+func (c *lruCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(lruEntry[V]).value, true
+}
+
+// add keeps the value of key, evicting the least recently used values over the limits
+func (c *lruCache[V]) add(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	c.entries[key] = c.order.PushFront(lruEntry[V]{key: key, value: value})
+	c.bytes += c.size(value)
+
+	for c.order.Len() > 0 && ((c.maxEntries > 0 && c.order.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)) {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *lruCache[V]) remove(e *list.Element) {
+	entry := c.order.Remove(e).(lruEntry[V])
+	delete(c.entries, entry.key)
+	c.bytes -= c.size(entry.value)
+}
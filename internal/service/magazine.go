@@ -0,0 +1,95 @@
+package service
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+// issueDateLayouts are the names of the folders of magazine issues, like Magazine/2023-01/issue.pdf
+var issueDateLayouts = []string{"2006-01-02", "2006-01", "2006"}
+
+// issueDate parses the name of the folder of a magazine issue
+func issueDate(name string) (time.Time, bool) {
+	for _, layout := range issueDateLayouts {
+		if date, err := time.Parse(layout, name); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// magazineIssue returns the name of the pdf in dirPath and the date of the issue when MagazineMode
+// is enabled, the folder is named after a date and the pdf is the only book in it.
+func (s OPDS) magazineIssue(dirPath string) (string, time.Time, bool) {
+	if !s.MagazineMode {
+		return "", time.Time{}, false
+	}
+
+	date, ok := issueDate(filepath.Base(dirPath))
+	if !ok {
+		return "", time.Time{}, false
+	}
+
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	var name string
+	for _, entry := range dirEntries {
+		_, pathRelativeToContentRoot, _ := strings.Cut(filepath.Join(dirPath, entry.Name()), s.TrustedRoot+"/")
+		if s.fileShouldBeIgnored(pathRelativeToContentRoot) || isCover(entry.Name()) {
+			continue
+		}
+		if entry.IsDir() || name != "" || !strings.EqualFold(filepath.Ext(entry.Name()), ".pdf") || !s.included(entry.Name()) {
+			return "", time.Time{}, false
+		}
+		name = entry.Name()
+	}
+	return name, date, name != ""
+}
+
+// issueTitle is the title of the issue in dirPath, the magazine followed by the date like "Magazine 2023-01"
+func (s OPDS) issueTitle(dirPath string) string {
+	if filepath.Dir(dirPath) == s.TrustedRoot {
+		return filepath.Base(dirPath)
+	}
+	return filepath.Base(filepath.Dir(dirPath)) + " " + filepath.Base(dirPath)
+}
+
+// makeIssueEntry returns the acquisition entry of the pdf of the magazine issue in dirPath
+// published on date, the entry replaces the one of the folder.
+func (s OPDS) makeIssueEntry(req *http.Request, dirPath, name string, date time.Time) opds.EntryBuilder {
+	filePath := filepath.Join(dirPath, name)
+	_, pathRelativeToContentRoot, _ := strings.Cut(filePath, s.TrustedRoot+"/")
+
+	builder := opds.EntryBuilder{}.
+		ID(filepath.Join("/shelf", pathRelativeToContentRoot)).
+		Title(s.issueTitle(dirPath)).
+		Published(date).
+		Updated(date).
+		AddLink(opds.LinkBuilder.
+			Rel(s.acquisitionRel()).
+			Title(name).
+			Href(s.href(filepath.Join("/shelf", escapePath(pathRelativeToContentRoot)))).
+			Type(getType(name, pathTypeFile)).
+			Build())
+
+	return s.addBookMetadata(filePath, builder, req)
+}
+
+// issueFileInfo is the FileInfo of the pdf of a magazine issue modified on the date of the issue,
+// so the issues are sorted by date in /new and the calendar
+type issueFileInfo struct {
+	os.FileInfo
+	date time.Time
+}
+
+func (fi issueFileInfo) ModTime() time.Time {
+	return fi.date
+}
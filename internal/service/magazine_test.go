@@ -0,0 +1,78 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerMagazineMode(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for _, issue := range []string{"2023-01", "2023-02"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "Magazine", issue), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "Magazine", issue, "issue.pdf"), []byte("Fixture"), 0o644))
+	}
+	// the older issue was downloaded last
+	modTime := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "Magazine", "2023-01", "issue.pdf"), modTime, modTime))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "Magazine", "2023-02", "issue.pdf"), modTime.AddDate(0, -1, 0), modTime.AddDate(0, -1, 0)))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "Magazine", "extras"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Magazine", "extras", "poster.pdf"), []byte("Fixture"), 0o644))
+
+	t.Run("issues are entries", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir, MagazineMode: true}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/shelf/Magazine", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "kind=acquisition")
+		assert.Contains(t, w.Body.String(), `<title>Magazine 2023-01</title>`)
+		assert.Contains(t, w.Body.String(), `<published>2023-01-01T00:00:00+00:00</published>`)
+		assert.Contains(t, w.Body.String(), `<updated>2023-01-01T00:00:00+00:00</updated>`)
+		assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/acquisition" href="/shelf/Magazine/2023-01/issue.pdf" type="application/pdf" title="issue.pdf"></link>`)
+		assert.Contains(t, w.Body.String(), `<title>Magazine 2023-02</title>`)
+		assert.Contains(t, w.Body.String(), `<link rel="subsection" href="/shelf/Magazine/extras"`, "a folder not named after a date is still a folder")
+	})
+
+	t.Run("newest issues first", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir, MagazineMode: true}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/new", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		assert.Contains(t, body, `<published>2023-02-01T00:00:00+00:00</published>`)
+		assert.Less(t, strings.Index(body, "Magazine 2023-02"), strings.Index(body, "Magazine 2023-01"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/shelf/Magazine", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `<link rel="subsection" href="/shelf/Magazine/2023-01"`)
+		assert.NotContains(t, w.Body.String(), "<published>2023")
+	})
+}
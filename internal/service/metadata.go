@@ -0,0 +1,63 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sidecarName is the file with the metadata of the books in its folder
+const sidecarName = "metadata.json"
+
+// sidecar is the content of a metadata.json, e.g.
+//
+//	{"cover": "https://covers.example/mybook.jpg", "tags": ["Fantasy", "Classics"]}
+type sidecar struct {
+	// Cover is the url of a remote cover
+	Cover string `json:"cover"`
+	// Tags are the subjects of the books
+	Tags []string `json:"tags"`
+}
+
+// readSidecar reads the metadata.json in dir, it returns false when there is none or it is not valid
+func readSidecar(dir string) (sidecar, bool) {
+	content, err := os.ReadFile(filepath.Join(dir, sidecarName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("readSidecar err: %s", err)
+		}
+		return sidecar{}, false
+	}
+
+	var meta sidecar
+	if err := json.Unmarshal(content, &meta); err != nil {
+		log.Printf("readSidecar %s err: %s", dir, err)
+		return sidecar{}, false
+	}
+
+	if meta.Cover != "" {
+		u, err := url.Parse(meta.Cover)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Printf("readSidecar %s: cover %q is not an http url", dir, meta.Cover)
+			meta.Cover = ""
+		}
+	}
+
+	return meta, true
+}
+
+// remoteImageType guesses the mime type of a remote image by the extension of its url
+func remoteImageType(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err == nil {
+		if t := mime.TypeByExtension(strings.ToLower(path.Ext(u.Path))); strings.HasPrefix(t, "image/") {
+			return t
+		}
+	}
+	return "image/jpeg"
+}
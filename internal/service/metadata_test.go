@@ -0,0 +1,60 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerSidecarCover(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for book, sidecar := range map[string]string{
+		"remote":      `{"cover": "https://covers.example/my%20book.png"}`,
+		"not http":    `{"cover": "javascript:alert(1)"}`,
+		"local first": `{"cover": "https://covers.example/ignored.jpg"}`,
+	} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, book), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, book, "book.epub"), []byte("Fixture"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, book, "metadata.json"), []byte(sidecar), 0o644))
+	}
+	writeJPEG(t, filepath.Join(dir, "local first", "cover.jpg"), 10, 15)
+	s := service.OPDS{TrustedRoot: dir, HideCalibreFiles: true, UseCalibreCovers: true, SidecarMetadata: true}
+
+	tests := map[string]struct {
+		input   string
+		want    string
+		notWant string
+	}{
+		"remote cover":        {input: "/shelf/remote", want: `<link rel="http://opds-spec.org/image" href="https://covers.example/my%20book.png" type="image/png"></link>`},
+		"not http cover":      {input: "/shelf/not%20http", notWant: `rel="http://opds-spec.org/image"`},
+		"local cover is used": {input: "/shelf/local%20first", want: `<link rel="http://opds-spec.org/image" href="/shelf/local%20first/cover.jpg" type="image/jpeg"></link>`, notWant: "ignored.jpg"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			err := s.Handler(w, req)
+			require.NoError(t, err)
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, []string{"book.epub"}, entryTitles(t, w.Body.Bytes()))
+			if tc.want != "" {
+				assert.Contains(t, w.Body.String(), tc.want)
+			}
+			if tc.notWant != "" {
+				assert.NotContains(t, w.Body.String(), tc.notWant)
+			}
+		})
+	}
+}
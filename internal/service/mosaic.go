@@ -0,0 +1,165 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const mosaicPathPrefix = "/mosaic/"
+
+const (
+	mosaicTileWidth  = thumbnailMaxWidth / 2
+	mosaicTileHeight = thumbnailMaxHeight / 2
+	mosaicMaxCovers  = 4
+	// mosaicCacheEntries is the number of mosaics kept in memory
+	mosaicCacheEntries = 1024
+)
+
+var mosaicBackground = color.RGBA{R: 0xee, G: 0xee, B: 0xee, A: 0xff}
+
+type mosaic struct {
+	hash    string
+	content []byte
+}
+
+// mosaics caches the generated images by directory, an entry is replaced when its hash changes
+var mosaics = newLRUCache(mosaicCacheEntries, 0, func(m mosaic) int { return len(m.content) })
+
+// mosaicsEnabled reports if the folders get mosaics, they are made from the calibre covers
+func (s OPDS) mosaicsEnabled() bool {
+	return s.Mosaics && s.UseCalibreCovers
+}
+
+// mosaicCovers returns up to four covers of the books in the subdirectories of dirPath
+// the user of req is authorized to
+func (s OPDS) mosaicCovers(req *http.Request, dirPath string) []string {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		log.Printf("mosaicCovers: readDir err: %s", err)
+		return nil
+	}
+
+	var covers []string
+	for _, entry := range dirEntries {
+		if !entry.IsDir() || s.fileShouldBeIgnored(entry.Name()) {
+			continue
+		}
+		if _, pathRelativeToContentRoot, _ := strings.Cut(filepath.Join(dirPath, entry.Name()), s.TrustedRoot+"/"); !s.authorized(req, pathRelativeToContentRoot) {
+			continue
+		}
+
+		if coverPath, ok := calibreCoverPath(filepath.Join(dirPath, entry.Name())); ok {
+			covers = append(covers, coverPath)
+		}
+
+		if len(covers) == mosaicMaxCovers {
+			break
+		}
+	}
+	return covers
+}
+
+// mosaicHash identifies the covers used in a mosaic by their path, size and modification time
+func mosaicHash(covers []string) string {
+	h := sha256.New()
+	for _, coverPath := range covers {
+		fi, err := os.Stat(coverPath)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(h, "%s %d %d\n", coverPath, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// serveMosaic serves an image made from the covers of the books in the directory of urlPath
+func (s OPDS) serveMosaic(w http.ResponseWriter, req *http.Request, urlPath string) error {
+	dirPath := filepath.Join(s.TrustedRoot, strings.TrimPrefix(urlPath, mosaicPathPrefix))
+
+	dirPath, err := verifyPath(dirPath, s.TrustedRoot)
+	if err != nil {
+		log.Printf("mosaic %q err: %s", dirPath, err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	_, pathRelativeToContentRoot, _ := strings.Cut(dirPath, s.TrustedRoot+"/")
+	if !s.mosaicsEnabled() || s.fileShouldBeIgnored(pathRelativeToContentRoot) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	if s.accessDenied(w, req, pathRelativeToContentRoot) {
+		return nil
+	}
+
+	covers := s.mosaicCovers(req, dirPath)
+	if len(covers) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	hash := mosaicHash(covers)
+
+	cached, ok := mosaics.get(dirPath)
+
+	if !ok || cached.hash != hash {
+		content, ok := s.readArtifact(mosaicArtifacts, hash)
+		if !ok {
+			content, err = makeMosaic(covers)
+			if err != nil {
+				return err
+			}
+			s.writeArtifact(mosaicArtifacts, hash, content)
+		}
+		cached = mosaic{hash: hash, content: content}
+
+		mosaics.add(dirPath, cached)
+	}
+
+	w.Header().Add("Content-Type", "image/jpeg")
+	w.Header().Add("ETag", `"`+hash+`"`)
+	http.ServeContent(w, req, "", TimeNow(), bytes.NewReader(cached.content))
+	return nil
+}
+
+// makeMosaic draws the covers in a 2x2 grid, the size is the same as a thumbnail
+func makeMosaic(covers []string) ([]byte, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, 2*mosaicTileWidth, 2*mosaicTileHeight))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: mosaicBackground}, image.Point{}, draw.Src)
+
+	for i, coverPath := range covers {
+		f, err := os.Open(coverPath)
+		if err != nil {
+			return nil, fmt.Errorf("open cover %s: %w", coverPath, err)
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode cover %s: %w", coverPath, err)
+		}
+
+		tile := resize(img, mosaicTileWidth, mosaicTileHeight)
+		tileBounds := tile.Bounds()
+
+		// center the cover in its tile
+		x := (i%2)*mosaicTileWidth + (mosaicTileWidth-tileBounds.Dx())/2
+		y := (i/2)*mosaicTileHeight + (mosaicTileHeight-tileBounds.Dy())/2
+		draw.Draw(dst, image.Rect(x, y, x+tileBounds.Dx(), y+tileBounds.Dy()), tile, tileBounds.Min, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, fmt.Errorf("encode mosaic: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,79 @@
+package service_test
+
+import (
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerMosaic(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for _, book := range []string{"book 1", "book 2", "book 3"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "series", book), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "series", book, "book.epub"), []byte("Fixture"), 0o644))
+		writeJPEG(t, filepath.Join(dir, "series", book, "cover.jpg"), 300, 450)
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "no covers", "book"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "no covers", "book", "book.epub"), []byte("Fixture"), 0o644))
+	s := service.OPDS{TrustedRoot: dir, HideCalibreFiles: true, UseCalibreCovers: true, Mosaics: true}
+
+	// act
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+	require.NoError(t, s.Handler(w, req))
+
+	// verify only folders with covers link to a mosaic
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/image/thumbnail" href="/mosaic/series" type="image/jpeg"></link>`)
+	assert.NotContains(t, w.Body.String(), `/mosaic/no%20covers`)
+
+	// act
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/mosaic/series", nil)
+	require.NoError(t, s.Handler(w, req))
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+	etag := w.Header().Get("ETag")
+	img, err := jpeg.Decode(w.Body)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 200, 300), img.Bounds())
+
+	// a changed cover changes the mosaic
+	writeJPEG(t, filepath.Join(dir, "series", "book 1", "cover.jpg"), 30, 45)
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/mosaic/series", nil)
+	require.NoError(t, s.Handler(w, req))
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	// a folder without covers has no mosaic
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/mosaic/no%20covers", nil)
+	require.NoError(t, s.Handler(w, req))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	t.Run("without calibre covers", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir, Mosaics: true}
+
+		// act
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf", nil)))
+		mosaic := httptest.NewRecorder()
+		require.NoError(t, s.Handler(mosaic, httptest.NewRequest(http.MethodGet, "/mosaic/series", nil)))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "/mosaic/")
+		assert.Equal(t, http.StatusNotFound, mosaic.Code)
+	})
+}
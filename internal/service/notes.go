@@ -0,0 +1,46 @@
+package service
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// notesLength is the maximum number of characters of the notes of a folder shown in its feed
+const notesLength = 1000
+
+// notesNames are the files annotating the folder holding them, any case
+var notesNames = []string{"readme", "readme.txt", "readme.md", "about.txt"}
+
+// isNotesFile reports if the file in filename holds the notes of its folder
+func isNotesFile(filename string) bool {
+	return slices.Contains(notesNames, strings.ToLower(filepath.Base(filename)))
+}
+
+// folderNotes returns the text of the README or about.txt in dir truncated to notesLength
+// characters, false when there is none
+func folderNotes(dir string) (string, bool) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+
+	for _, entry := range dirEntries {
+		if entry.IsDir() || !isNotesFile(entry.Name()) {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			log.Printf("folderNotes %s err: %s", entry.Name(), err)
+			continue
+		}
+		notes := strings.TrimSpace(strings.ToValidUTF8(string(content), ""))
+		if notes == "" {
+			continue
+		}
+		return truncate(notes, notesLength), true
+	}
+	return "", false
+}
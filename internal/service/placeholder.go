@@ -0,0 +1,71 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// placeholderPathPrefix serves in /placeholder/<name> the images of the PlaceholderCovers directory
+const placeholderPathPrefix = "/placeholder/"
+
+// defaultPlaceholder is the name without extension of the placeholder of the formats without one of their own
+const defaultPlaceholder = "default"
+
+// placeholderExtensions are the extensions of the placeholder images, in order of preference
+var placeholderExtensions = []string{".png", ".jpg", ".jpeg", ".gif"}
+
+// placeholderCover returns the cover of the books without one from the PlaceholderCovers directory,
+// the image named after the format of the book, like pdf.png, or else the default one, like default.png.
+func (s OPDS) placeholderCover(bookPath string) (cover, bool) {
+	if s.PlaceholderCovers == "" {
+		return cover{}, false
+	}
+
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(bookPath), "."))
+	for _, name := range []string{format, defaultPlaceholder} {
+		if name == "" {
+			continue
+		}
+		for _, ext := range placeholderExtensions {
+			if fi, err := os.Stat(filepath.Join(s.PlaceholderCovers, name+ext)); err == nil && fi.Mode().IsRegular() {
+				return cover{
+					href:     s.href(placeholderPathPrefix + name + ext),
+					mimeType: getType(name+ext, pathTypeFile),
+				}, true
+			}
+		}
+	}
+	return cover{}, false
+}
+
+// servePlaceholder serves the placeholder image named in urlPath, only the images directly in
+// the PlaceholderCovers directory are served
+func (s OPDS) servePlaceholder(w http.ResponseWriter, req *http.Request, urlPath string) error {
+	name := strings.TrimPrefix(urlPath, placeholderPathPrefix)
+	if s.PlaceholderCovers == "" || name == "" || strings.ContainsAny(name, `/\`) || !isImage(strings.ToLower(filepath.Ext(name))) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	placeholderPath := filepath.Join(s.PlaceholderCovers, name)
+	if fi, err := os.Stat(placeholderPath); err != nil || !fi.Mode().IsRegular() {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	return serveCover(w, req, cover{mimeType: getType(placeholderPath, pathTypeFile), localPath: placeholderPath})
+}
+
+// validatePlaceholderCovers checks that the PlaceholderCovers directory exists
+func (s OPDS) validatePlaceholderCovers() error {
+	fi, err := os.Stat(s.PlaceholderCovers)
+	if err != nil {
+		return fmt.Errorf("placeholder covers %s: %w", s.PlaceholderCovers, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("placeholder covers %s is not a directory", s.PlaceholderCovers)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package service_test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerPlaceholderCovers(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for _, name := range []string{"mybook.pdf", "mybook.epub", "notes.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("Fixture"), 0o644))
+	}
+	placeholders := t.TempDir()
+	for _, name := range []string{"pdf.png", "epub.jpg", "default.png"} {
+		require.NoError(t, os.WriteFile(filepath.Join(placeholders, name), []byte("placeholder of "+name), 0o644))
+	}
+
+	s := service.OPDS{TrustedRoot: dir, PlaceholderCovers: placeholders}
+	w := httptest.NewRecorder()
+
+	// act
+	require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf", nil)))
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	var feed struct {
+		Entry []struct {
+			Title string `xml:"title"`
+			Link  []struct {
+				Rel  string `xml:"rel,attr"`
+				Href string `xml:"href,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+	require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &feed))
+
+	type image struct{ href, mimeType string }
+	covers := map[string]image{}
+	thumbnails := map[string]image{}
+	for _, entry := range feed.Entry {
+		for _, link := range entry.Link {
+			switch link.Rel {
+			case "http://opds-spec.org/image":
+				covers[entry.Title] = image{link.Href, link.Type}
+			case "http://opds-spec.org/image/thumbnail":
+				thumbnails[entry.Title] = image{link.Href, link.Type}
+			}
+		}
+	}
+	want := map[string]image{
+		"mybook.pdf":  {"/placeholder/pdf.png", "image/png"},
+		"mybook.epub": {"/placeholder/epub.jpg", "image/jpeg"},
+		"notes.txt":   {"/placeholder/default.png", "image/png"},
+	}
+	assert.Equal(t, want, covers)
+	assert.Equal(t, want, thumbnails)
+
+	t.Run("serve", func(t *testing.T) {
+		tests := map[string]struct {
+			input      string
+			wantStatus int
+			wantBody   string
+		}{
+			"placeholder":     {input: "/placeholder/pdf.png", wantStatus: http.StatusOK, wantBody: "placeholder of pdf.png"},
+			"missing":         {input: "/placeholder/cbz.png", wantStatus: http.StatusNotFound},
+			"not an image":    {input: "/placeholder/notes.txt", wantStatus: http.StatusNotFound},
+			"outside the dir": {input: "/placeholder/..%2Fmybook.png", wantStatus: http.StatusNotFound},
+		}
+
+		for name, tc := range tests {
+			t.Run(name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+
+				// act
+				require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, tc.input, nil)))
+
+				// verify
+				assert.Equal(t, tc.wantStatus, w.Code)
+				if tc.wantBody != "" {
+					assert.Equal(t, tc.wantBody, w.Body.String())
+				}
+			})
+		}
+	})
+}
+
+func TestValidatePlaceholderCovers(t *testing.T) {
+	// setup
+	s := service.OPDS{TrustedRoot: t.TempDir(), AllowUnsafeRoot: true, PlaceholderCovers: filepath.Join(t.TempDir(), "missing")}
+
+	// act
+	err := s.Validate()
+
+	// verify
+	assert.ErrorContains(t, err, "placeholder covers")
+}
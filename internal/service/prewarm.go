@@ -0,0 +1,111 @@
+package service
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Summary is the overview of a catalog counted by Prewarm
+type Summary struct {
+	Books int
+	// Formats are the number of books by format, like "epub" or "pdf"
+	Formats map[string]int
+	// WithoutCover is the number of books without a cover
+	WithoutCover int
+}
+
+func (s Summary) String() string {
+	formats := make([]string, 0, len(s.Formats))
+	for format := range s.Formats {
+		formats = append(formats, format)
+	}
+	slices.Sort(formats)
+	for i, format := range formats {
+		formats[i] = fmt.Sprintf("%d %s", s.Formats[format], format)
+	}
+	return fmt.Sprintf("%d books (%s), %d without cover", s.Books, strings.Join(formats, ", "), s.WithoutCover)
+}
+
+// Prewarm walks the TrustedRoot of opts serving the feed of every folder and the newest books
+// like the Handler does, so the metadata, covers and feeds of the first requests are cached,
+// and counts the books. The error is for a root that can't be walked.
+func Prewarm(opts OPDS) (Summary, error) {
+	summary := Summary{Formats: map[string]int{}}
+	opts, err := NewOPDS(opts)
+	if err != nil {
+		return summary, err
+	}
+
+	err = filepath.WalkDir(opts.TrustedRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("prewarm %q err: %s", path, err)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		pathRelativeToContentRoot := opts.relativePath(path)
+
+		// skip the files the feeds don't list
+		if d.IsDir() && opts.fileShouldBeIgnored(pathRelativeToContentRoot) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && (opts.fileShouldBeIgnored(d.Name()) || !opts.included(d.Name())) {
+			return nil
+		}
+
+		if d.IsDir() {
+			opts.prewarmFeed(strings.TrimSuffix("/shelf/"+escapePath(pathRelativeToContentRoot), "/"))
+			return nil
+		}
+
+		if isImage(strings.ToLower(filepath.Ext(d.Name()))) || opts.isBookCover(path) {
+			return nil
+		}
+
+		summary.Books++
+		summary.Formats[bookFormat(d.Name())]++
+		if _, ok := opts.resolveCover(path); !ok {
+			summary.WithoutCover++
+		}
+		return nil
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	opts.prewarmFeed("/new")
+	return summary, nil
+}
+
+// prewarmFeed serves the feed in urlPath discarding it
+func (s OPDS) prewarmFeed(urlPath string) {
+	req, err := http.NewRequest(http.MethodGet, urlPath, nil)
+	if err != nil {
+		log.Printf("prewarm %q err: %s", urlPath, err)
+		return
+	}
+	if err := s.Handler(discardResponse{header: http.Header{}}, req); err != nil {
+		log.Printf("prewarm %q err: %s", urlPath, err)
+	}
+}
+
+// discardResponse is a ResponseWriter for the feeds served only to fill the caches
+type discardResponse struct {
+	header http.Header
+}
+
+func (d discardResponse) Header() http.Header {
+	return d.header
+}
+
+func (d discardResponse) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (d discardResponse) WriteHeader(int) {}
@@ -0,0 +1,62 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrewarm(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "with cover"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "without cover", "nested"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".hidden"), 0o755))
+	writeEPUB(t, filepath.Join(dir, "with cover", "covered.epub"), `<dc:creator>Someone</dc:creator>`)
+	writeJPEG(t, filepath.Join(dir, "with cover", "cover.jpg"), 60, 90)
+	writeEPUB(t, filepath.Join(dir, "without cover", "bare.epub"), `<dc:creator>Someone</dc:creator>`)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "without cover", "nested", "paper.pdf"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "without cover", "nested", "other.pdf"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden", "secret.pdf"), []byte("Fixture"), 0o644))
+
+	authorized := 0
+	s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true, HideDotFiles: true, FeedCache: service.NewFeedCache(0, 0), Authorize: func(user, relPath string) bool {
+		if strings.HasSuffix(relPath, ".epub") {
+			authorized++
+		}
+		return true
+	}}
+
+	// act
+	summary, err := service.Prewarm(s)
+
+	// verify
+	require.NoError(t, err)
+	assert.Equal(t, 4, summary.Books)
+	assert.Equal(t, map[string]int{"epub": 2, "pdf": 2}, summary.Formats)
+	assert.Equal(t, 3, summary.WithoutCover)
+	assert.Equal(t, "4 books (2 epub, 2 pdf), 3 without cover", summary.String())
+
+	// the feeds of the folders are served from the cache
+	authorized = 0
+	w := httptest.NewRecorder()
+	require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf/with%20cover", nil)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "covered.epub")
+	assert.Zero(t, authorized)
+}
+
+func TestPrewarmMissingRoot(t *testing.T) {
+	// act
+	_, err := service.Prewarm(service.OPDS{TrustedRoot: filepath.Join(t.TempDir(), "missing")})
+
+	// verify
+	assert.Error(t, err)
+}
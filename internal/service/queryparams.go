@@ -0,0 +1,29 @@
+package service
+
+import (
+	"net/http"
+	"slices"
+)
+
+// queryParams are the query params of the built-in routes, the other ones are dropped
+// so they don't make different feeds or cache keys of the same feed, like ?_=12345
+var queryParams = []string{"q", "page", "days", "format", groupParam, prefixParam, "seed"}
+
+// withAllowedQuery returns req without the query params that are neither queryParams nor
+// QueryParams, the params kept are sorted so their order doesn't matter either
+func (s OPDS) withAllowedQuery(req *http.Request) *http.Request {
+	if req.URL.RawQuery == "" {
+		return req
+	}
+
+	query := req.URL.Query()
+	for name := range query {
+		if !slices.Contains(queryParams, name) && !slices.Contains(s.QueryParams, name) {
+			query.Del(name)
+		}
+	}
+
+	req = req.Clone(req.Context())
+	req.URL.RawQuery = query.Encode()
+	return req
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+// Quirks are the tweaks to the feeds for the readers not following the standards
+type Quirks struct {
+	// UnescapedSlashes keeps the slashes in the hrefs instead of escaping them as %2F,
+	// like in the link to the books of an author named "AC/DC"
+	UnescapedSlashes bool
+	// CompactOutput encodes the feeds without indentation
+	CompactOutput bool
+}
+
+// UserAgentQuirk are the quirks of the readers whose User-Agent holds Substring
+type UserAgentQuirk struct {
+	Substring string
+	Quirks    Quirks
+}
+
+// KnownQuirks are the quirks of some known readers, for UserAgentQuirks
+var KnownQuirks = []UserAgentQuirk{
+	{Substring: "FBReader", Quirks: Quirks{UnescapedSlashes: true}},
+	{Substring: "Aldiko", Quirks: Quirks{CompactOutput: true}},
+}
+
+// quirks returns the quirks of the first UserAgentQuirks matching the User-Agent of req,
+// none for the readers without quirks
+func (s OPDS) quirks(req *http.Request) Quirks {
+	userAgent := req.Header.Get("User-Agent")
+	for _, q := range s.UserAgentQuirks {
+		if q.Substring != "" && strings.Contains(userAgent, q.Substring) {
+			return q.Quirks
+		}
+	}
+	return Quirks{}
+}
+
+// applyQuirks tweaks the links of the feed and its entries for quirks
+func applyQuirks(feed *opds.Feed, quirks Quirks) {
+	if !quirks.UnescapedSlashes {
+		return
+	}
+
+	for i := range feed.Link {
+		feed.Link[i].Href = strings.ReplaceAll(feed.Link[i].Href, "%2F", "/")
+	}
+	for _, entry := range feed.Entry {
+		for i := range entry.Link {
+			entry.Link[i].Href = strings.ReplaceAll(entry.Link[i].Href, "%2F", "/")
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerUserAgentQuirks(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	writeEPUB(t, filepath.Join(dir, "highway.epub"), `<dc:creator>AC/DC</dc:creator>`)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "live"), 0o755))
+
+	configured := []service.UserAgentQuirk{
+		{Substring: "KOReader", Quirks: service.Quirks{UnescapedSlashes: true, CompactOutput: true}},
+		{Substring: "Reader", Quirks: service.Quirks{CompactOutput: true}},
+	}
+
+	tests := map[string]struct {
+		quirks    []service.UserAgentQuirk
+		userAgent string
+		wantHref  string
+		compact   bool
+		wantVary  bool
+	}{
+		"modern reader":          {quirks: service.KnownQuirks, userAgent: "KOReader/2024.04", wantHref: `href="/authors/AC%2FDC"`, wantVary: true},
+		"reader without escapes": {quirks: service.KnownQuirks, userAgent: "FBReader/3.0.6 (Android 13)", wantHref: `href="/authors/AC/DC"`, wantVary: true},
+		"compact reader":         {quirks: service.KnownQuirks, userAgent: "Aldiko Next/1.0", wantHref: `href="/authors/AC%2FDC"`, compact: true, wantVary: true},
+		"first quirks matching":  {quirks: configured, userAgent: "KOReader/2024.04", wantHref: `href="/authors/AC/DC"`, compact: true, wantVary: true},
+		"later quirks matching":  {quirks: configured, userAgent: "FBReader/3.0.6 (Android 13)", wantHref: `href="/authors/AC%2FDC"`, compact: true, wantVary: true},
+		"without quirks":         {userAgent: "FBReader/3.0.6 (Android 13)", wantHref: `href="/authors/AC%2FDC"`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, EpubMetadata: true, UserAgentQuirks: tc.quirks}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+			req.Header.Set("User-Agent", tc.userAgent)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), tc.wantHref)
+			assert.Equal(t, !tc.compact, strings.Contains(w.Body.String(), "\n  <feed"))
+			assert.Equal(t, tc.wantVary, slices.Contains(w.Header().Values("Vary"), "User-Agent"))
+		})
+	}
+
+	t.Run("the author is found without escapes", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir, EpubMetadata: true}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/authors/AC/DC", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"highway.epub"}, entryTitles(t, w.Body.Bytes()))
+	})
+
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+const randomPath = "/random"
+
+// randomSeed is the seed of the books of req: its seed query param or the number of the
+// current day, so the same books are shown all day long
+func randomSeed(req *http.Request) (uint64, error) {
+	if seed := req.URL.Query().Get("seed"); seed != "" {
+		n, err := strconv.ParseUint(seed, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("query param 'seed' must be a non negative number: %q", seed)
+		}
+		return n, nil
+	}
+	return uint64(time.Now().Unix() / (24 * 60 * 60)), nil
+}
+
+// serveRandom serves RandomBooks books picked at random, not found when RandomBooks is zero
+func (s OPDS) serveRandom(w http.ResponseWriter, req *http.Request) error {
+	if s.RandomBooks <= 0 {
+		return s.serveNotFound(w, req)
+	}
+
+	seed, err := randomSeed(req)
+	if err != nil {
+		return err
+	}
+
+	return s.serveBuiltFeed(w, req, acquisitionType, func(req *http.Request) (any, error) {
+		feed := s.makeFeedRandom(req, seed)
+		return &opds.AcquisitionFeed{Feed: &feed, Dc: "http://purl.org/dc/terms/", Opds: "http://opds-spec.org/2010/catalog"}, nil
+	})
+}
+
+// makeFeedRandom lists RandomBooks books of the catalog picked with seed, the same seed picks
+// the same books while the catalog doesn't change
+func (s OPDS) makeFeedRandom(req *http.Request, seed uint64) opds.Feed {
+	feedBuilder := opds.FeedBuilder.
+		ID(req.URL.Path).
+		Title(translate(req, "Random books")).
+		Updated(TimeNow()).
+		AddLink(s.startLink())
+
+	if !s.DisableSearch {
+		feedBuilder = feedBuilder.AddLink(s.searchLink())
+	}
+
+	// the books are walked by modification time, they are sorted by path so the
+	// picks depend only on the seed and the books in the catalog
+	files := s.walkBooks(req)
+	slices.SortFunc(files, func(a, b File) int {
+		return strings.Compare(a.filePath, b.filePath)
+	})
+
+	r := rand.New(rand.NewPCG(seed, seed))
+	r.Shuffle(len(files), func(i, j int) {
+		files[i], files[j] = files[j], files[i]
+	})
+
+	for _, file := range files[:min(s.RandomBooks, len(files))] {
+		feedBuilder = feedBuilder.AddEntry(s.makeFileEntry(file, req).Build())
+	}
+	return feedBuilder.Build()
+}
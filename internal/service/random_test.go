@@ -0,0 +1,89 @@
+package service_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerRandom(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for i := 1; i <= 10; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("book%02d.epub", i)), []byte("Fixture"), 0o644))
+	}
+	s := service.OPDS{TrustedRoot: dir, RandomBooks: 3}
+	random := func(input string) []string {
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, input, nil)))
+		require.Equal(t, http.StatusOK, w.Code, input)
+		assert.Equal(t, "application/atom+xml;profile=opds-catalog;kind=acquisition", w.Header().Get("Content-Type"))
+		return entryTitles(t, w.Body.Bytes())
+	}
+
+	// act
+	daily := random("/random")
+	today := time.Now().Unix() / (24 * 60 * 60)
+
+	// verify the seed is the number of the day
+	assert.Len(t, daily, 3)
+	assert.Equal(t, random(fmt.Sprintf("/random?seed=%d", today)), daily, "the same books all day")
+	nextDays := [][]string{}
+	for day := today + 1; day <= today+3; day++ {
+		nextDays = append(nextDays, random(fmt.Sprintf("/random?seed=%d", day)))
+	}
+	assert.NotEqual(t, [][]string{daily, daily, daily}, nextDays, "new books the next days")
+	assert.Equal(t, []string{"book01.epub", "book05.epub", "book08.epub"}, random("/random?seed=42"))
+	assert.Equal(t, random("/random?seed=42"), random("/random?seed=42"))
+}
+
+func TestHandlerRandomRoot(t *testing.T) {
+	tests := map[string]struct {
+		randomBooks int
+		wantEntry   bool
+		wantStatus  int
+	}{
+		"enabled":  {randomBooks: 5, wantEntry: true, wantStatus: http.StatusOK},
+		"disabled": {randomBooks: 0, wantEntry: false, wantStatus: http.StatusNotFound},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// setup
+			s := service.OPDS{TrustedRoot: "testdata", RandomBooks: tc.randomBooks}
+
+			// act
+			root := httptest.NewRecorder()
+			require.NoError(t, s.Handler(root, httptest.NewRequest(http.MethodGet, "/", nil)))
+			random := httptest.NewRecorder()
+			require.NoError(t, s.Handler(random, httptest.NewRequest(http.MethodGet, "/random", nil)))
+
+			// verify
+			if tc.wantEntry {
+				assert.Contains(t, root.Body.String(), `<link rel="subsection" href="/random" type="application/atom+xml;profile=opds-catalog;kind=acquisition"></link>`)
+			} else {
+				assert.NotContains(t, root.Body.String(), "/random")
+			}
+			assert.Equal(t, tc.wantStatus, random.Code)
+		})
+	}
+}
+
+func TestHandlerRandomBadSeed(t *testing.T) {
+	// setup
+	s := service.OPDS{TrustedRoot: "testdata", RandomBooks: 5}
+
+	// act
+	err := s.Handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/random?seed=x", nil))
+
+	// verify
+	assert.Error(t, err)
+}
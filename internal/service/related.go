@@ -0,0 +1,189 @@
+package service
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dubyte/dir2opds/opds"
+	"golang.org/x/tools/blog/atom"
+)
+
+const (
+	authorsPathPrefix = "/authors/"
+	seriesPathPrefix  = "/series/"
+)
+
+// bookSeries returns the calibre:series of the epub in filePath, "" when it has none
+func (s OPDS) bookSeries(filePath string) string {
+	meta, ok := s.readEpubMetadata(filePath)
+	if !ok {
+		return ""
+	}
+	return meta.Series
+}
+
+// addRelated links the entry of a book to the other books of its authors and of its series
+func (s OPDS) addRelated(req *http.Request, filePath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	for _, author := range s.bookAuthors(filePath) {
+		builder = builder.AddLink(opds.LinkBuilder.
+			Rel("related").
+			Title(translate(req, "More from %s", author)).
+			Href(s.href(authorsPathPrefix + url.PathEscape(author))).
+			Type(acquisitionType).
+			Build())
+	}
+
+	if series := s.bookSeries(filePath); series != "" {
+		builder = builder.AddLink(opds.LinkBuilder.
+			Rel("related").
+			Title(translate(req, "More from the series %s", series)).
+			Href(s.href(seriesPathPrefix + url.PathEscape(series))).
+			Type(acquisitionType).
+			Build())
+	}
+	return builder
+}
+
+// serveRelated serves the books of the author in /authors/<name> or of the series in /series/<name>,
+// 404 when there are none. /authors and /series list every author or series.
+func (s OPDS) serveRelated(w http.ResponseWriter, req *http.Request, urlPath string) error {
+	if urlPath == authorsPathPrefix || urlPath == seriesPathPrefix {
+		return s.serveBuiltFeed(w, req, navigationType, func(req *http.Request) (any, error) {
+			return s.makeFeedRelatedIndex(req, urlPath == authorsPathPrefix), nil
+		})
+	}
+
+	built, err := s.buildFeed(req, func(req *http.Request) (any, error) {
+		return s.makeFeedRelated(req, urlPath), nil
+	})
+	if err != nil {
+		log.Printf("building %q: %s", req.URL.Path, err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return nil
+	}
+
+	feed := built.(opds.Feed)
+	if len(feed.Entry) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	return s.serveFeed(w, req, &opds.AcquisitionFeed{Feed: &feed, Dc: "http://purl.org/dc/terms/", Opds: "http://opds-spec.org/2010/catalog"}, acquisitionType)
+}
+
+// makeFeedRelated lists the books of an author by title or the books of a series by their index
+func (s OPDS) makeFeedRelated(req *http.Request, urlPath string) opds.Feed {
+	name, byAuthor := strings.CutPrefix(urlPath, authorsPathPrefix)
+	if !byAuthor {
+		name = strings.TrimPrefix(urlPath, seriesPathPrefix)
+	}
+	matches := func(filePath string) bool {
+		if !byAuthor {
+			return strings.EqualFold(s.bookSeries(filePath), name)
+		}
+		for _, author := range s.bookAuthors(filePath) {
+			if strings.EqualFold(author, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	feedBuilder := opds.FeedBuilder.
+		ID(req.URL.Path).
+		Title(name).
+		Updated(TimeNow()).
+		AddLink(s.startLink())
+
+	if !s.DisableSearch {
+		feedBuilder = feedBuilder.AddLink(s.searchLink())
+	}
+
+	var files []File
+	for _, file := range s.walkBooks(req) {
+		if matches(file.filePath) {
+			files = append(files, file)
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if !byAuthor {
+			return seriesPosition(s.seriesIndex(files[i].filePath)) < seriesPosition(s.seriesIndex(files[j].filePath))
+		}
+		return strings.ToLower(files[i].fileInfo.Name()) < strings.ToLower(files[j].fileInfo.Name())
+	})
+
+	for _, file := range files {
+		feedBuilder = feedBuilder.AddEntry(s.makeFileEntry(file, req).Build())
+	}
+	return feedBuilder.Build()
+}
+
+// makeFeedRelatedIndex lists the authors or the series of the books by name with their number
+// of books, the names differing only in case are the same author or series
+func (s OPDS) makeFeedRelatedIndex(req *http.Request, byAuthor bool) opds.Feed {
+	title, prefix := translate(req, "Series"), seriesPathPrefix
+	if byAuthor {
+		title, prefix = translate(req, "Authors"), authorsPathPrefix
+	}
+
+	feedBuilder := opds.FeedBuilder.
+		ID(strings.TrimSuffix(prefix, "/")).
+		Title(title).
+		Updated(TimeNow()).
+		AddLink(s.startLink())
+
+	if !s.DisableSearch {
+		feedBuilder = feedBuilder.AddLink(s.searchLink())
+	}
+
+	// the first spelling of a name is listed
+	var names []string
+	counts := map[string]int{}
+	for _, file := range s.walkBooks(req) {
+		bookNames := s.bookAuthors(file.filePath)
+		if !byAuthor {
+			bookNames = []string{s.bookSeries(file.filePath)}
+		}
+		for _, name := range bookNames {
+			if name == "" {
+				continue
+			}
+			key := strings.ToLower(name)
+			if counts[key] == 0 {
+				names = append(names, name)
+			}
+			counts[key]++
+		}
+	}
+
+	sort.SliceStable(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+
+	for _, name := range names {
+		href := prefix + url.PathEscape(name)
+		content := atom.Text{Type: "text", Body: translate(req, "%d books.", counts[strings.ToLower(name)])}
+
+		builder := opds.EntryBuilder{}.
+			Title(name).
+			ID(href).
+			AddLink(opds.LinkBuilder.Rel("subsection").Href(s.href(href)).Type(acquisitionType).Build()).
+			Content(&content)
+
+		feedBuilder = feedBuilder.AddEntry(builder.Build())
+	}
+	return feedBuilder.Build()
+}
+
+// seriesPosition orders the books of a series, the books without index go last
+func seriesPosition(index string) float64 {
+	position, err := strconv.ParseFloat(index, 64)
+	if err != nil {
+		return float64(1 << 53)
+	}
+	return position
+}
@@ -0,0 +1,60 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerRelated(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "earthsea"), 0o755))
+	writeEPUB(t, filepath.Join(dir, "earthsea", "tombs.epub"), `<dc:creator>Ursula K. Le Guin</dc:creator><meta name="calibre:series" content="Earthsea"/><meta name="calibre:series_index" content="2"/>`)
+	writeEPUB(t, filepath.Join(dir, "earthsea", "wizard.epub"), `<dc:creator>Ursula K. Le Guin</dc:creator><meta name="calibre:series" content="Earthsea"/><meta name="calibre:series_index" content="1"/>`)
+	writeEPUB(t, filepath.Join(dir, "dispossessed.epub"), `<dc:creator>Ursula K. Le Guin</dc:creator>`)
+	writeEPUB(t, filepath.Join(dir, "dune.epub"), `<dc:creator>Frank Herbert</dc:creator>`)
+	s := service.OPDS{TrustedRoot: dir, EpubMetadata: true}
+
+	// act
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf/earthsea", nil)
+	require.NoError(t, s.Handler(w, req))
+
+	// verify the books link to more from their author and their series
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<link rel="related" href="/authors/Ursula%20K.%20Le%20Guin" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="More from Ursula K. Le Guin"></link>`)
+	assert.Contains(t, w.Body.String(), `<link rel="related" href="/series/Earthsea" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="More from the series Earthsea"></link>`)
+
+	tests := map[string]struct {
+		input            string
+		wantedStatusCode int
+		want             []string
+	}{
+		"author":         {input: "/authors/Ursula%20K.%20Le%20Guin", wantedStatusCode: 200, want: []string{"dispossessed.epub", "tombs.epub", "wizard.epub"}},
+		"series":         {input: "/series/Earthsea", wantedStatusCode: 200, want: []string{"wizard.epub", "tombs.epub"}},
+		"unknown author": {input: "/authors/Nobody", wantedStatusCode: 404},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, tc.wantedStatusCode, w.Code)
+			if tc.want != nil {
+				assert.Equal(t, tc.want, entryTitles(t, w.Body.Bytes()))
+			}
+		})
+	}
+}
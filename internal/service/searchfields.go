@@ -0,0 +1,112 @@
+package service
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+// searchFields are the prefixes of the search terms matching a field of the books instead of their
+// file name, like author:tolkien or title:"the hobbit"
+var searchFields = []string{"author", "title"}
+
+// searchQuery is a search split in the terms matching the file names and the ones matching a field
+type searchQuery struct {
+	// name is the text of the terms without prefix, the file names must contain it
+	name string
+	// fields are the terms with a prefix by field, the field must contain every term
+	fields map[string][]string
+}
+
+// parseSearchQuery splits query in its terms, the value of a prefixed term may be quoted to hold spaces
+func parseSearchQuery(query string) searchQuery {
+	q := searchQuery{fields: map[string][]string{}}
+
+	var names []string
+	for _, term := range splitSearchTerms(query) {
+		field, value, ok := strings.Cut(term, ":")
+		field = strings.ToLower(field)
+		if !ok || value == "" || !slices.Contains(searchFields, field) {
+			names = append(names, strings.Trim(term, `"`))
+			continue
+		}
+		q.fields[field] = append(q.fields[field], strings.ToLower(strings.Trim(value, `"`)))
+	}
+	q.name = strings.ToLower(strings.Join(names, " "))
+	return q
+}
+
+// splitSearchTerms splits query at the spaces out of double quotes
+func splitSearchTerms(query string) []string {
+	var terms []string
+	var term strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			term.WriteRune(r)
+		case r == ' ' && !quoted:
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+		default:
+			term.WriteRune(r)
+		}
+	}
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+	return terms
+}
+
+// matchesName reports if the file name holds the terms without prefix
+func (q searchQuery) matchesName(name string) bool {
+	return strings.Contains(strings.ToLower(name), q.name)
+}
+
+// hasFields reports if the query has prefixed terms, the metadata of the books is needed to match them
+func (q searchQuery) hasFields() bool {
+	return len(q.fields) > 0
+}
+
+// matchesEntry reports if the entry of the book in filePath holds the prefixed terms: the authors
+// in its authors and the titles in its title, its translations or the titles of its epub metadata
+func (s OPDS) matchesEntry(q searchQuery, filePath string, entry opds.Entry) bool {
+	var authors []string
+	for _, author := range entry.Author {
+		authors = append(authors, author.Name)
+	}
+
+	titles := []string{entry.Title}
+	for _, title := range entry.Titles {
+		titles = append(titles, title.Value)
+	}
+	if meta, ok := s.readEpubMetadata(filePath); ok {
+		for _, title := range meta.Titles {
+			titles = append(titles, title.Value)
+		}
+	}
+
+	values := map[string][]string{"author": authors, "title": titles}
+	for field, terms := range q.fields {
+		for _, term := range terms {
+			if !containsFold(values[field], term) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// containsFold reports if any of values contains the lowercase term ignoring case
+func containsFold(values []string, term string) bool {
+	for _, value := range values {
+		if strings.Contains(strings.ToLower(value), term) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerSearchFields(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "Tolkien"), 0o755))
+	writeEPUB(t, filepath.Join(dir, "Tolkien", "hobbit.epub"), `<dc:title>The Hobbit</dc:title><dc:creator>J. R. R. Tolkien</dc:creator>`)
+	writeEPUB(t, filepath.Join(dir, "Tolkien", "silmarillion.epub"), `<dc:title>The Silmarillion</dc:title><dc:creator>J. R. R. Tolkien</dc:creator>`)
+	writeEPUB(t, filepath.Join(dir, "narnia.epub"), `<dc:title>The Lion, the Witch and the Wardrobe</dc:title><dc:creator>C. S. Lewis</dc:creator>`)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hobbit notes.txt"), []byte("Fixture"), 0o644))
+
+	tests := map[string]struct {
+		query string
+		want  []string
+	}{
+		"author":                     {query: "author:tolkien", want: []string{"hobbit.epub", "silmarillion.epub"}},
+		"author ignoring case":       {query: "AUTHOR:Lewis", want: []string{"narnia.epub"}},
+		"title":                      {query: "title:silmarillion", want: []string{"silmarillion.epub"}},
+		"quoted title":               {query: `title:"the hobbit"`, want: []string{"hobbit.epub"}},
+		"title of the file name":     {query: "title:notes", want: []string{"hobbit notes.txt"}},
+		"author and title":           {query: "author:tolkien title:hobbit", want: []string{"hobbit.epub"}},
+		"author and file name":       {query: "author:tolkien silma", want: []string{"silmarillion.epub"}},
+		"file name":                  {query: "hobbit", want: []string{"hobbit.epub", "hobbit notes.txt"}},
+		"unknown field is file name": {query: "series:tolkien", want: []string{}},
+		"no match":                   {query: "author:tolkien title:wardrobe", want: []string{}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, EpubMetadata: true}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/search?q="+url.QueryEscape(tc.query), nil)
+
+			// act
+			err := s.Handler(w, req)
+
+			// verify
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, entryTitles(t, w.Body.Bytes()))
+		})
+	}
+}
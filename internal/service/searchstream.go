@@ -0,0 +1,100 @@
+package service
+
+import (
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+// serveSearchStream writes the feed of the results of query entry by entry as the walk finds
+// them, so readers show the first results before the whole catalog was searched. The
+// opensearch:totalResults is only known at the end, it is written after the entries.
+func (s OPDS) serveSearchStream(w http.ResponseWriter, req *http.Request, query string) error {
+	feed := s.searchFeed(req, query)
+	if s.Provider.Name != "" {
+		provider := s.Provider
+		feed.Author = &provider
+	}
+
+	if s.HTML {
+		w.Header().Add("Vary", "Accept")
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	if len(s.UserAgentQuirks) > 0 {
+		w.Header().Add("Vary", "User-Agent")
+	}
+	quirks := s.quirks(req)
+	applyQuirks(&feed, quirks)
+
+	w.Header().Add("Content-Type", acquisitionType)
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return nil
+	}
+
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	if !s.CompactOutput && !quirks.CompactOutput {
+		enc.Indent("  ", "    ")
+	}
+	flush := func() error {
+		if err := enc.Flush(); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return nil
+	}
+
+	start := xml.StartElement{Name: xml.Name{Space: "http://www.w3.org/2005/Atom", Local: "feed"}, Attr: []xml.Attr{
+		{Name: xml.Name{Local: "xmlns:dc"}, Value: "http://purl.org/dc/terms/"},
+		{Name: xml.Name{Local: "xmlns:opds"}, Value: "http://opds-spec.org/2010/catalog"},
+		{Name: xml.Name{Local: "xmlns:opensearch"}, Value: "http://a9.com/-/spec/opensearch/1.1/"},
+	}}
+	element := func(local string) xml.StartElement {
+		return xml.StartElement{Name: xml.Name{Local: local}}
+	}
+	head := []func() error{
+		func() error { return enc.EncodeToken(start) },
+		func() error { return enc.EncodeElement(feed.Title, element("title")) },
+		func() error { return enc.EncodeElement(feed.ID, element("id")) },
+		func() error { return enc.EncodeElement(feed.Link, element("link")) },
+		func() error { return enc.EncodeElement(feed.Updated, element("updated")) },
+		func() error { return enc.EncodeElement(feed.Author, element("author")) },
+		flush,
+	}
+	for _, encode := range head {
+		if err := encode(); err != nil {
+			log.Printf("error while streaming '%s': %s", req.URL.Path, err)
+			return nil
+		}
+	}
+
+	count := s.walkSearch(req, query, func(entry opds.Entry) error {
+		if s.MaxTitleLength > 0 {
+			truncateTitles(&entry, s.MaxTitleLength)
+		}
+		applyQuirks(&opds.Feed{Entry: []*opds.Entry{&entry}}, quirks)
+		if err := enc.EncodeElement(entry, element("entry")); err != nil {
+			return err
+		}
+		return flush()
+	})
+
+	tail := []func() error{
+		func() error { return enc.EncodeElement(count, element("opensearch:totalResults")) },
+		func() error { return enc.EncodeToken(start.End()) },
+		flush,
+	}
+	for _, encode := range tail {
+		if err := encode(); err != nil {
+			log.Printf("error while streaming '%s': %s", req.URL.Path, err)
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package service_test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/blog/atom"
+)
+
+type searchResults struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    []atom.Link `xml:"link"`
+	Entries []struct {
+		Title string      `xml:"title"`
+		ID    string      `xml:"id"`
+		Link  []atom.Link `xml:"link"`
+	} `xml:"entry"`
+	TotalResults int `xml:"totalResults"`
+}
+
+func TestHandlerStreamSearch(t *testing.T) {
+	// setup
+	search := func(s service.OPDS) (*httptest.ResponseRecorder, searchResults) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/search?q=mybook", nil)
+		require.NoError(t, s.Handler(w, req))
+
+		var results searchResults
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &results))
+		return w, results
+	}
+	_, want := search(service.OPDS{TrustedRoot: "testdata", HideDotFiles: true, UseCalibreCovers: true})
+
+	// act
+	w, got := search(service.OPDS{TrustedRoot: "testdata", HideDotFiles: true, UseCalibreCovers: true, StreamSearch: true})
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/atom+xml;profile=opds-catalog;kind=acquisition", w.Header().Get("Content-Type"))
+	assert.True(t, w.Flushed)
+	assert.Len(t, got.Entries, 8)
+	assert.Equal(t, want, got)
+	assert.Equal(t, 8, got.TotalResults)
+}
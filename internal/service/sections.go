@@ -0,0 +1,70 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/dubyte/dir2opds/opds"
+	"golang.org/x/tools/blog/atom"
+)
+
+// the values of RootSections
+const (
+	sectionNew      = "new"
+	sectionShelf    = "shelf"
+	sectionRandom   = "random"
+	sectionCalendar = "calendar"
+	sectionAuthors  = "authors"
+	sectionSeries   = "series"
+)
+
+// rootSections are the built-in feeds the root feed may list
+var rootSections = []string{sectionNew, sectionShelf, sectionRandom, sectionCalendar, sectionAuthors, sectionSeries}
+
+// rootSections returns the sections listed in the root feed, none with CustomRootOnly
+func (s OPDS) rootSections() []string {
+	if s.CustomRootOnly {
+		return nil
+	}
+	if len(s.RootSections) > 0 {
+		return s.RootSections
+	}
+
+	sections := []string{sectionNew, sectionShelf}
+	if s.RandomBooks > 0 {
+		sections = append(sections, sectionRandom)
+	}
+	return sections
+}
+
+// rootSectionEntry returns the entry of the root feed linking to the feed of section
+func (s OPDS) rootSectionEntry(req *http.Request, section string) opds.Entry {
+	var title, href, rel, linkType string
+	var content atom.Text
+	switch section {
+	case sectionNew:
+		title, href, rel, linkType = translate(req, "Newest books"), "/new", "http://opds-spec.org/sort/new", acquisitionType
+		content = atom.Text{Type: "text", Body: translate(req, "The 15 latest modified books, most-recently-modified first.")}
+	case sectionShelf:
+		title, href, rel, linkType = s.shelfTitle(req), "/shelf", shelfRel, acquisitionType
+		content = atom.Text{Type: "text", Body: translate(req, "All books.")}
+	case sectionRandom:
+		title, href, rel, linkType = translate(req, "Random books"), randomPath, shelfRel, acquisitionType
+		content = atom.Text{Type: "text", Body: translate(req, "%d books picked at random, new ones every day.", s.RandomBooks)}
+	case sectionCalendar:
+		title, href, rel, linkType = translate(req, "Books by date"), calendarPath, shelfRel, navigationType
+		content = atom.Text{Type: "text", Body: translate(req, "The books by the year and month they were modified.")}
+	case sectionAuthors:
+		title, href, rel, linkType = translate(req, "Authors"), "/authors", shelfRel, navigationType
+		content = atom.Text{Type: "text", Body: translate(req, "The books by author.")}
+	case sectionSeries:
+		title, href, rel, linkType = translate(req, "Series"), "/series", shelfRel, navigationType
+		content = atom.Text{Type: "text", Body: translate(req, "The books by series.")}
+	}
+
+	return opds.EntryBuilder{}.
+		Title(title).
+		ID(href).
+		AddLink(opds.LinkBuilder.Href(s.href(href)).Rel(rel).Type(linkType).Build()).
+		Content(&content).
+		Build()
+}
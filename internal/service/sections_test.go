@@ -0,0 +1,95 @@
+package service_test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/blog/atom"
+)
+
+func TestHandlerRootSections(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	writeEPUB(t, filepath.Join(dir, "hobbit.epub"), `<dc:creator>J. R. R. Tolkien</dc:creator><meta name="calibre:series" content="Middle-earth"/>`)
+	writeEPUB(t, filepath.Join(dir, "silmarillion.epub"), `<dc:creator>j. r. r. tolkien</dc:creator><meta name="calibre:series" content="Middle-earth"/>`)
+	writeEPUB(t, filepath.Join(dir, "narnia.epub"), `<dc:creator>C. S. Lewis</dc:creator>`)
+	// the spelling of the newest book is listed
+	modTime := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "hobbit.epub"), modTime, modTime))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "silmarillion.epub"), modTime.Add(-time.Hour), modTime.Add(-time.Hour)))
+
+	type link struct {
+		Title string
+		Href  string
+	}
+	links := func(t *testing.T, body []byte) []link {
+		var feed struct {
+			Entries []struct {
+				Title string      `xml:"title"`
+				Link  []atom.Link `xml:"link"`
+			} `xml:"entry"`
+		}
+		require.NoError(t, xml.Unmarshal(body, &feed))
+		var got []link
+		for _, entry := range feed.Entries {
+			got = append(got, link{Title: entry.Title, Href: entry.Link[0].Href})
+		}
+		return got
+	}
+
+	tests := map[string]struct {
+		s     service.OPDS
+		input string
+		want  []link
+	}{
+		"default sections": {
+			s:     service.OPDS{TrustedRoot: dir},
+			input: "/",
+			want:  []link{{Title: "Newest books", Href: "/new"}, {Title: "All books", Href: "/shelf"}},
+		},
+		"authors and series": {
+			s:     service.OPDS{TrustedRoot: dir, RootSections: []string{"authors", "series"}},
+			input: "/",
+			want:  []link{{Title: "Authors", Href: "/authors"}, {Title: "Series", Href: "/series"}},
+		},
+		"authors": {
+			s:     service.OPDS{TrustedRoot: dir, EpubMetadata: true},
+			input: "/authors",
+			want:  []link{{Title: "C. S. Lewis", Href: "/authors/C.%20S.%20Lewis"}, {Title: "J. R. R. Tolkien", Href: "/authors/J.%20R.%20R.%20Tolkien"}},
+		},
+		"series": {
+			s:     service.OPDS{TrustedRoot: dir, EpubMetadata: true},
+			input: "/series/",
+			want:  []link{{Title: "Middle-earth", Href: "/series/Middle-earth"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			err := tc.s.Handler(w, req)
+
+			// verify
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.want, links(t, w.Body.Bytes()))
+		})
+	}
+
+	t.Run("unknown section", func(t *testing.T) {
+		assert.Error(t, service.OPDS{TrustedRoot: dir, RootSections: []string{"tags"}}.Validate())
+		assert.Error(t, service.OPDS{TrustedRoot: dir, RootSections: []string{"random"}}.Validate(), "random needs random books")
+		assert.NoError(t, service.OPDS{TrustedRoot: dir, RootSections: []string{"new", "random"}, RandomBooks: 3}.Validate())
+	})
+}
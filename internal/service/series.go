@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultSeriesTitleFormat formats the padded series index and the title like "03 - Title"
+const defaultSeriesTitleFormat = "%s - %s"
+
+// seriesIndex returns the calibre:series_index of the epub in filePath, "" when it has none
+func (s OPDS) seriesIndex(filePath string) string {
+	meta, ok := s.readEpubMetadata(filePath)
+	if !ok {
+		return ""
+	}
+	return meta.SeriesIndex
+}
+
+// seriesIndexWidth returns the width the series indices of the books in a folder are
+// zero-padded to, the SeriesIndexWidth or the width of the largest index when it is zero
+func (s OPDS) seriesIndexWidth(fpath string, dirEntries []os.DirEntry) int {
+	if !s.SeriesIndexTitles {
+		return 0
+	}
+	if s.SeriesIndexWidth > 0 {
+		return s.SeriesIndexWidth
+	}
+
+	var width int
+	for _, entry := range dirEntries {
+		if entry.IsDir() || s.fileShouldBeIgnored(entry.Name()) {
+			continue
+		}
+		width = max(width, len(seriesIndexInteger(s.seriesIndex(filepath.Join(fpath, entry.Name())))))
+	}
+	return width
+}
+
+// folderSeriesIndexWidth is the seriesIndexWidth of the folder in dirPath
+func (s OPDS) folderSeriesIndexWidth(dirPath string) int {
+	if !s.SeriesIndexTitles {
+		return 0
+	}
+
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return 0
+	}
+	return s.seriesIndexWidth(dirPath, dirEntries)
+}
+
+// seriesTitle prefixes title, when SeriesIndexTitles is enabled, with the series index of the book in filePath zero-padded to width,
+// so readers sorting alphabetically keep the reading order. A whole index like 2.0 is shown as 2.
+func (s OPDS) seriesTitle(filePath, title string, width int) string {
+	if !s.SeriesIndexTitles {
+		return title
+	}
+
+	index := s.seriesIndex(filePath)
+	if index == "" {
+		return title
+	}
+
+	integer := seriesIndexInteger(index)
+	_, fraction, _ := strings.Cut(index, ".")
+	if len(integer) < width {
+		integer = strings.Repeat("0", width-len(integer)) + integer
+	}
+	if fraction = strings.TrimRight(fraction, "0"); fraction != "" {
+		integer += "." + fraction
+	}
+
+	format := s.SeriesTitleFormat
+	if format == "" {
+		format = defaultSeriesTitleFormat
+	}
+	return fmt.Sprintf(format, integer, title)
+}
+
+// seriesIndexInteger returns the integer part of a series index without leading zeros, "" for no index
+func seriesIndexInteger(index string) string {
+	if index == "" {
+		return ""
+	}
+	integer, _, _ := strings.Cut(index, ".")
+	if integer = strings.TrimLeft(integer, "0"); integer == "" {
+		return "0"
+	}
+	return integer
+}
@@ -0,0 +1,82 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerSeriesIndexTitles(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for name, index := range map[string]string{"Dune.epub": "1", "Dune Messiah.epub": "2.0", "Sandworms of Dune.epub": "10", "Prelude.epub": "1.5"} {
+		writeEPUB(t, filepath.Join(dir, name), `<meta name="calibre:series_index" content="`+index+`"/>`)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("Fixture"), 0o644))
+
+	tests := map[string]struct {
+		s    service.OPDS
+		want []string
+	}{
+		"disabled": {
+			s:    service.OPDS{TrustedRoot: dir, EpubMetadata: true},
+			want: []string{"Dune Messiah.epub", "Dune.epub", "Prelude.epub", "Sandworms of Dune.epub", "notes.txt"},
+		},
+		"padded to the largest index": {
+			s:    service.OPDS{TrustedRoot: dir, EpubMetadata: true, SeriesIndexTitles: true},
+			want: []string{"01 - Dune.epub", "01.5 - Prelude.epub", "02 - Dune Messiah.epub", "10 - Sandworms of Dune.epub", "notes.txt"},
+		},
+		"configured width and format": {
+			s:    service.OPDS{TrustedRoot: dir, EpubMetadata: true, SeriesIndexTitles: true, SeriesIndexWidth: 3, SeriesTitleFormat: "[%s] %s"},
+			want: []string{"[001.5] Prelude.epub", "[001] Dune.epub", "[002] Dune Messiah.epub", "[010] Sandworms of Dune.epub", "notes.txt"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+			// act
+			require.NoError(t, tc.s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			titles := entryTitles(t, w.Body.Bytes())
+			sort.Strings(titles)
+			assert.Equal(t, tc.want, titles)
+		})
+	}
+}
+
+func TestValidateSeriesTitleFormat(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]struct {
+		format  string
+		wantErr bool
+	}{
+		"default":         {format: ""},
+		"custom":          {format: "[%s] %s"},
+		"literal percent": {format: "%s%% %s"},
+		"missing title":   {format: "%s", wantErr: true},
+		"extra verb":      {format: "%s - %s (%s)", wantErr: true},
+		"other verb":      {format: "%d - %s", wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// act
+			err := service.OPDS{TrustedRoot: dir, SeriesTitleFormat: tc.format}.Validate()
+
+			// verify
+			assert.Equal(t, tc.wantErr, err != nil, err)
+		})
+	}
+}
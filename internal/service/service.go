@@ -5,9 +5,12 @@ package service
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"mime"
@@ -15,8 +18,13 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dubyte/dir2opds/search"
@@ -30,8 +38,12 @@ func init() {
 	_ = mime.AddExtensionType(".epub", "application/epub+zip")
 	_ = mime.AddExtensionType(".cbz", "application/x-cbz")
 	_ = mime.AddExtensionType(".cbr", "application/x-cbr")
+	_ = mime.AddExtensionType(".zip", "application/zip")
 	_ = mime.AddExtensionType(".fb2", "text/fb2+xml")
 	_ = mime.AddExtensionType(".pdf", "application/pdf")
+	_ = mime.AddExtensionType(".m4b", "audio/mp4")
+	_ = mime.AddExtensionType(".m4a", "audio/mp4")
+	_ = mime.AddExtensionType(".mp3", "audio/mpeg")
 }
 
 const (
@@ -54,6 +66,321 @@ type OPDS struct {
 	UseCalibreCovers bool
 	HideDotFiles     bool
 	NoCache          bool
+	// Title is the name of the catalog shown by clients, e.g. as the name of its search, "dir2opds" when empty.
+	Title string
+	// Description of the catalog shown by clients, e.g. as the description of its search.
+	Description string
+	// ShelfTitle is the title of the root entry linking to every folder, "All books" when empty.
+	ShelfTitle string
+	// Provider is the author of every feed, the contact of the catalog provider.
+	// It is omitted when its Name is empty.
+	Provider atom.Person
+	// Authorize when set decides if a user may see a path relative to the TrustedRoot,
+	// the entries it denies are omitted and accessing them returns 403.
+	// The user is the basic auth user of the request, authenticated before reaching the Handler.
+	Authorize func(user string, relPath string) bool
+	// BlockFunc when set decides if a path relative to the TrustedRoot is unavailable for legal reasons
+	// to req, like in its region. The entries it blocks are omitted and accessing them returns 451.
+	BlockFunc func(relPath string, req *http.Request) bool
+	// BasePath is the path the catalog is mounted at, e.g. /opds serves the root feed
+	// at /opds and prefixes every href with it. Empty mounts it at the root.
+	BasePath string
+	// StartHref is the target of the rel="start" link of every feed, "/" when empty.
+	StartHref string
+	// NavEntries are extra entries shown in the root feed after the built-in ones.
+	NavEntries []NavEntry
+	// RootSections are the built-in feeds listed in the root feed in order: "new", "shelf",
+	// "random", "calendar", "authors" and "series". Empty lists the newest and all the books,
+	// and the random books with RandomBooks.
+	RootSections []string
+	// CustomRootOnly leaves out the RootSections of the root feed so only the NavEntries
+	// are shown, their feeds like /new and /shelf are still served.
+	CustomRootOnly bool
+	// Version of dir2opds reported in /about.
+	Version string
+	// HrefRewriter when set rewrites every generated href,
+	// useful behind proxies that rewrite paths.
+	HrefRewriter func(string) string
+	// Thumbnails resizes the thumbnails of the books with a cover on the fly, the full cover is their thumbnail otherwise.
+	Thumbnails bool
+	// HashedCovers links the covers at /covers/<sha-256 of the image> with caching headers letting
+	// the clients keep them forever, the url changes with the image.
+	HashedCovers bool
+	// HashedCoverIndex when set remembers the books of the hashed covers linked from the feeds to
+	// serve them, the covers it doesn't hold are not found and it is rebuilt in the background.
+	HashedCoverIndex *HashedCoverIndex
+	// ThumbnailFormat is the preferred mime type of thumbnails for clients accepting it,
+	// image/jpeg is used otherwise. See RegisterThumbnailEncoder.
+	ThumbnailFormat string
+	// SidecarMetadata reads the metadata of the books in a folder from its metadata.json.
+	SidecarMetadata bool
+	// FolderNotes shows the README or about.txt of a folder as the subtitle of its feed instead of listing it.
+	FolderNotes bool
+	// LibraryStats when set shows the number of books and their size as the subtitle of the root feed,
+	// like "1,234 books · 45 GB". Counting them walks the whole library, at most every 10 minutes.
+	LibraryStats *LibraryStats
+	// Mosaics adds a thumbnail to folders made from the covers of up to four of their books.
+	// It requires UseCalibreCovers.
+	Mosaics bool
+	// BuildTimeout limits the time to build a feed, 503 is returned when it expires.
+	// Zero means no limit.
+	BuildTimeout time.Duration
+	// StreamSearch writes the search results as they are found instead of once the whole catalog
+	// was searched, their total is written after them. MaxFeedBytes and BuildTimeout don't apply.
+	StreamSearch bool
+	// GroupBy groups the books of the folder feeds by "format" or by the first "letter" of their
+	// title: the books of a group are listed together and link with the collection rel to the
+	// feed of their group, which only lists them. Empty doesn't group them.
+	GroupBy string
+	// FolderInTitles qualifies the titles of the books in the flat feeds, like /new or the search
+	// results, with the name of their folder: "prefix" lists "Tolkien — The Hobbit.epub" and
+	// "suffix" lists "The Hobbit.epub — Tolkien". Empty lists their titles alone.
+	FolderInTitles string
+	// QueryParams are the query params kept besides the ones of the built-in routes, like q or page.
+	// The other ones are dropped before building the feeds and their cache keys.
+	QueryParams []string
+	// Extensionless is what to do with the files without extension: "skip" doesn't list them,
+	// "default" gives them the ExtensionlessType and "sniff" detects their type from their content,
+	// falling back to the ExtensionlessType. Empty lists them without a type.
+	Extensionless string
+	// ExtensionlessType is the type of the files without extension, application/octet-stream when it is empty.
+	ExtensionlessType string
+	// ArchiveFormats tells the types of the books inside the zips, like an epub and a pdf, with
+	// opds:indirectAcquisition so the readers know what they get after unarchiving them.
+	ArchiveFormats bool
+	// ComicZips lists the zips holding only images as comics, like the cbz files, instead of as archives.
+	ComicZips bool
+	// BookLength adds the page count of pdfs and the approximate word count of epubs to their summary.
+	// Computing them reads the whole book so they are cached until the file changes.
+	BookLength bool
+	// BookFolders presents a folder holding one book in several formats, or the chapters
+	// of an audiobook, as a single book in /book/<path> instead of one entry per file.
+	BookFolders bool
+	// BookFormatFacets links a facet per format in the feed of a book folder, the facet of
+	// a format only holds its acquisition link for readers that can't pick between several.
+	BookFormatFacets bool
+	// HTML serves the feeds as html pages to clients accepting text/html, like browsers.
+	HTML bool
+	// OpenAccess marks the downloads as open-access acquisitions,
+	// some readers then download them directly instead of starting a purchase flow.
+	OpenAccess bool
+	// FolderUpdated sets the updated time of the folder entries from the newest modification
+	// time of the files and folders they hold.
+	FolderUpdated bool
+	// FolderUpdatedDeep makes FolderUpdated look into every subfolder, not only the direct contents.
+	FolderUpdatedDeep bool
+	// MagazineMode lists a folder named after a date holding a single pdf, like Magazine/2023-01/issue.pdf,
+	// as an issue published on that date instead of as a folder, /new sorts the issues by their date.
+	MagazineMode bool
+	// MetadataWorkers is the number of books of a folder whose entries, and metadata, are built
+	// at the same time. Zero uses one per CPU.
+	MetadataWorkers int
+	// UserAgentQuirks are the tweaks to the feeds for the readers by a substring of their User-Agent,
+	// the first one matching applies, like KnownQuirks. The other readers get the feeds as they are.
+	UserAgentQuirks []UserAgentQuirk
+	// FeedCache when set keeps the feeds of the folders until their listing, or the listings of the
+	// subfolders their entries are made from, change, unless NoCache or the BlockFunc, deciding
+	// for each request, is set.
+	FeedCache *FeedCache
+	// RandomBooks is the number of books of the /random feed, picked at random every day.
+	// Zero disables the feed.
+	RandomBooks int
+	// InlinePreview serves the images and pdfs with an inline Content-Disposition so browsers
+	// show them, the other files are still served as attachments to download.
+	InlinePreview bool
+	// CacheDir when set keeps the checksums, the covers extracted from the epubs, the thumbnails
+	// and the mosaics in files so they survive restarts and the covers are served from them. Empty keeps
+	// the checksums and mosaics only in memory and reads the covers from the epubs every time they are served.
+	// It must be outside of the TrustedRoot.
+	CacheDir string
+	// FirstSeen sets the time a book was first listed as the published date of its entry and its
+	// modification time as the updated date. The first listings are kept in the CacheDir,
+	// without it both dates are the modification time.
+	FirstSeen bool
+	// PlaceholderCovers is a directory with the covers of the books without one, named after
+	// their format like epub.png, pdf.png or cbz.jpg, default.png covers the other formats.
+	PlaceholderCovers string
+	// NotFound answers the routes that don't exist, a feed linking to the start of the catalog when it is nil.
+	NotFound http.Handler
+	// Sort orders the books of a folder by their title case-insensitively with "title", the default,
+	// the most recently modified first with "date" or keeps the order of the directory, by file name,
+	// with "name". The folders are listed first.
+	Sort string
+	// MaxEntriesPerFeed is the maximum number of books listed in the feed of a folder, the first ones
+	// once sorted. Zero lists them all.
+	MaxEntriesPerFeed int
+	// DisableSearch removes the search links from the feeds and stops serving /opensearch.xml,
+	// /suggest and /search.
+	DisableSearch bool
+	// WebpubManifests serves in /manifest/<path> a Readium Web Publication Manifest of the epubs,
+	// linked from their entries, for the readers streaming them instead of downloading them.
+	WebpubManifests bool
+	// IncludeOnly when set lists and serves only the files whose name matches it, like \.epub$.
+	// Covers are still served and the HideDotFiles and HideCalibreFiles rules still apply.
+	IncludeOnly *regexp.Regexp
+	// MinFileSize when positive hides the files smaller than that many bytes, like placeholders,
+	// they are not listed and not served. Covers are still served.
+	MinFileSize int64
+	// EbookExtensionsOnly classifies a folder as a folder of books only when it holds
+	// ebooks, other files like notes.txt don't make it an acquisition feed.
+	EbookExtensionsOnly bool
+	// AuthorFromFolder sets the author of the books from their folders,
+	// for libraries organized as Author/Title/book.epub or Author/book.epub.
+	AuthorFromFolder bool
+	// EpubMetadata reads the metadata of the epubs, like their authors or their cover, from their package document.
+	EpubMetadata bool
+	// EmbedCovers serves in /withcover/<path> the epubs without a cover with the cover.jpg of their folder
+	// added to them, linked from their entries. It requires UseCalibreCovers.
+	EmbedCovers bool
+	// DefaultRights is the license of the books, like "CC BY-SA 4.0", the dc:rights of an epub
+	// replaces it with EpubMetadata. The entries have no rights when both are empty.
+	DefaultRights string
+	// ExternalReaderTemplate links the books to a web reader, its {href} is replaced with the
+	// escaped download url of the book, like https://reader.example/open?url={href}.
+	// Empty disables the links.
+	ExternalReaderTemplate string
+	// ExternalReaderRel is the rel of the links to the web reader, "alternate" when it is empty.
+	ExternalReaderRel string
+	// AuthorSeparator splits an epub with a single creator like "A & B" in several authors,
+	// empty disables the split.
+	AuthorSeparator string
+	// IncludeChecksums adds the sha-256 of the books as their dc:identifier for integrity checks
+	// and deduplication. Hashing reads the whole book so it is cached until the file changes.
+	IncludeChecksums bool
+	// RobotsTxt is the policy served in /robots.txt, it disallows every crawler when empty.
+	RobotsTxt string
+	// MaxTitleLength truncates the titles of the entries longer than that many characters
+	// with an ellipsis, the hrefs keep the full file names. Zero means no limit.
+	MaxTitleLength int
+	// CompactOutput encodes the feeds without indentation, smaller for clients with little bandwidth.
+	CompactOutput bool
+	// MaxFeedBytes limits the size of a feed, 413 is returned instead of a feed that
+	// outgrows it so a huge folder can't exhaust the memory. Zero means no limit.
+	MaxFeedBytes int
+	// SeriesIndexTitles prefixes the titles of the epubs in a folder with their zero-padded
+	// calibre:series_index, like "03 - Title", so readers sorting alphabetically keep the
+	// reading order. It requires EpubMetadata.
+	SeriesIndexTitles bool
+	// SeriesTitleFormat formats the padded series index and the title, "%s - %s" when empty.
+	SeriesTitleFormat string
+	// SeriesIndexWidth is the width the series indices are zero-padded to,
+	// zero pads them to the largest index of the folder.
+	SeriesIndexWidth int
+	// AllowUnsafeRoot allows serving the filesystem root or the home directory.
+	AllowUnsafeRoot bool
+}
+
+// Validate returns an error when the TrustedRoot does not exist or it would expose
+// too much, like the filesystem root or the home directory, unless AllowUnsafeRoot is set.
+func (s OPDS) Validate() error {
+	if s.Sort != "" && s.Sort != sortTitle && s.Sort != sortDate && s.Sort != sortName {
+		return fmt.Errorf("sort %q must be %q, %q or %q", s.Sort, sortTitle, sortDate, sortName)
+	}
+
+	if s.Extensionless != "" && s.Extensionless != extensionlessSkip && s.Extensionless != extensionlessDefault && s.Extensionless != extensionlessSniff {
+		return fmt.Errorf("extensionless %q must be %q, %q or %q", s.Extensionless, extensionlessSkip, extensionlessDefault, extensionlessSniff)
+	}
+
+	if s.GroupBy != "" && s.GroupBy != groupByFormat && s.GroupBy != groupByLetter {
+		return fmt.Errorf("group by %q must be %q or %q", s.GroupBy, groupByFormat, groupByLetter)
+	}
+
+	if s.FolderInTitles != "" && s.FolderInTitles != folderInTitlesPrefix && s.FolderInTitles != folderInTitlesSuffix {
+		return fmt.Errorf("folder in titles %q must be %q or %q", s.FolderInTitles, folderInTitlesPrefix, folderInTitlesSuffix)
+	}
+
+	// the series titles are formatted with the padded index and the title, only literal percent signs can go along
+	if verbs := strings.ReplaceAll(s.SeriesTitleFormat, "%%", ""); s.SeriesTitleFormat != "" && (strings.Count(verbs, "%") != 2 || strings.Count(verbs, "%s") != 2) {
+		return fmt.Errorf("series title format %q must hold two %%s, the index and the title", s.SeriesTitleFormat)
+	}
+
+	for _, section := range s.RootSections {
+		if !slices.Contains(rootSections, section) {
+			return fmt.Errorf("root section %q must be one of %q", section, rootSections)
+		}
+		if section == sectionRandom && s.RandomBooks <= 0 {
+			return errors.New("the random root section needs random books")
+		}
+	}
+
+	if s.CustomRootOnly && len(s.NavEntries) == 0 {
+		return errors.New("custom root only needs nav entries, the root feed would be empty")
+	}
+
+	fi, err := os.Stat(s.TrustedRoot)
+	if err != nil {
+		return fmt.Errorf("trusted root %s: %w", s.TrustedRoot, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("trusted root %s is not a directory", s.TrustedRoot)
+	}
+
+	if s.CacheDir != "" {
+		if err := s.validateCacheDir(); err != nil {
+			return err
+		}
+	}
+
+	if s.PlaceholderCovers != "" {
+		if err := s.validatePlaceholderCovers(); err != nil {
+			return err
+		}
+	}
+
+	if s.AllowUnsafeRoot {
+		return nil
+	}
+
+	root, err := canonicalPath(s.TrustedRoot)
+	if err != nil {
+		return err
+	}
+
+	if root == filepath.VolumeName(root)+string(filepath.Separator) {
+		return fmt.Errorf("trusted root %s is the filesystem root, use a directory with books", s.TrustedRoot)
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		if home, err = canonicalPath(home); err == nil && home == root {
+			return fmt.Errorf("trusted root %s is the home directory, use a directory with books", s.TrustedRoot)
+		}
+	}
+
+	return nil
+}
+
+// NewOPDS returns the options of opts to serve, once they are valid, with their TrustedRoot made
+// absolute and canonical so the paths relative to it and the checks of the paths inside it hold
+// for roots like "testdata/". The error is the one of Validate.
+func NewOPDS(opts OPDS) (OPDS, error) {
+	if err := opts.Validate(); err != nil {
+		return opts, err
+	}
+
+	root, err := canonicalPath(opts.TrustedRoot)
+	if err != nil {
+		return opts, fmt.Errorf("trusted root %s: %w", opts.TrustedRoot, err)
+	}
+	opts.TrustedRoot = root
+	return opts, nil
+}
+
+func canonicalPath(aPath string) (string, error) {
+	aPath, err := filepath.Abs(aPath)
+	if err != nil {
+		return "", fmt.Errorf("get absolute path %s: %w", aPath, err)
+	}
+	return filepath.EvalSymlinks(aPath)
+}
+
+// NavEntry is a top-level navigation entry of the root feed,
+// e.g. "By Author" pointing to a custom or built-in route.
+type NavEntry struct {
+	Title string
+	Href  string
+	Rel   string
+	// Type of the linked feed, navigation feed when empty.
+	Type string
 }
 
 type IsDirer interface {
@@ -70,8 +397,29 @@ const acquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisit
 const searchType = "application/opensearchdescription+xml"
 const searchDefinitionPath = "/" + searchDefinitionName
 const searchDefinitionName = "opensearch.xml"
+
+// searchSyntax describes the search fields in the search definition
+const searchSyntax = " Prefix a term with author: or title: to match that field of the books, e.g. author:tolkien title:\"the hobbit\". "
 const searchPath = "/search"
 
+const defaultTitle = "dir2opds"
+
+// shelfRel is the rel of the root entry linking to /shelf, the same of the links to folders
+const shelfRel = "subsection"
+const defaultShelfTitle = "All books"
+
+// the values of Sort
+const (
+	sortTitle = "title"
+	sortDate  = "date"
+	sortName  = "name"
+)
+const aboutPath = "/about"
+const robotsPath = "/robots.txt"
+
+// defaultRobotsTxt keeps the crawlers out of the catalog
+const defaultRobotsTxt = "User-agent: *\nDisallow: /\n"
+
 var TimeNow = timeNowFunc()
 
 // Handler serve the content of a book file or
@@ -85,38 +433,157 @@ func (s OPDS) Handler(w http.ResponseWriter, req *http.Request) error {
 		return err
 	}
 
+	// a backslash is a separator where the os uses it, as in \..\..\ it could climb out of
+	// the TrustedRoot, elsewhere it is a valid character of a file name
+	if filepath.Separator == '\\' && strings.ContainsRune(urlPath, '\\') {
+		log.Printf("urlPath %q err: backslash separator", urlPath)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	// the routes are relative to the BasePath, /opds and /opds/ are the root feed
+	if base := s.basePath(); base != "" {
+		if urlPath != base && !strings.HasPrefix(urlPath, base+"/") {
+			w.WriteHeader(http.StatusNotFound)
+			return nil
+		}
+		req = req.Clone(req.Context())
+		req.URL.Path = strings.TrimPrefix(req.URL.Path, base)
+		req.URL.RawPath = strings.TrimPrefix(req.URL.RawPath, base)
+		urlPath = strings.TrimPrefix(urlPath, base)
+		if urlPath == "" {
+			urlPath, req.URL.Path, req.URL.RawPath = "/", "/", ""
+		}
+	}
+
+	// /shelf/mybook/ and /shelf/mybook are the same folder, drop the trailing slash
+	// so both produce the same feed and hrefs
+	if len(urlPath) > 1 && strings.HasSuffix(urlPath, "/") {
+		req = req.Clone(req.Context())
+		req.URL.Path = strings.TrimRight(req.URL.Path, "/")
+		req.URL.RawPath = strings.TrimRight(req.URL.RawPath, "/")
+		urlPath = strings.TrimRight(urlPath, "/")
+		if urlPath == "" {
+			urlPath, req.URL.Path = "/", "/"
+		}
+	}
+
+	req = s.withAllowedQuery(req)
+
+	// without search its definition, the suggestions and the results are not served
+	if s.DisableSearch && (urlPath == searchDefinitionPath || urlPath == suggestPath || urlPath == searchPath) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
 	if urlPath == searchDefinitionPath {
 		var content []byte
 
 		searchDefinition := &search.OpenSearchDefinition{
+			ShortName:      s.searchShortName(),
+			Description:    s.searchDescription(),
+			Comment:        searchSyntax,
 			InputEncoding:  "UTF-8",
 			OutputEncoding: "UTF-8",
-			OpenSearchUrl:  search.OpenSearchUrl{Type: "application/atom+xml;profile=opds-catalog;kind=acquisition", Template: "/search?q={searchTerms}"},
+			OpenSearchUrls: []search.OpenSearchUrl{
+				{Type: "application/atom+xml;profile=opds-catalog;kind=acquisition", Template: s.href("/search?q={searchTerms}")},
+				{Type: suggestionsType, Template: s.href(suggestPath + "?q={searchTerms}")},
+			},
 		}
 
 		content, err = xml.MarshalIndent(searchDefinition, "  ", "    ")
+		if err != nil {
+			return err
+		}
 		content = append([]byte(xml.Header), content...)
 
 		w.Header().Add("Content-Type", "application/xml")
 
 		http.ServeContent(w, req, searchDefinitionName, TimeNow(), bytes.NewReader(content))
 		return nil
-	} else if urlPath == "/" {
-		var content []byte
-		navigation := s.makeFeedRoot(req)
-		content, err = xml.MarshalIndent(navigation, "  ", "    ")
-		content = append([]byte(xml.Header), content...)
-		w.Header().Add("Content-Type", navigationType)
-		http.ServeContent(w, req, "feed.xml", TimeNow(), bytes.NewReader(content))
+	} else if urlPath == aboutPath {
+		content, err := json.MarshalIndent(s.about(), "", "  ")
+		if err != nil {
+			return err
+		}
+		w.Header().Add("Content-Type", "application/json")
+		http.ServeContent(w, req, "about.json", TimeNow(), bytes.NewReader(content))
 		return nil
-	} else if urlPath == "/new" {
-		var content []byte
-		navigation := s.makeFeedNewest(req)
-		content, err = xml.MarshalIndent(navigation, "  ", "    ")
-		content = append([]byte(xml.Header), content...)
-		w.Header().Add("Content-Type", navigationType)
-		http.ServeContent(w, req, "feed.xml", TimeNow(), bytes.NewReader(content))
+	} else if urlPath == robotsPath {
+		robots := s.RobotsTxt
+		if robots == "" {
+			robots = defaultRobotsTxt
+		}
+		w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+		http.ServeContent(w, req, "robots.txt", TimeNow(), strings.NewReader(robots))
 		return nil
+	} else if urlPath == sitemapPath {
+		return s.serveSitemap(w, req)
+	} else if urlPath == suggestPath {
+		return s.serveSuggestions(w, req)
+	} else if strings.HasPrefix(urlPath, mosaicPathPrefix) {
+		return s.serveMosaic(w, req, urlPath)
+	} else if strings.HasPrefix(urlPath, hashedCoverPathPrefix) {
+		return s.serveHashedCover(w, req, urlPath)
+	} else if strings.HasPrefix(urlPath, placeholderPathPrefix) {
+		return s.servePlaceholder(w, req, urlPath)
+	} else if strings.HasPrefix(urlPath, embeddedCoverPathPrefix) {
+		return s.serveEmbeddedCover(w, req, urlPath)
+	} else if strings.HasPrefix(urlPath, thumbnailPathPrefix) {
+		return s.serveThumbnail(w, req, urlPath)
+	} else if urlPath+"/" == authorsPathPrefix || urlPath+"/" == seriesPathPrefix {
+		return s.serveRelated(w, req, urlPath+"/")
+	} else if strings.HasPrefix(urlPath, authorsPathPrefix) || strings.HasPrefix(urlPath, seriesPathPrefix) {
+		return s.serveRelated(w, req, urlPath)
+	} else if strings.HasPrefix(urlPath, manifestPathPrefix) {
+		return s.serveManifest(w, req, urlPath)
+	} else if strings.HasPrefix(urlPath, withCoverPathPrefix) {
+		return s.serveWithCover(w, req, urlPath)
+	} else if strings.HasPrefix(urlPath, bookPathPrefix) {
+		return s.serveBook(w, req, urlPath)
+	} else if urlPath == calendarPath || strings.HasPrefix(urlPath, calendarPath+"/") {
+		return s.serveCalendar(w, req, urlPath)
+	} else if urlPath == randomPath {
+		return s.serveRandom(w, req)
+	} else if urlPath == "/" {
+		// without HTML the browsers get a landing page instead of the feed, see serveLanding
+		if !s.HTML {
+			w.Header().Add("Vary", "Accept")
+			if wantsHTML(req) {
+				return s.serveLanding(w, req)
+			}
+		}
+		return s.serveBuiltFeed(w, req, navigationType, func(req *http.Request) (any, error) {
+			return s.makeFeedRoot(req), nil
+		})
+	} else if urlPath == "/new" {
+		var days int
+		if d := req.URL.Query().Get("days"); d != "" {
+			days, err = strconv.Atoi(d)
+			if err != nil || days < 1 {
+				return fmt.Errorf("query param 'days' must be a positive number: %q", d)
+			}
+		}
+		page, err := pageParam(req)
+		if err != nil {
+			return err
+		}
+		format := strings.ToLower(strings.TrimPrefix(req.URL.Query().Get("format"), "."))
+		return s.serveBuiltFeed(w, req, acquisitionType, func(req *http.Request) (any, error) {
+			feed := s.makeFeedNewest(req, days, page, format)
+			return &opds.AcquisitionFeed{Feed: &feed, Dc: "http://purl.org/dc/terms/", Opds: "http://opds-spec.org/2010/catalog"}, nil
+		})
+	} else if urlPath == crawlablePath {
+		page, err := pageParam(req)
+		if err != nil {
+			return err
+		}
+		return s.serveBuiltFeed(w, req, acquisitionType, func(req *http.Request) (any, error) {
+			feed := s.makeFeedCrawlable(req, page)
+			return &opds.AcquisitionFeed{Feed: &feed, Dc: "http://purl.org/dc/terms/", Opds: "http://opds-spec.org/2010/catalog"}, nil
+		})
+	} else if urlPath != searchPath && urlPath != "/shelf" && !strings.HasPrefix(urlPath, "/shelf/") {
+		return s.serveNotFound(w, req)
 	}
 
 	var query = ""
@@ -153,16 +620,29 @@ func (s OPDS) Handler(w http.ResponseWriter, req *http.Request) error {
 
 	log.Printf("fPath:'%s'", fPath)
 
+	if _, pathRelativeToContentRoot, _ := strings.Cut(fPath, s.TrustedRoot+"/"); s.accessDenied(w, req, pathRelativeToContentRoot) {
+		return nil
+	}
+
 	// it's a file just serve the file
-	if getPathType(fPath) == pathTypeFile {
+	if s.getPathType(fPath) == pathTypeFile {
 		_, pathRelativeToContentRoot, _ := strings.Cut(fPath, s.TrustedRoot+"/")
-		if s.UseCalibreCovers && strings.HasSuffix(pathRelativeToContentRoot, "cover.jpg") {
-			http.ServeFile(w, req, fPath)
+		if s.UseCalibreCovers && isCalibreCover(filepath.Base(pathRelativeToContentRoot)) {
+			if s.InlinePreview {
+				w.Header().Add("Content-Disposition", s.contentDisposition(fPath))
+			}
+			return serveCover(w, req, cover{mimeType: getType(fPath, pathTypeFile), localPath: fPath})
 		}
-		if fileShouldBeIgnored(pathRelativeToContentRoot, s.HideCalibreFiles, s.HideDotFiles) {
+		if s.fileShouldBeIgnored(pathRelativeToContentRoot) || !s.included(pathRelativeToContentRoot) || s.tooSmall(fPath) {
 			w.WriteHeader(http.StatusNotFound)
 		} else {
-			w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filepath.Base(pathRelativeToContentRoot)))
+			w.Header().Add("Content-Disposition", s.contentDisposition(fPath))
+			if s.isComicZip(fPath) {
+				w.Header().Set("Content-Type", comicType)
+			}
+			if fileType := s.extensionlessType(fPath); fileType != "" {
+				w.Header().Set("Content-Type", fileType)
+			}
 			http.ServeFile(w, req, fPath)
 		}
 		return nil
@@ -173,95 +653,601 @@ func (s OPDS) Handler(w http.ResponseWriter, req *http.Request) error {
 		w.Header().Add("Expires", "0")
 	}
 
-	var content []byte
+	if urlPath == searchPath && s.StreamSearch && !(s.HTML && wantsHTML(req)) {
+		return s.serveSearchStream(w, req, query)
+	} else if urlPath == searchPath {
+		return s.serveBuiltFeed(w, req, acquisitionType, func(req *http.Request) (any, error) {
+			searchResult, size := s.makeFeedSearchResult(req, query)
+			return &search.SearchResultFeed{Feed: &searchResult, Size: size, OS: "http://purl.org/dc/terms/", Opds: "http://opds-spec.org/2010/catalog", Dc: "http://purl.org/dc/terms/"}, nil
+		})
+	} else if s.getPathType(fPath) == pathTypeDirOfFiles {
+		return s.serveFolderFeed(w, req, fPath, acquisitionType, func(req *http.Request) (any, error) {
+			navFeed, err := s.makeFeedPath(fPath, req)
+			if err != nil {
+				return nil, err
+			}
+			return &opds.AcquisitionFeed{Feed: &navFeed, Dc: "http://purl.org/dc/terms/", Opds: "http://opds-spec.org/2010/catalog"}, nil
+		})
+	}
 
-	if urlPath == searchPath {
-		searchResult, size := s.makeFeedSearchResult(req, query)
-		acFeed := &search.SearchResultFeed{Feed: &searchResult, Size: size, OS: "http://purl.org/dc/terms/", Opds: "http://opds-spec.org/2010/catalog", Dc: "http://purl.org/dc/terms/"}
-		content, err = xml.MarshalIndent(acFeed, "  ", "    ")
-		w.Header().Add("Content-Type", "application/atom+xml;profile=opds-catalog;kind=acquisition")
-	} else if getPathType(fPath) == pathTypeDirOfFiles {
-		navFeed := s.makeFeedPath(fPath, req)
-		acFeed := &opds.AcquisitionFeed{Feed: &navFeed, Dc: "http://purl.org/dc/terms/", Opds: "http://opds-spec.org/2010/catalog"}
-		content, err = xml.MarshalIndent(acFeed, "  ", "    ")
-		w.Header().Add("Content-Type", "application/atom+xml;profile=opds-catalog;kind=acquisition")
-	} else { // it is a navigation feed
-		navFeed := s.makeFeedPath(fPath, req)
-		content, err = xml.MarshalIndent(navFeed, "  ", "    ")
-		w.Header().Add("Content-Type", "application/atom+xml;profile=opds-catalog;kind=navigation")
+	// it is a navigation feed
+	return s.serveFolderFeed(w, req, fPath, navigationType, func(req *http.Request) (any, error) {
+		return s.makeFeedPath(fPath, req)
+	})
+}
+
+// prefixParam is the query param of the start of the names of the entries listed in a folder feed
+const prefixParam = "prefix"
+
+// pageParam returns the page query param, 1 when it is missing
+func pageParam(req *http.Request) (int, error) {
+	p := req.URL.Query().Get("page")
+	if p == "" {
+		return 1, nil
+	}
+	page, err := strconv.Atoi(p)
+	if err != nil || page < 1 {
+		return 0, fmt.Errorf("query param 'page' must be a positive number: %q", p)
+	}
+	return page, nil
+}
+
+// errBuildTimeout is returned when a feed takes longer than the BuildTimeout to be built
+var errBuildTimeout = errors.New("feed build timed out")
+
+// buildFeed runs build under the BuildTimeout, the request given to build carries
+// the deadline so the builders stop reading directories once it expires.
+func (s OPDS) buildFeed(req *http.Request, build func(req *http.Request) (any, error)) (any, error) {
+	if s.BuildTimeout <= 0 {
+		return build(req)
 	}
 
+	ctx, cancel := context.WithTimeout(req.Context(), s.BuildTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	type built struct {
+		feed any
+		err  error
+	}
+	done := make(chan built, 1)
+	go func() {
+		feed, err := build(req)
+		done <- built{feed: feed, err: err}
+	}()
+
+	select {
+	case b := <-done:
+		// a builder may return early with a partial feed
+		if ctx.Err() != nil {
+			return nil, errBuildTimeout
+		}
+		return b.feed, b.err
+	case <-ctx.Done():
+		return nil, errBuildTimeout
+	}
+}
+
+// serveBuiltFeed builds a feed and serves it, it responds 503 when the build times out
+// and returns the error of the build otherwise
+func (s OPDS) serveBuiltFeed(w http.ResponseWriter, req *http.Request, contentType string, build func(req *http.Request) (any, error)) error {
+	feed, err := s.buildFeed(req, build)
+	if errors.Is(err, errBuildTimeout) {
+		log.Printf("building %q: %s", req.URL.Path, err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return nil
+	}
 	if err != nil {
-		log.Printf("error while serving '%s': %s", fPath, err)
-		return err
+		return fmt.Errorf("building %q: %w", req.URL.Path, err)
 	}
+	return s.serveFeed(w, req, feed, contentType)
+}
 
-	content = append([]byte(xml.Header), content...)
-	http.ServeContent(w, req, "feed.xml", TimeNow(), bytes.NewReader(content))
+// feedOf returns the opds.Feed of the feeds served by serveFeed
+func feedOf(feed any) *opds.Feed {
+	switch f := feed.(type) {
+	case opds.Feed:
+		return &f
+	case *opds.Feed:
+		return f
+	case *opds.AcquisitionFeed:
+		return f.Feed
+	case *search.SearchResultFeed:
+		return f.Feed
+	}
+	return nil
+}
 
+// serveFeed marshals a feed to xml and serves it with the given content type
+func (s OPDS) serveFeed(w http.ResponseWriter, req *http.Request, feed any, contentType string) error {
+	if f, ok := feed.(opds.Feed); ok {
+		feed = &f
+	}
+	if f := feedOf(feed); f != nil && f.Author == nil && s.Provider.Name != "" {
+		provider := s.Provider
+		f.Author = &provider
+	}
+	if f := feedOf(feed); f != nil && s.MaxTitleLength > 0 {
+		for _, entry := range f.Entry {
+			truncateTitles(entry, s.MaxTitleLength)
+		}
+	}
+
+	if s.HTML {
+		w.Header().Add("Vary", "Accept")
+	}
+	// the built-in titles are in the language of the request
+	w.Header().Add("Vary", "Accept-Language")
+	if s.HTML && wantsHTML(req) {
+		return s.serveHTML(w, req, feed)
+	}
+
+	if len(s.UserAgentQuirks) > 0 {
+		w.Header().Add("Vary", "User-Agent")
+	}
+	quirks := s.quirks(req)
+	if f := feedOf(feed); f != nil {
+		applyQuirks(f, quirks)
+	}
+
+	buf := cappedBuffer{max: s.MaxFeedBytes}
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if !s.CompactOutput && !quirks.CompactOutput {
+		enc.Indent("  ", "    ")
+	}
+	if err := enc.Encode(feed); errors.Is(err, errFeedTooLarge) {
+		s.serveFeedTooLarge(w, req)
+		return nil
+	} else if err != nil {
+		log.Printf("error while serving '%s': %s", req.URL.Path, err)
+		return err
+	}
+	w.Header().Add("Content-Type", contentType)
+	http.ServeContent(w, req, "feed.xml", TimeNow(), bytes.NewReader(buf.Bytes()))
 	return nil
 }
-func (s OPDS) makeFeedRoot(req *http.Request) atom.Feed {
-	newestContent := atom.Text{Type: "text", Body: "The 15 latest modified books, most-recently-modified first."}
-	allContent := atom.Text{Type: "text", Body: "All books."}
 
+// truncateTitles shortens the titles of entry to length characters, see truncate
+func truncateTitles(entry *opds.Entry, length int) {
+	entry.Title = truncate(entry.Title, length)
+	for i := range entry.Titles {
+		entry.Titles[i].Value = truncate(entry.Titles[i].Value, length)
+	}
+}
+
+// truncate shortens text to length characters ending with an ellipsis, multibyte characters are not split
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length-1]) + "…"
+}
+
+// errFeedTooLarge is returned when a feed outgrows MaxFeedBytes
+var errFeedTooLarge = errors.New("feed too large")
+
+// cappedBuffer is a buffer refusing to grow over max bytes, zero means no limit
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.max > 0 && b.Len()+len(p) > b.max {
+		return 0, errFeedTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+func (b *cappedBuffer) WriteString(str string) (int, error) {
+	return b.Write([]byte(str))
+}
+
+// serveNotFound answers 404 to the routes that don't exist, with the NotFound handler when it is set
+// or with a feed linking to the start of the catalog so readers can find their way back
+func (s OPDS) serveNotFound(w http.ResponseWriter, req *http.Request) error {
+	if s.NotFound != nil {
+		s.NotFound.ServeHTTP(w, req)
+		return nil
+	}
+
+	content := atom.Text{Type: "text", Body: translate(req, "The page %s does not exist.", req.URL.Path)}
+	start := s.startLink()
+	feed := opds.FeedBuilder.
+		ID(req.URL.Path).
+		Title(translate(req, "Not found")).
+		Updated(TimeNow()).
+		AddLink(start).
+		AddEntry(opds.EntryBuilder{}.
+			ID(start.Href).
+			Title(translate(req, "Home")).
+			AddLink(opds.LinkBuilder.Rel("start").Href(start.Href).Type(navigationType).Build()).
+			Content(&content).
+			Build()).
+		Build()
+
+	body, err := xml.MarshalIndent(feed, "  ", "    ")
+	if err != nil {
+		return err
+	}
+	w.Header().Add("Content-Type", navigationType)
+	w.WriteHeader(http.StatusNotFound)
+	_, err = w.Write(append([]byte(xml.Header), body...))
+	return err
+}
+
+// serveFeedTooLarge answers 413 to a feed over MaxFeedBytes suggesting smaller feeds
+func (s OPDS) serveFeedTooLarge(w http.ResponseWriter, req *http.Request) {
+	log.Printf("feed %q is larger than %d bytes", req.URL.Path, s.MaxFeedBytes)
+	http.Error(w, fmt.Sprintf("the feed is larger than %d bytes, browse its subfolders, use the search or the paginated %s feed", s.MaxFeedBytes, s.href(crawlablePath)), http.StatusRequestEntityTooLarge)
+}
+
+func (s OPDS) makeFeedRoot(req *http.Request) opds.Feed {
 	feedBuilder := opds.FeedBuilder.
 		ID(req.URL.Path).
-		Title("Home").
+		Title(translate(req, "Home")).
 		Updated(TimeNow()).
-		AddLink(opds.LinkBuilder.Rel("start").Href("/").Type(navigationType).Build()).
-		AddLink(opds.LinkBuilder.Rel("search").Href(searchDefinitionPath).Type(searchType).Build())
+		AddLink(s.startLink())
 
-	var builder = opds.EntryBuilder{}
+	if summary, ok := s.librarySummary(req); ok {
+		feedBuilder = feedBuilder.Subtitle(summary)
+	}
 
-	builder = opds.EntryBuilder{}.Title("Newest books").ID("/new").AddLink(opds.LinkBuilder.Href("/new").Rel("http://opds-spec.org/sort/new").Type(acquisitionType).Build()).Content(&newestContent)
+	if !s.DisableSearch {
+		feedBuilder = feedBuilder.AddLink(s.searchLink())
+	}
+	feedBuilder = feedBuilder.AddLink(opds.LinkBuilder.Rel(crawlableRel).Href(s.href(crawlablePath)).Type(acquisitionType).Build())
 
-	feedBuilder = feedBuilder.AddEntry(builder.Build())
+	for _, section := range s.rootSections() {
+		feedBuilder = feedBuilder.AddEntry(s.rootSectionEntry(req, section))
+	}
 
-	builder = opds.EntryBuilder{}.Title("All books").ID("/shelf").AddLink(opds.LinkBuilder.Href("/shelf").Rel("http://opds-spec.org/subsection").Type(acquisitionType).Build()).Content(&allContent)
+	for _, nav := range s.NavEntries {
+		linkType := nav.Type
+		if linkType == "" {
+			linkType = navigationType
+		}
+
+		builder := opds.EntryBuilder{}.Title(nav.Title).ID(nav.Href).AddLink(opds.LinkBuilder.Href(s.href(nav.Href)).Rel(nav.Rel).Type(linkType).Build())
 
-	feedBuilder = feedBuilder.AddEntry(builder.Build())
+		feedBuilder = feedBuilder.AddEntry(builder.Build())
+	}
 
 	return feedBuilder.Build()
 }
 
-func (s OPDS) makeFeedPath(fpath string, req *http.Request) atom.Feed {
+// about describes a running instance, it must never include credentials.
+type about struct {
+	Version     string          `json:"version"`
+	GoVersion   string          `json:"goVersion"`
+	TrustedRoot string          `json:"trustedRoot"`
+	Options     map[string]bool `json:"options"`
+}
+
+func (s OPDS) about() about {
+	return about{
+		Version:     s.Version,
+		GoVersion:   runtime.Version(),
+		TrustedRoot: s.TrustedRoot,
+		Options: map[string]bool{
+			"hideCalibreFiles":    s.HideCalibreFiles,
+			"useCalibreCovers":    s.UseCalibreCovers,
+			"hideDotFiles":        s.HideDotFiles,
+			"noCache":             s.NoCache,
+			"bookLength":          s.BookLength,
+			"comicZips":           s.ComicZips,
+			"thumbnails":          s.Thumbnails,
+			"hashedCovers":        s.HashedCovers,
+			"mosaics":             s.Mosaics,
+			"bookFolders":         s.BookFolders,
+			"bookFormatFacets":    s.BookFormatFacets,
+			"html":                s.HTML,
+			"openAccess":          s.OpenAccess,
+			"authorFromFolder":    s.AuthorFromFolder,
+			"epubMetadata":        s.EpubMetadata,
+			"embedCovers":         s.EmbedCovers,
+			"ebookExtensionsOnly": s.EbookExtensionsOnly,
+			"folderUpdated":       s.FolderUpdated,
+			"magazineMode":        s.MagazineMode,
+			"compactOutput":       s.CompactOutput,
+			"webpubManifests":     s.WebpubManifests,
+			"disableSearch":       s.DisableSearch,
+			"customRootOnly":      s.CustomRootOnly,
+			"inlinePreview":       s.InlinePreview,
+			"streamSearch":        s.StreamSearch,
+			"sidecarMetadata":     s.SidecarMetadata,
+			"folderNotes":         s.FolderNotes,
+			"archiveFormats":      s.ArchiveFormats,
+			"seriesIndexTitles":   s.SeriesIndexTitles,
+			"includeChecksums":    s.IncludeChecksums,
+			"firstSeen":           s.FirstSeen,
+			"libraryStats":        s.LibraryStats != nil,
+		},
+	}
+}
+
+// basePath returns the BasePath without trailing slash, "" when the catalog is mounted at the root
+func (s OPDS) basePath() string {
+	base := strings.TrimRight(s.BasePath, "/")
+	if base != "" && !strings.HasPrefix(base, "/") {
+		base = "/" + base
+	}
+	return base
+}
+
+// href prefixes a generated absolute path with the BasePath and applies the HrefRewriter
+func (s OPDS) href(h string) string {
+	if strings.HasPrefix(h, "/") {
+		h = s.basePath() + h
+	}
+	if s.HrefRewriter == nil {
+		return h
+	}
+	return s.HrefRewriter(h)
+}
+
+// escapePath escapes each segment of a slash separated path, e.g.
+// "my folder/my book.epub" is "my%20folder/my%20book.epub"
+func escapePath(p string) string {
+	segments := strings.Split(filepath.ToSlash(p), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// shelfTitle is the ShelfTitle or "All books" in the language of req when it is empty
+func (s OPDS) shelfTitle(req *http.Request) string {
+	if s.ShelfTitle == "" {
+		return translate(req, defaultShelfTitle)
+	}
+	return s.ShelfTitle
+}
+
+// searchShortName is the Title of the catalog truncated to the 16 characters allowed by OpenSearch
+func (s OPDS) searchShortName() string {
+	title := []rune(s.title())
+	if len(title) > 16 {
+		title = title[:16]
+	}
+	return string(title)
+}
+
+func (s OPDS) searchDescription() string {
+	if s.Description != "" {
+		return s.Description
+	}
+	return "Search the books of " + s.title()
+}
+
+func (s OPDS) title() string {
+	if s.Title != "" {
+		return s.Title
+	}
+	return defaultTitle
+}
+
+func (s OPDS) searchLink() atom.Link {
+	return opds.LinkBuilder.Rel("search").Href(s.href(searchDefinitionPath)).Type(searchType).Build()
+}
+
+func (s OPDS) startLink() atom.Link {
+	href := s.StartHref
+	if href == "" {
+		href = "/"
+	}
+	return opds.LinkBuilder.Rel("start").Href(s.href(href)).Type(navigationType).Build()
+}
+
+// makeFeedPath returns the feed of the folder in fpath, an error when the folder can't be read
+// instead of an empty feed that looks like a folder without books
+func (s OPDS) makeFeedPath(fpath string, req *http.Request) (opds.Feed, error) {
 	feedBuilder := opds.FeedBuilder.
 		ID(req.URL.Path).
-		Title("Catalog in " + req.URL.Path).
+		Title(translate(req, "Catalog in %s", req.URL.Path)).
 		Updated(TimeNow()).
-		AddLink(opds.LinkBuilder.Rel("start").Href("/").Type(navigationType).Build()).
-		AddLink(opds.LinkBuilder.Rel("search").Href(searchDefinitionPath).Type(searchType).Build())
+		AddLink(s.startLink())
 
-	dirEntries, _ := os.ReadDir(fpath)
-	for _, entry := range dirEntries {
-		if fileShouldBeIgnored(entry.Name(), s.HideCalibreFiles, s.HideDotFiles) {
-			continue
+	if !s.DisableSearch {
+		feedBuilder = feedBuilder.AddLink(s.searchLink())
+	}
+
+	if s.FolderNotes {
+		if notes, ok := folderNotes(fpath); ok {
+			feedBuilder = feedBuilder.Subtitle(notes)
 		}
+	}
+
+	dirEntries, err := os.ReadDir(fpath)
+	if err != nil {
+		log.Printf("makeFeedPath: readDir err: %s", err)
+		return opds.Feed{}, err
+	}
 
-		pathType := getPathType(filepath.Join(fpath, entry.Name()))
+	// ?prefix=A lists only the entries whose name starts with A in any case, a part of a large folder
+	if prefix := strings.ToLower(req.URL.Query().Get(prefixParam)); prefix != "" {
+		dirEntries = slices.DeleteFunc(dirEntries, func(entry os.DirEntry) bool {
+			return !strings.HasPrefix(strings.ToLower(entry.Name()), prefix)
+		})
+	}
+
+	// a directory may hold books and folders, list the folders first so they are not hidden between books
+	sort.SliceStable(dirEntries, func(i, j int) bool {
+		return dirEntries[i].IsDir() && !dirEntries[j].IsDir()
+	})
+	books := slices.IndexFunc(dirEntries, func(entry os.DirEntry) bool { return !entry.IsDir() })
+	if books < 0 {
+		books = len(dirEntries)
+	}
 
-		var builder = opds.EntryBuilder{}
+	// the most recently modified books first, before their entries are built
+	if s.Sort == sortDate {
+		modTimes := make(map[string]time.Time, len(dirEntries)-books)
+		for _, entry := range dirEntries[books:] {
+			if info, err := entry.Info(); err == nil {
+				modTimes[entry.Name()] = info.ModTime()
+			}
+		}
+		sort.SliceStable(dirEntries[books:], func(i, j int) bool {
+			return modTimes[dirEntries[books+i].Name()].After(modTimes[dirEntries[books+j].Name()])
+		})
+	}
 
-		rel := getRel(entry.Name(), pathType)
+	seriesWidth := s.seriesIndexWidth(fpath, dirEntries)
 
-		builder = builder.ID(filepath.Join(req.URL.Path, entry.Name())).
+	// the entries are built concurrently, reading the metadata of the books is slow,
+	// and added in the order of the listing
+	entries := make([]*opds.Entry, len(dirEntries))
+	s.forEachEntry(req, len(dirEntries), func(i int) {
+		if entry, ok := s.makeDirEntry(req, fpath, dirEntries[i], seriesWidth); ok {
+			if i >= books && s.GroupBy != "" {
+				entry = s.addGroup(fpath, dirEntries[i].Name(), entry)
+			}
+			entries[i] = &entry
+		}
+	})
+
+	// the books are sorted by their title case-insensitively, the folders are kept before them
+	if s.Sort == "" || s.Sort == sortTitle {
+		sort.SliceStable(entries[books:], func(i, j int) bool {
+			return strings.ToLower(entryTitle(entries[books+i])) < strings.ToLower(entryTitle(entries[books+j]))
+		})
+	}
+
+	if s.GroupBy != "" {
+		groupEntries(req, entries, books)
+	}
+
+	// the books are truncated once sorted, so the ones left out are the last ones
+	if s.MaxEntriesPerFeed > 0 {
+		listed := 0
+		for i, entry := range entries[books:] {
+			if entry == nil {
+				continue
+			}
+			listed++
+			if listed > s.MaxEntriesPerFeed {
+				entries[books+i] = nil
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		if entry != nil {
+			feedBuilder = feedBuilder.AddEntry(*entry)
+		}
+	}
+	return feedBuilder.Build(), nil
+}
+
+// entryTitle is the title of an entry, "" for an entry not listed
+func entryTitle(entry *opds.Entry) string {
+	if entry == nil {
+		return ""
+	}
+	return entry.Title
+}
+
+// makeDirEntry returns the entry of a file or folder of the folder in fpath,
+// false when it is not listed
+func (s OPDS) makeDirEntry(req *http.Request, fpath string, entry os.DirEntry, seriesWidth int) (opds.Entry, bool) {
+	if s.fileShouldBeIgnored(entry.Name()) || s.isBookCover(filepath.Join(fpath, entry.Name())) {
+		return opds.Entry{}, false
+	}
+
+	if !entry.IsDir() && (!s.included(entry.Name()) || s.tooSmall(filepath.Join(fpath, entry.Name()))) {
+		return opds.Entry{}, false
+	}
+
+	if _, pathRelativeToContentRoot, _ := strings.Cut(filepath.Join(fpath, entry.Name()), s.TrustedRoot+"/"); !s.authorized(req, pathRelativeToContentRoot) {
+		return opds.Entry{}, false
+	}
+
+	pathType := s.getPathType(filepath.Join(fpath, entry.Name()))
+
+	var builder = opds.EntryBuilder{}
+
+	rel := s.getRel(entry.Name(), pathType)
+
+	builder = builder.ID(filepath.Join(req.URL.Path, entry.Name())).
+		Title(entry.Name()).
+		AddLink(opds.LinkBuilder.
+			Rel(rel).
+			Title(entry.Name()).
+			Href(s.href(filepath.Join(req.URL.EscapedPath(), url.PathEscape(entry.Name())))).
+			Type(s.linkType(filepath.Join(fpath, entry.Name()), pathType)).
+			Build())
+
+	if s.BookFolders && pathType != pathTypeFile && s.bookFolderFiles(filepath.Join(fpath, entry.Name())) != nil {
+		// the folder is one book, link to it instead of listing its formats as separate books
+		_, pathRelativeToContentRoot, _ := strings.Cut(filepath.Join(fpath, entry.Name()), s.TrustedRoot+"/")
+
+		builder = opds.EntryBuilder{}.
+			ID(filepath.Join(req.URL.Path, entry.Name())).
 			Title(entry.Name()).
 			AddLink(opds.LinkBuilder.
-				Rel(rel).
+				Rel("subsection").
 				Title(entry.Name()).
-				Href(filepath.Join(req.URL.RequestURI(), url.PathEscape(entry.Name()))).
-				Type(getType(entry.Name(), pathType)).
+				Href(s.href(bookPathPrefix + escapePath(pathRelativeToContentRoot))).
+				Type(acquisitionType).
 				Build())
+	}
+
+	if name, date, ok := s.magazineIssue(filepath.Join(fpath, entry.Name())); ok && pathType != pathTypeFile {
+		return s.makeIssueEntry(req, filepath.Join(fpath, entry.Name()), name, date).Build(), true
+	}
 
-		if rel == "http://opds-spec.org/acquisition" {
-			builder = addCoverIfExists(filepath.Join(fpath, entry.Name()), builder, s)
+	if rel == s.acquisitionRel() {
+		builder = builder.Title(s.seriesTitle(filepath.Join(fpath, entry.Name()), entry.Name(), seriesWidth))
+		builder = s.addBookMetadata(filepath.Join(fpath, entry.Name()), builder, req)
+	}
+
+	if pathType != pathTypeFile {
+		if updated, ok := s.folderUpdated(req, filepath.Join(fpath, entry.Name())); ok {
+			builder = builder.Updated(updated.UTC())
 		}
+	}
 
-		feedBuilder = feedBuilder.
-			AddEntry(builder.Build())
+	if s.mosaicsEnabled() && pathType != pathTypeFile && len(s.mosaicCovers(req, filepath.Join(fpath, entry.Name()))) > 0 {
+		_, pathRelativeToContentRoot, _ := strings.Cut(filepath.Join(fpath, entry.Name()), s.TrustedRoot+"/")
+
+		builder = builder.AddLink(opds.LinkBuilder.
+			Rel("http://opds-spec.org/image/thumbnail").
+			Href(s.href(mosaicPathPrefix + escapePath(pathRelativeToContentRoot))).
+			Type("image/jpeg").
+			Build())
 	}
-	return feedBuilder.Build()
+
+	return builder.Build(), true
+}
+
+// forEachEntry calls build with every index below n from up to MetadataWorkers goroutines
+// and waits for them, no new call is started once req is done
+func (s OPDS) forEachEntry(req *http.Request, n int, build func(i int)) {
+	workers := s.MetadataWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				build(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n && req.Context().Err() == nil; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
 }
 
 type File struct {
@@ -269,33 +1255,87 @@ type File struct {
 	fileInfo os.FileInfo
 }
 
-func (s OPDS) makeFeedNewest(req *http.Request) atom.Feed {
+// newestPageSize is the number of books in each page of /new
+const newestPageSize = 14
+
+// makeFeedNewest lists the most recently modified books by pages of newestPageSize linked with rel="next",
+// when days is greater than zero it lists every book modified within that many days instead.
+// A format like cbz only lists the books of that format.
+func (s OPDS) makeFeedNewest(req *http.Request, days, page int, format string) opds.Feed {
 	feedBuilder := search.FeedBuilder.
 		ID(req.URL.Path).
-		Title("Newest books").
+		Title(translate(req, "Newest books")).
 		Updated(TimeNow()).
-		AddLink(opds.LinkBuilder.Rel("start").Href("/").Type(navigationType).Build()).
-		AddLink(opds.LinkBuilder.Rel("search").Href(searchDefinitionPath).Type(searchType).Build())
+		AddLink(s.startLink())
+
+	if !s.DisableSearch {
+		feedBuilder = feedBuilder.AddLink(s.searchLink())
+	}
+
+	files := s.walkBooks(req)
 
+	if format != "" {
+		files = slices.DeleteFunc(files, func(file File) bool {
+			return bookFormat(file.fileInfo.Name()) != format
+		})
+	}
+
+	if days > 0 {
+		since := time.Now().AddDate(0, 0, -days)
+		files = files[:sort.Search(len(files), func(i int) bool {
+			return files[i].fileInfo.ModTime().Before(since)
+		})]
+	} else {
+		start := min((page-1)*newestPageSize, len(files))
+		end := min(start+newestPageSize, len(files))
+
+		if page > 1 {
+			feedBuilder = feedBuilder.AddLink(opds.LinkBuilder.Rel("previous").Href(s.href(newestHref(page-1, format))).Type(acquisitionType).Build())
+		}
+		if end < len(files) {
+			feedBuilder = feedBuilder.AddLink(opds.LinkBuilder.Rel("next").Href(s.href(newestHref(page+1, format))).Type(acquisitionType).Build())
+		}
+		files = files[start:end]
+	}
+
+	for _, file := range files {
+		feedBuilder = feedBuilder.
+			AddEntry(s.makeFileEntry(file, req).Build())
+	}
+
+	return feedBuilder.Build()
+}
+
+// walkBooks returns every file under the TrustedRoot that is not ignored and the user
+// of req is authorized to, sorted by modified descending. The walk stops when req is done.
+func (s OPDS) walkBooks(req *http.Request) []File {
+	ctx := req.Context()
 	var files = []File{}
 
 	filepath.WalkDir(s.TrustedRoot, func(path string, file fs.DirEntry, err error) error {
 		if err != nil {
-			return err
+			return walkError(path, file, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-		_, pathRelativeToContentRoot, _ := strings.Cut(path, s.TrustedRoot+"/")
+		pathRelativeToContentRoot := s.relativePath(path)
 
-		if file.IsDir() && fileShouldBeIgnored(pathRelativeToContentRoot, s.HideCalibreFiles, s.HideDotFiles) {
+		if file.IsDir() && (s.fileShouldBeIgnored(pathRelativeToContentRoot) || !s.authorized(req, pathRelativeToContentRoot)) {
 			return filepath.SkipDir
 		}
 
-		if !file.IsDir() && !fileShouldBeIgnored(file.Name(), s.HideCalibreFiles, s.HideDotFiles) {
+		if !file.IsDir() && !s.fileShouldBeIgnored(file.Name()) && s.included(file.Name()) && !s.tooSmall(path) && !s.isBookCover(path) && s.authorized(req, pathRelativeToContentRoot) {
 			info, err := os.Stat(path)
 			if err != nil {
-				log.Printf("makeFeedNewest os.Stat err: %s", err)
+				log.Printf("walkBooks os.Stat err: %s", err)
 				return nil
 			}
 
+			if name, date, ok := s.magazineIssue(filepath.Dir(path)); ok && name == info.Name() {
+				info = issueFileInfo{FileInfo: info, date: date}
+			}
+
 			if !info.IsDir() {
 				files = append(files, File{filePath: path, fileInfo: info})
 			}
@@ -303,7 +1343,8 @@ func (s OPDS) makeFeedNewest(req *http.Request) atom.Feed {
 		return nil
 	})
 
-	// sorting files by modified descending
+	// sorting files by modified descending, ties by name and then by path
+	// so books with the same name in different folders keep their order
 	sort.Slice(files, func(i, j int) bool {
 		fileI := files[i].fileInfo
 		fileJ := files[j].fileInfo
@@ -318,119 +1359,441 @@ func (s OPDS) makeFeedNewest(req *http.Request) atom.Feed {
 		return files[i].filePath < files[j].filePath
 	})
 
-	for i := 0; i < 14 && i < len(files); i++ {
-		file := files[i]
-		_, pathRelativeToContentRoot, _ := strings.Cut(file.filePath, s.TrustedRoot+"/")
+	return files
+}
+
+// newestHref is the path of a page of the newest books of a format, of every format when it is empty
+func newestHref(page int, format string) string {
+	if format == "" {
+		return fmt.Sprintf("/new?page=%d", page)
+	}
+	return fmt.Sprintf("/new?format=%s&page=%d", url.QueryEscape(format), page)
+}
+
+// makeFileEntry returns an acquisition entry for a file found walking the TrustedRoot
+func (s OPDS) makeFileEntry(file File, req *http.Request) opds.EntryBuilder {
+	if name, date, ok := s.magazineIssue(filepath.Dir(file.filePath)); ok && name == file.fileInfo.Name() {
+		return s.makeIssueEntry(req, filepath.Dir(file.filePath), name, date)
+	}
+
+	_, pathRelativeToContentRoot, _ := strings.Cut(file.filePath, s.TrustedRoot+"/")
 
-		var builder = opds.EntryBuilder{}
+	var builder = opds.EntryBuilder{}
 
-		builder = builder.ID(filepath.Join("/shelf", pathRelativeToContentRoot)).
+	builder = builder.ID(filepath.Join("/shelf", pathRelativeToContentRoot)).
+		Title(s.folderTitle(file.filePath, file.fileInfo.Name())).
+		AddLink(opds.LinkBuilder.
+			Rel(s.acquisitionRel()).
 			Title(file.fileInfo.Name()).
-			AddLink(opds.LinkBuilder.
-				Rel("http://opds-spec.org/acquisition").
-				Title(file.fileInfo.Name()).
-				Href(filepath.Join("/shelf", url.PathEscape(pathRelativeToContentRoot))).
-				Type(getType(file.fileInfo.Name(), pathTypeFile)).
-				Build())
+			Href(s.href(filepath.Join("/shelf", escapePath(pathRelativeToContentRoot)))).
+			Type(s.linkType(file.filePath, pathTypeFile)).
+			Build())
+
+	return s.addBookMetadata(file.filePath, builder, req)
+}
 
-		builder = addCoverIfExists(file.filePath, builder, s)
+// addBookMetadata adds to the entry of a book its cover, length, the formats inside it, checksum,
+// authors, categories, links to the books related to it, to its web publication manifest, to the epub with its
+// cover added and to the external reader, translated titles and rights, the same in every feed listing the book
+func (s OPDS) addBookMetadata(filePath string, builder opds.EntryBuilder, req *http.Request) opds.EntryBuilder {
+	builder = addCoverIfExists(filePath, builder, s, req)
+	builder = s.addLength(filePath, builder)
+	builder = s.addArchiveFormats(filePath, builder)
+	builder = s.addChecksum(filePath, builder)
+	builder = s.addAuthors(filePath, builder)
+	builder = s.addCategories(filePath, builder)
+	builder = s.addRelated(req, filePath, builder)
+	builder = s.addWebpub(filePath, builder)
+	builder = s.addWithCover(req, filePath, builder)
+	builder = s.addExternalReader(req, filePath, builder)
+	builder = s.addFirstSeen(filePath, builder)
+	builder = s.addTranslatedTitles(filePath, builder)
+	builder = s.addEpubID(filePath, builder)
+	return s.addRights(filePath, builder)
+}
 
-		feedBuilder = feedBuilder.
-			AddEntry(builder.Build())
+// folderUpdated returns the newest modification time of the contents of the folder in dirPath,
+// of its direct contents or of every subfolder with FolderUpdatedDeep.
+// It returns false when FolderUpdated is disabled or the folder is empty.
+func (s OPDS) folderUpdated(req *http.Request, dirPath string) (time.Time, bool) {
+	if !s.FolderUpdated {
+		return time.Time{}, false
 	}
 
-	return feedBuilder.Build()
+	var newest time.Time
+	filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if err := req.Context().Err(); err != nil {
+			return err
+		}
+		if path == dirPath {
+			return nil
+		}
+
+		pathRelativeToContentRoot := s.relativePath(path)
+		if s.fileShouldBeIgnored(d.Name()) || !s.authorized(req, pathRelativeToContentRoot) || (!d.IsDir() && !s.included(d.Name())) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info, err := d.Info(); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		if d.IsDir() && !s.FolderUpdatedDeep {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return newest, !newest.IsZero()
 }
 
-func (s OPDS) makeFeedSearchResult(req *http.Request, query string) (atom.Feed, int) {
-	feedBuilder := search.FeedBuilder.
+func (s OPDS) makeFeedSearchResult(req *http.Request, query string) (opds.Feed, int) {
+	feed := s.searchFeed(req, query)
+	count := s.walkSearch(req, query, func(entry opds.Entry) error {
+		feed.Entry = append(feed.Entry, &entry)
+		return nil
+	})
+	return feed, count
+}
+
+// searchFeed returns the feed of the results of query without its entries
+func (s OPDS) searchFeed(req *http.Request, query string) opds.Feed {
+	return search.FeedBuilder.
 		ID(req.URL.Path).
-		Title(fmt.Sprintf("Folders containing files matching query %s", query)).
+		Title(translate(req, "Folders containing files matching query %s", query)).
 		Updated(TimeNow()).
-		AddLink(opds.LinkBuilder.Rel("start").Href("/").Type(navigationType).Build()).
-		AddLink(opds.LinkBuilder.Rel("search").Href(searchDefinitionPath).Type(searchType).Build())
+		AddLink(s.startLink()).
+		AddLink(s.searchLink()).
+		Build()
+}
 
+// walkSearch calls found with the entry of every file matching query in walk order and returns
+// how many were found: its name contains the terms without prefix and its metadata the prefixed
+// ones, see parseSearchQuery. The walk stops when req is done or found returns an error.
+func (s OPDS) walkSearch(req *http.Request, query string, found func(entry opds.Entry) error) int {
 	var count = 0
+	q := parseSearchQuery(query)
 	filepath.WalkDir(s.TrustedRoot, func(path string, file fs.DirEntry, err error) error {
 		if err != nil {
+			return walkError(path, file, err)
+		}
+		if err := req.Context().Err(); err != nil {
 			return err
 		}
 
-		_, pathRelativeToContentRoot, _ := strings.Cut(path, s.TrustedRoot+"/")
+		pathRelativeToContentRoot := s.relativePath(path)
 
-		if file.IsDir() && fileShouldBeIgnored(pathRelativeToContentRoot, s.HideCalibreFiles, s.HideDotFiles) {
+		if file.IsDir() && (s.fileShouldBeIgnored(pathRelativeToContentRoot) || !s.authorized(req, pathRelativeToContentRoot)) {
 			return filepath.SkipDir
 		}
 
 		if !file.IsDir() {
-			if fileShouldBeIgnored(pathRelativeToContentRoot, s.HideCalibreFiles, s.HideDotFiles) {
+			// the files are listed like in the feed of their folder, the covers of the books are not entries
+			if s.fileShouldBeIgnored(pathRelativeToContentRoot) || !s.included(file.Name()) || s.tooSmall(path) || s.isBookCover(path) || !s.authorized(req, pathRelativeToContentRoot) {
 				// skip
 			} else {
-				if strings.Contains(strings.ToLower(file.Name()), strings.ToLower(query)) {
+				if q.matchesName(file.Name()) {
 					var builder = opds.EntryBuilder{}
 
+					rel := s.getRel(file.Name(), pathTypeFile)
 					builder = builder.
 						ID(filepath.Join("/shelf", pathRelativeToContentRoot)).
 						Title(file.Name()).
 						AddLink(opds.LinkBuilder.
-							Rel(getRel(file.Name(), 0)).
-							Href(filepath.Join("/shelf", url.PathEscape(pathRelativeToContentRoot))).
-							Type(getType(file.Name(), 0)).
+							Rel(rel).
+							Href(s.href(filepath.Join("/shelf", escapePath(pathRelativeToContentRoot)))).
+							Type(s.linkType(path, pathTypeFile)).
 							Build())
 
-					builder = addCoverIfExists(path, builder, s)
-
-					feedBuilder = feedBuilder.AddEntry(builder.Build())
+					if rel == s.acquisitionRel() {
+						builder = builder.Title(s.seriesTitle(path, file.Name(), s.folderSeriesIndexWidth(filepath.Dir(path))))
+						builder = s.addBookMetadata(path, builder, req)
+					}
+
+					entry := builder.Build()
+					if q.hasFields() && !s.matchesEntry(q, path, entry) {
+						return nil
+					}
+					if s.FolderInTitles != "" {
+						// the folder qualifies the title once the book matched, title:terms don't match the folder
+						entry = builder.Title(s.folderTitle(path, entry.Title)).Build()
+					}
+
+					if err := found(entry); err != nil {
+						return err
+					}
 					count++
 				}
 			}
 		}
 		return nil
 	})
-	return feedBuilder.Build(), count
+	return count
+}
+
+// walkError logs the error of a walk reading path and skips it, an unreadable folder doesn't
+// stop the walk of the rest of the catalog. The error is returned when the walk can't start.
+func walkError(path string, d fs.DirEntry, err error) error {
+	if d == nil {
+		return err
+	}
+	log.Printf("walk %q err: %s", path, err)
+	if d.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// authorized reports if the user of req may access the path relative to the TrustedRoot,
+// the path and every folder it is in are checked so hiding a folder hides its content.
+// The user is the basic auth user of the request, authenticated before reaching the Handler.
+// A path blocked for req is never authorized.
+func (s OPDS) authorized(req *http.Request, pathRelativeToContentRoot string) bool {
+	if s.blocked(req, pathRelativeToContentRoot) {
+		return false
+	}
+	if s.Authorize == nil || pathRelativeToContentRoot == "" || pathRelativeToContentRoot == currentDirectory {
+		return true
+	}
+
+	user, _, _ := req.BasicAuth()
+	segments := strings.Split(filepath.ToSlash(pathRelativeToContentRoot), "/")
+	for i := range segments {
+		if !s.Authorize(user, strings.Join(segments[:i+1], "/")) {
+			return false
+		}
+	}
+	return true
 }
 
-func fileShouldBeIgnored(filename string, hideCalibreFiles, hideDotFiles bool) bool {
-	// not ignore those directories
-	if filename == currentDirectory || filename == parentDirectory {
+// blocked reports if the BlockFunc blocks the path relative to the TrustedRoot for req,
+// a blocked folder blocks its content
+func (s OPDS) blocked(req *http.Request, pathRelativeToContentRoot string) bool {
+	if s.BlockFunc == nil || pathRelativeToContentRoot == "" || pathRelativeToContentRoot == currentDirectory {
+		return false
+	}
+
+	segments := strings.Split(filepath.ToSlash(pathRelativeToContentRoot), "/")
+	for i := range segments {
+		if s.BlockFunc(strings.Join(segments[:i+1], "/"), req) {
+			return true
+		}
+	}
+	return false
+}
+
+// accessDenied answers 451 when the path relative to the TrustedRoot is blocked for req
+// or 403 when its user is not authorized to it and reports if it did
+func (s OPDS) accessDenied(w http.ResponseWriter, req *http.Request, pathRelativeToContentRoot string) bool {
+	switch {
+	case s.blocked(req, pathRelativeToContentRoot):
+		w.WriteHeader(http.StatusUnavailableForLegalReasons)
+	case !s.authorized(req, pathRelativeToContentRoot):
+		w.WriteHeader(http.StatusForbidden)
+	default:
+		return false
+	}
+	return true
+}
+
+func (s OPDS) fileShouldBeIgnored(filename string) bool {
+	// not ignore those directories nor the TrustedRoot, its path relative to the content root is empty
+	if filename == "" || filename == currentDirectory || filename == parentDirectory {
 		return includeFile
 	}
 
-	if hideDotFiles && strings.HasPrefix(filename, hiddenFilePrefix) {
+	if s.HideDotFiles && isDotFile(filename) {
 		return ignoreFile
 	}
 
-	if hideCalibreFiles &&
-		(strings.Contains(filename, ".opf") ||
-			strings.Contains(filename, "cover.") ||
-			strings.Contains(filename, "metadata.db") ||
-			strings.Contains(filename, "metadata_db_prefs_backup.json") ||
-			strings.Contains(filename, ".caltrash") ||
-			strings.Contains(filename, ".calnotes")) {
+	if isThumbnailsDir(filename) {
+		return ignoreFile
+	}
+
+	if s.HideCalibreFiles && isCalibreFile(filename) {
+		return ignoreFile
+	}
+
+	if s.SidecarMetadata && filepath.Base(filename) == sidecarName {
+		return ignoreFile
+	}
+
+	if s.FolderNotes && isNotesFile(filename) {
 		return ignoreFile
 	}
 
 	return false
 }
 
-func getRel(name string, pathType int) string {
+// contentDisposition returns the Content-Disposition of the file in filePath, inline for the
+// images and pdfs browsers show with InlinePreview and attachment for the rest so they are downloaded
+func (s OPDS) contentDisposition(filePath string) string {
+	disposition := "attachment"
+	if fileType := getType(filePath, pathTypeFile); s.InlinePreview && (strings.HasPrefix(fileType, "image/") || fileType == "application/pdf") {
+		disposition = "inline"
+	}
+	return fmt.Sprintf("%s; filename=\"%s\"", disposition, filepath.Base(filePath))
+}
+
+// relativePath returns the path of a file under the TrustedRoot relative to it, "" for the
+// TrustedRoot itself. Unlike cutting the TrustedRoot prefix it works for roots like
+// "books/" or "/" whose children don't start with the root and a slash.
+func (s OPDS) relativePath(path string) string {
+	rel, err := filepath.Rel(s.TrustedRoot, path)
+	if err != nil || rel == currentDirectory {
+		return ""
+	}
+	return rel
+}
+
+// isBookCover reports if the file in filePath is the cover of the books in its folder,
+// covers are served with their books instead of as entries of their own.
+func (s OPDS) isBookCover(filePath string) bool {
+	if !s.UseCalibreCovers || !isCalibreCover(filepath.Base(filePath)) {
+		return false
+	}
+
+	dirEntries, err := os.ReadDir(filepath.Dir(filePath))
+	if err != nil {
+		return false
+	}
+	for _, entry := range dirEntries {
+		if !entry.IsDir() && !isCover(entry.Name()) && !s.fileShouldBeIgnored(entry.Name()) && s.included(entry.Name()) && !s.tooSmall(filepath.Join(filepath.Dir(filePath), entry.Name())) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDotFile reports if the path is a dot file or is inside a dot folder
+func isDotFile(path string) bool {
+	for _, name := range strings.Split(filepath.ToSlash(path), "/") {
+		if strings.HasPrefix(name, hiddenFilePrefix) && name != currentDirectory && name != parentDirectory {
+			return true
+		}
+	}
+	return false
+}
+
+// isCalibreFile reports if the path is a file stored by calibre or is inside a calibre folder,
+// names are matched exactly so books like "The .opf Story.epub" are not hidden.
+func isCalibreFile(path string) bool {
+	for _, name := range strings.Split(filepath.ToSlash(path), "/") {
+		if name == ".caltrash" || name == ".calnotes" {
+			return true
+		}
+	}
+
+	name := filepath.Base(path)
+	switch name {
+	case "metadata.db", "metadata_db_prefs_backup.json":
+		return true
+	}
+
+	if strings.ToLower(filepath.Ext(name)) == ".opf" {
+		return true
+	}
+
+	return isCover(name)
+}
+
+// tooSmall reports if the file in filePath is smaller than MinFileSize, covers are never too small
+func (s OPDS) tooSmall(filePath string) bool {
+	if s.MinFileSize <= 0 || isCover(filepath.Base(filePath)) {
+		return false
+	}
+	fi, err := os.Stat(filePath)
+	return err == nil && !fi.IsDir() && fi.Size() < s.MinFileSize
+}
+
+// included reports if a file is listed and served according to IncludeOnly and Extensionless,
+// the covers are always included so the books keep them
+func (s OPDS) included(name string) bool {
+	name = filepath.Base(name)
+	if s.Extensionless == extensionlessSkip && !hasExtension(name) {
+		return false
+	}
+	return s.IncludeOnly == nil || s.IncludeOnly.MatchString(name) || isCover(name)
+}
+
+// isCalibreCover reports if name is a calibre cover, cover.jpg with its extension in any case
+func isCalibreCover(name string) bool {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) == "cover" && strings.ToLower(ext) == ".jpg"
+}
+
+// calibreCoverPath returns the path of the calibre cover in dirPath, see isCalibreCover
+func calibreCoverPath(dirPath string) (string, bool) {
+	for _, name := range []string{"cover.jpg", "cover.JPG"} {
+		if _, err := os.Stat(filepath.Join(dirPath, name)); err == nil {
+			return filepath.Join(dirPath, name), true
+		}
+	}
+	return "", false
+}
+
+// isCover reports if name is a cover stored next to the books, like cover.jpg
+func isCover(name string) bool {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) == "cover" && isImage(strings.ToLower(ext))
+}
+
+// ebookExtensions are the formats considered books by EbookExtensionsOnly
+var ebookExtensions = map[string]bool{
+	".epub": true,
+	".mobi": true,
+	".azw3": true,
+	".pdf":  true,
+	".cbz":  true,
+	".cbr":  true,
+	".fb2":  true,
+	".djvu": true,
+	".m4b":  true,
+	".m4a":  true,
+	".mp3":  true,
+}
+
+func isEbook(name string) bool {
+	return ebookExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+func isAudio(name string) bool {
+	return strings.HasPrefix(mime.TypeByExtension(strings.ToLower(filepath.Ext(name))), "audio/")
+}
+
+func isImage(ext string) bool {
+	return ext == ".png" || ext == ".jpg" || ext == ".jpeg" || ext == ".gif"
+}
+
+// acquisitionRel is the rel of the links to download the books
+func (s OPDS) acquisitionRel() string {
+	if s.OpenAccess {
+		return "http://opds-spec.org/acquisition/open-access"
+	}
+	return "http://opds-spec.org/acquisition"
+}
+
+func (s OPDS) getRel(name string, pathType int) string {
 	if pathType == pathTypeDirOfFiles || pathType == pathTypeDirOfDirs {
 		return "subsection"
 	}
 
-	ext := filepath.Ext(name)
-	if ext == ".png" || ext == ".jpg" || ext == ".jpeg" || ext == ".gif" {
+	if isImage(strings.ToLower(filepath.Ext(name))) {
 		return "http://opds-spec.org/image/thumbnail"
 	}
 
 	// mobi, epub, etc
-	return "http://opds-spec.org/acquisition"
+	return s.acquisitionRel()
 }
 
 func getType(name string, pathType int) string {
 	switch pathType {
 	case pathTypeFile:
-		return mime.TypeByExtension(filepath.Ext(name))
+		return mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
 	case pathTypeDirOfFiles:
 		return acquisitionType
 	case pathTypeDirOfDirs:
@@ -440,7 +1803,7 @@ func getType(name string, pathType int) string {
 	}
 }
 
-func getPathType(dirpath string) int {
+func (s OPDS) getPathType(dirpath string) int {
 	fi, err := os.Stat(dirpath)
 	if err != nil {
 		log.Printf("getPathType os.Stat err: %s", err)
@@ -457,7 +1820,11 @@ func getPathType(dirpath string) int {
 	}
 
 	for _, entry := range dirEntries {
-		if isFile(entry) {
+		if isFile(entry) && s.included(entry.Name()) && !s.tooSmall(filepath.Join(dirpath, entry.Name())) && (!s.EbookExtensionsOnly || isEbook(entry.Name()) || s.isComicZip(filepath.Join(dirpath, entry.Name()))) {
+			return pathTypeDirOfFiles
+		}
+		if _, _, ok := s.magazineIssue(filepath.Join(dirpath, entry.Name())); ok && entry.IsDir() {
+			// the issues of a magazine are listed as books
 			return pathTypeDirOfFiles
 		}
 	}
@@ -491,25 +1858,195 @@ func verifyPath(path, trustedRoot string) (string, error) {
 	return r, nil
 }
 
+// inTrustedRoot reports if path is trustedRoot or inside it,
+// /books-private is not inside /books
 func inTrustedRoot(path string, trustedRoot string) bool {
-	return strings.HasPrefix(path, trustedRoot)
+	root := filepath.Clean(trustedRoot)
+	return path == root || strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
 }
 
-func addCoverIfExists(akquisitionPath string, builder opds.EntryBuilder, s OPDS) opds.EntryBuilder {
-	if s.UseCalibreCovers {
-		coverPath := filepath.Dir(akquisitionPath) + "/cover.jpg"
-		stat, err := os.Stat(coverPath)
+// cover is the image of a book, a local file or a remote url
+type cover struct {
+	href      string
+	mimeType  string
+	localPath string
+	// epubPath is the epub holding the image of the cover, it is read when the cover is opened
+	epubPath string
+	// content reads the image of a cover inside an epub
+	content func() ([]byte, error)
+}
+
+// local reports if the image of the cover can be read to resize it
+func (c cover) local() bool {
+	return c.localPath != "" || c.content != nil
+}
+
+// open returns the image of a local cover
+func (c cover) open() (io.ReadSeekCloser, error) {
+	if c.content != nil {
+		content, err := c.content()
+		if err != nil {
+			return nil, err
+		}
+		return nopSeekCloser{bytes.NewReader(content)}, nil
+	}
+	return os.Open(c.localPath)
+}
+
+// nopSeekCloser is an io.ReadSeekCloser of an image in memory
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
 
-		if err == nil {
+func (nopSeekCloser) Close() error { return nil }
+
+// serveCover streams the image of a local cover with its Content-Length, without reading it whole
+// in memory. Its Content-Type is detected from its content, the mimeType of the cover when it isn't an image.
+func serveCover(w http.ResponseWriter, req *http.Request, c cover) error {
+	f, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var head [512]byte
+	n, err := io.ReadFull(f, head[:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	mimeType := c.mimeType
+	if detected := http.DetectContentType(head[:n]); strings.HasPrefix(detected, "image/") {
+		mimeType = detected
+	}
+	// the files keep their modification time for the conditional requests like with http.ServeFile
+	modTime := TimeNow()
+	if f, ok := f.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil {
+			modTime = fi.ModTime()
+		}
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	http.ServeContent(w, req, "", modTime, f)
+	return nil
+}
+
+// resolveCover returns the cover of a book, a calibre cover.jpg next to it is preferred
+// over the cover url of its metadata sidecar and then over the cover inside an epub.
+func (s OPDS) resolveCover(akquisitionPath string) (cover, bool) {
+	if s.UseCalibreCovers {
+		if coverPath, ok := calibreCoverPath(filepath.Dir(akquisitionPath)); ok {
 			_, coverPathRelativeToContentRoot, _ := strings.Cut(coverPath, s.TrustedRoot+"/")
 
-			builder = builder.AddLink(opds.LinkBuilder.
-				Rel("http://opds-spec.org/image").
-				Href(filepath.Join("/shelf", url.PathEscape(coverPathRelativeToContentRoot))).
-				Type(getType(stat.Name(), pathTypeFile)).
-				Build())
+			return cover{
+				href:      s.href(filepath.Join("/shelf", escapePath(coverPathRelativeToContentRoot))),
+				mimeType:  getType(coverPath, pathTypeFile),
+				localPath: coverPath,
+			}, true
 		}
 	}
 
+	if s.SidecarMetadata {
+		if meta, ok := readSidecar(filepath.Dir(akquisitionPath)); ok && meta.Cover != "" {
+			return cover{href: meta.Cover, mimeType: remoteImageType(meta.Cover)}, true
+		}
+	}
+
+	if mimeType, ok := s.readEmbeddedCover(akquisitionPath); ok {
+		return s.embeddedCover(akquisitionPath, mimeType), true
+	}
+
+	return cover{}, false
+}
+
+// addAuthors sets the authors of a book from its epub metadata when EpubMetadata is enabled,
+// otherwise from its folders when AuthorFromFolder is enabled.
+func (s OPDS) addAuthors(bookPath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	for _, author := range s.bookAuthors(bookPath) {
+		builder = builder.AddAuthor(opds.AuthorBuilder.Name(author).Build())
+	}
+	return builder
+}
+
+// bookAuthors returns the authors of a book from its epub metadata or its folders, see addAuthors
+func (s OPDS) bookAuthors(bookPath string) []string {
+	if authors := s.epubAuthors(bookPath); len(authors) > 0 {
+		return authors
+	}
+
+	if author := s.folderAuthor(bookPath); author != "" {
+		return []string{author}
+	}
+	return nil
+}
+
+// folderAuthor returns the author of a book from the folders it is in when AuthorFromFolder is enabled:
+// the grandparent folder for Author/Title/book.epub or the parent folder for Author/book.epub.
+// Books in the TrustedRoot have no author.
+func (s OPDS) folderAuthor(bookPath string) string {
+	if !s.AuthorFromFolder {
+		return ""
+	}
+
+	_, pathRelativeToContentRoot, _ := strings.Cut(bookPath, s.TrustedRoot+"/")
+	folders := strings.Split(filepath.ToSlash(filepath.Dir(pathRelativeToContentRoot)), "/")
+
+	switch {
+	case len(folders) >= 2:
+		return folders[len(folders)-2]
+	case folders[0] != currentDirectory:
+		return folders[0]
+	default:
+		return ""
+	}
+}
+
+func addCoverIfExists(akquisitionPath string, builder opds.EntryBuilder, s OPDS, req *http.Request) opds.EntryBuilder {
+	c, hasCover := s.resolveCover(akquisitionPath)
+	if !hasCover {
+		// the placeholders are not the covers of the books, they are never hashed nor resized
+		c, hasCover = s.placeholderCover(akquisitionPath)
+	}
+	if hasCover {
+		if href, ok := s.hashedCoverHref(akquisitionPath, c); ok {
+			c.href = href
+		}
+		builder = builder.AddLink(opds.LinkBuilder.
+			Rel("http://opds-spec.org/image").
+			Href(c.href).
+			Type(c.mimeType).
+			Build())
+	}
+
+	_, pathRelativeToContentRoot, _ := strings.Cut(akquisitionPath, s.TrustedRoot+"/")
+	thumbnailHref := s.href(thumbnailPathPrefix + escapePath(pathRelativeToContentRoot))
+
+	// a pre-generated thumbnail is preferred, then one resized on the fly and then the full cover,
+	// so the readers asking for thumbnails find one for every cover
+	switch {
+	case s.pregeneratedThumbnail(akquisitionPath) != "":
+		builder = builder.AddLink(opds.LinkBuilder.
+			Rel("http://opds-spec.org/image/thumbnail").
+			Href(thumbnailHref).
+			Type("image/jpeg").
+			Build())
+	case s.Thumbnails && hasCover && c.local():
+		builder = builder.AddLink(opds.LinkBuilder.
+			Rel("http://opds-spec.org/image/thumbnail").
+			Href(thumbnailHref).
+			Type(s.thumbnailType(req)).
+			Build())
+	case hasCover:
+		builder = builder.AddLink(opds.LinkBuilder.
+			Rel("http://opds-spec.org/image/thumbnail").
+			Href(c.href).
+			Type(c.mimeType).
+			Build())
+	}
+
 	return builder
 }
@@ -1,15 +1,30 @@
 package service_test
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/dubyte/dir2opds/internal/service"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/blog/atom"
 )
 
 func TestHandler(t *testing.T) {
@@ -26,13 +41,14 @@ func TestHandler(t *testing.T) {
 		wantedStatusCode  int
 	}{
 		"root navigation":                     {input: "/", want: root, WantedContentType: "application/atom+xml;profile=opds-catalog;kind=navigation", wantedStatusCode: 200},
-		"newest 15 books":                     {input: "/new", want: newest, WantedContentType: "application/atom+xml;profile=opds-catalog;kind=navigation", wantedStatusCode: 200},
+		"newest 15 books":                     {input: "/new", want: newest, WantedContentType: "application/atom+xml;profile=opds-catalog;kind=acquisition", wantedStatusCode: 200},
 		"feed (dir of dirs )":                 {input: "/shelf", want: all, WantedContentType: "application/atom+xml;profile=opds-catalog;kind=navigation", wantedStatusCode: 200},
 		"acquisitionFeed(dir of files)":       {input: "/shelf/mybook", want: acquisitionFeed, WantedContentType: "application/atom+xml;profile=opds-catalog;kind=acquisition", wantedStatusCode: 200},
 		"servingAFile":                        {input: "/shelf/mybook/mybook.txt", want: "Fixture", WantedContentType: "text/plain; charset=utf-8", wantedStatusCode: 200},
 		"is not serving hidden file":          {input: "/shelf/.Trash/mybook.epub", want: "Fixture", WantedContentType: "text/plain", wantedStatusCode: 404},
 		"serving file with spaces":            {input: "/shelf/mybook/mybook%20copy.txt", want: "Fixture", WantedContentType: "text/plain; charset=utf-8", wantedStatusCode: 200},
 		"http trasversal vulnerability check": {input: "/shelf/../../../../mybook", want: all, WantedContentType: "application/atom+xml;profile=opds-catalog;kind=navigation", wantedStatusCode: 404},
+		"backslash trasversal check":          {input: `/shelf/mybook\..\..\..\mybook`, want: all, WantedContentType: "application/atom+xml;profile=opds-catalog;kind=navigation", wantedStatusCode: 404},
 		"search definition":                   {input: "/opensearch.xml", want: searchDefinition, WantedContentType: "application/xml", wantedStatusCode: 200},
 		"search result":                       {input: "/search?q=mybook", want: searchResult, WantedContentType: "application/atom+xml;profile=opds-catalog;kind=acquisition", wantedStatusCode: 200},
 	}
@@ -40,7 +56,7 @@ func TestHandler(t *testing.T) {
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			// setup
-			s := service.OPDS{"testdata", true, true, true, true}
+			s := service.OPDS{TrustedRoot: "testdata", HideCalibreFiles: true, UseCalibreCovers: true, HideDotFiles: true, NoCache: true}
 			w := httptest.NewRecorder()
 			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
 			service.TimeNow = func() time.Time {
@@ -71,12 +87,1890 @@ func TestHandler(t *testing.T) {
 
 }
 
+func TestHandlerNavEntries(t *testing.T) {
+	// pre-setup
+	nowFn := service.TimeNow
+	defer func() {
+		service.TimeNow = nowFn
+	}()
+
+	// setup
+	s := service.OPDS{
+		TrustedRoot: "testdata",
+		NavEntries:  []service.NavEntry{{Title: "Magazines", Href: "/shelf/magazines", Rel: "subsection"}},
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	service.TimeNow = func() time.Time {
+		return time.Date(2020, 05, 25, 00, 00, 00, 0, time.UTC)
+	}
+
+	// act
+	err := s.Handler(w, req)
+	require.NoError(t, err)
+
+	// verify
+	body := w.Body.String()
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, body, `<title>Magazines</title>
+          <id>/shelf/magazines</id>
+          <link rel="subsection" href="/shelf/magazines" type="application/atom+xml;profile=opds-catalog;kind=navigation"></link>`)
+	assert.Less(t, strings.Index(body, "All books"), strings.Index(body, "Magazines"))
+}
+
+func TestHandlerAbout(t *testing.T) {
+	// setup
+	s := service.OPDS{TrustedRoot: "testdata", HideCalibreFiles: true, HideDotFiles: true, Version: "v1.2.3"}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/about", nil)
+	req.SetBasicAuth("reader", "s3cr3t")
+
+	// act
+	err := s.Handler(w, req)
+	require.NoError(t, err)
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var got struct {
+		Version     string          `json:"version"`
+		GoVersion   string          `json:"goVersion"`
+		TrustedRoot string          `json:"trustedRoot"`
+		Options     map[string]bool `json:"options"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, "v1.2.3", got.Version)
+	assert.Equal(t, runtime.Version(), got.GoVersion)
+	assert.Equal(t, "testdata", got.TrustedRoot)
+	assert.True(t, got.Options["hideCalibreFiles"])
+	assert.True(t, got.Options["hideDotFiles"])
+	assert.False(t, got.Options["useCalibreCovers"])
+	assert.NotContains(t, w.Body.String(), "s3cr3t")
+	assert.NotContains(t, w.Body.String(), "reader")
+}
+
+func TestHandlerNewestDays(t *testing.T) {
+	// setup, the days are counted back from the time of the request
+	now := time.Now()
+	dir := t.TempDir()
+	books := map[string]time.Duration{
+		"yesterday.epub":  20 * time.Hour,
+		"last-week.epub":  7 * 24 * time.Hour,
+		"last-month.epub": 31 * 24 * time.Hour,
+		"last-year.epub":  365 * 24 * time.Hour,
+	}
+	for name, age := range books {
+		fPath := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(fPath, []byte("Fixture"), 0o644))
+		require.NoError(t, os.Chtimes(fPath, now.Add(-age), now.Add(-age)))
+	}
+	s := service.OPDS{TrustedRoot: dir}
+
+	tests := map[string]struct {
+		input string
+		want  []string
+	}{
+		"within 30 days": {input: "/new?days=30", want: []string{"yesterday.epub", "last-week.epub"}},
+		"within 1 day":   {input: "/new?days=1", want: []string{"yesterday.epub"}},
+		"without days":   {input: "/new", want: []string{"yesterday.epub", "last-week.epub", "last-month.epub", "last-year.epub"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			err := s.Handler(w, req)
+			require.NoError(t, err)
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.want, entryTitles(t, w.Body.Bytes()))
+		})
+	}
+}
+
+// entryTitles returns the titles of the entries of a feed in document order.
+func entryTitles(t *testing.T, body []byte) []string {
+	t.Helper()
+	var feed struct {
+		Entry []struct {
+			Title string `xml:"title"`
+		} `xml:"entry"`
+	}
+	require.NoError(t, xml.Unmarshal(body, &feed))
+	titles := []string{}
+	for _, e := range feed.Entry {
+		titles = append(titles, e.Title)
+	}
+	return titles
+}
+
+func TestHandlerMixedDirectory(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "series", "extras"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "series", "book 1.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "series", "book 2.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "series", "extras", "map.pdf"), []byte("Fixture"), 0o644))
+	s := service.OPDS{TrustedRoot: dir}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf/series", nil)
+
+	// act
+	err := s.Handler(w, req)
+	require.NoError(t, err)
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/atom+xml;profile=opds-catalog;kind=acquisition", w.Header().Get("Content-Type"))
+	assert.Equal(t, []string{"extras", "book 1.epub", "book 2.epub"}, entryTitles(t, w.Body.Bytes()))
+	assert.Contains(t, w.Body.String(), `<link rel="subsection" href="/shelf/series/extras" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="extras"></link>`)
+}
+
+func TestHandlerHrefRewriter(t *testing.T) {
+	// setup
+	s := service.OPDS{
+		TrustedRoot:      "testdata",
+		HideCalibreFiles: true,
+		UseCalibreCovers: true,
+		HideDotFiles:     true,
+		HrefRewriter:     func(href string) string { return "/cdn" + href },
+	}
+	hrefs := regexp.MustCompile(`(?:href|template)="([^"]*)"`)
+
+	for _, input := range []string{"/", "/new", "/shelf", "/shelf/mybook", "/search?q=mybook", "/opensearch.xml"} {
+		t.Run(input, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, input, nil)
+
+			// act
+			err := s.Handler(w, req)
+			require.NoError(t, err)
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			matches := hrefs.FindAllStringSubmatch(w.Body.String(), -1)
+			require.NotEmpty(t, matches)
+			for _, m := range matches {
+				assert.True(t, strings.HasPrefix(m[1], "/cdn/"), "href %q is not rewritten", m[1])
+				assert.False(t, strings.HasPrefix(m[1], "/cdn/cdn"), "href %q is rewritten twice", m[1])
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	books := t.TempDir()
+
+	tests := map[string]struct {
+		opds    service.OPDS
+		wantErr bool
+	}{
+		"books dir":                {opds: service.OPDS{TrustedRoot: books}, wantErr: false},
+		"filesystem root":          {opds: service.OPDS{TrustedRoot: "/"}, wantErr: true},
+		"home dir":                 {opds: service.OPDS{TrustedRoot: home}, wantErr: true},
+		"home dir with dots":       {opds: service.OPDS{TrustedRoot: filepath.Join(home, "..", filepath.Base(home))}, wantErr: true},
+		"not existent":             {opds: service.OPDS{TrustedRoot: filepath.Join(books, "missing")}, wantErr: true},
+		"filesystem root override": {opds: service.OPDS{TrustedRoot: "/", AllowUnsafeRoot: true}, wantErr: false},
+		"home dir override":        {opds: service.OPDS{TrustedRoot: home, AllowUnsafeRoot: true}, wantErr: false},
+		"not existent override":    {opds: service.OPDS{TrustedRoot: filepath.Join(books, "missing"), AllowUnsafeRoot: true}, wantErr: true},
+		"custom root without nav":  {opds: service.OPDS{TrustedRoot: books, CustomRootOnly: true}, wantErr: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.opds.Validate()
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestHandlerCalibreFiles(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for _, name := range []string{
+		"The .opf Story.epub",
+		"metadata.db backup.epub",
+		"my cover.epub",
+		"cover.epub",
+		"book.epub",
+		"cover.jpg",
+		"metadata.opf",
+		"metadata.db",
+		"metadata_db_prefs_backup.json",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("Fixture"), 0o644))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, ".caltrash"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".caltrash", "deleted.epub"), []byte("Fixture"), 0o644))
+	s := service.OPDS{TrustedRoot: dir, HideCalibreFiles: true}
+
+	// act
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+	require.NoError(t, s.Handler(w, req))
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"book.epub", "cover.epub", "metadata.db backup.epub", "my cover.epub", "The .opf Story.epub"}, entryTitles(t, w.Body.Bytes()))
+
+	// files inside calibre folders are not served
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/shelf/.caltrash/deleted.epub", nil)
+	require.NoError(t, s.Handler(w, req))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandlerBuildTimeout(t *testing.T) {
+	tests := map[string]struct {
+		timeout          time.Duration
+		wantedStatusCode int
+	}{
+		"expired":    {timeout: time.Nanosecond, wantedStatusCode: http.StatusServiceUnavailable},
+		"in time":    {timeout: time.Minute, wantedStatusCode: http.StatusOK},
+		"no timeout": {timeout: 0, wantedStatusCode: http.StatusOK},
+	}
+
+	for name, tc := range tests {
+		for _, input := range []string{"/", "/new", "/shelf", "/shelf/mybook", "/search?q=mybook", "/calendar"} {
+			t.Run(name+" "+input, func(t *testing.T) {
+				// setup
+				s := service.OPDS{TrustedRoot: "testdata", HideDotFiles: true, BuildTimeout: tc.timeout}
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, input, nil)
+
+				// act
+				err := s.Handler(w, req)
+				require.NoError(t, err)
+
+				// verify
+				assert.Equal(t, tc.wantedStatusCode, w.Code)
+			})
+		}
+	}
+}
+
+func TestHandlerTrailingSlash(t *testing.T) {
+	// setup
+	s := service.OPDS{TrustedRoot: "testdata", HideCalibreFiles: true, HideDotFiles: true}
+
+	for _, dirURL := range []string{"/shelf/mybook", "/shelf", "/search"} {
+		t.Run(dirURL, func(t *testing.T) {
+			// act
+			var bodies []string
+			for _, input := range []string{dirURL, dirURL + "/", dirURL + "//"} {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, input+"?q=book", nil)
+				require.NoError(t, s.Handler(w, req))
+				require.Equal(t, http.StatusOK, w.Code)
+				bodies = append(bodies, w.Body.String())
+			}
+
+			// verify
+			assert.Equal(t, bodies[0], bodies[1])
+			assert.Equal(t, bodies[0], bodies[2])
+		})
+	}
+}
+
+func TestHandlerChildHrefsIgnoreQuery(t *testing.T) {
+	// setup
+	s := service.OPDS{TrustedRoot: "testdata", HideCalibreFiles: true, HideDotFiles: true}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf/new%20folder?q=book&page=2", nil)
+
+	// act
+	require.NoError(t, s.Handler(w, req))
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `href="/shelf/new%20folder/mybook.txt"`)
+	assert.NotContains(t, w.Body.String(), "page=2")
+}
+
+func TestHandlerOpenAccess(t *testing.T) {
+	tests := map[string]struct {
+		openAccess bool
+		wantRel    string
+	}{
+		"generic":     {openAccess: false, wantRel: "http://opds-spec.org/acquisition"},
+		"open-access": {openAccess: true, wantRel: "http://opds-spec.org/acquisition/open-access"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// setup
+			s := service.OPDS{TrustedRoot: "testdata", HideCalibreFiles: true, HideDotFiles: true, UseCalibreCovers: true, OpenAccess: tc.openAccess}
+
+			for _, input := range []string{"/shelf/with%20cover", "/new", "/search?q=mybook"} {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, input, nil)
+
+				// act
+				require.NoError(t, s.Handler(w, req))
+
+				// verify
+				require.Equal(t, http.StatusOK, w.Code)
+				assert.Contains(t, w.Body.String(), `<link rel="`+tc.wantRel+`" href=`, input)
+			}
+		})
+	}
+}
+
+func TestHandlerNewestSameNameTie(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	modTime := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, folder := range []string{"zeta", "alpha", "mid"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, folder), 0o755))
+		fPath := filepath.Join(dir, folder, "mybook.epub")
+		require.NoError(t, os.WriteFile(fPath, []byte("Fixture"), 0o644))
+		require.NoError(t, os.Chtimes(fPath, modTime, modTime))
+	}
+	s := service.OPDS{TrustedRoot: dir}
+
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/new", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify same mtime and name are ordered by their path
+		require.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		alpha := strings.Index(body, `href="/shelf/alpha/mybook.epub"`)
+		mid := strings.Index(body, `href="/shelf/mid/mybook.epub"`)
+		zeta := strings.Index(body, `href="/shelf/zeta/mybook.epub"`)
+		require.True(t, alpha > 0 && mid > 0 && zeta > 0, body)
+		assert.True(t, alpha < mid && mid < zeta, body)
+	}
+}
+
+func TestHandlerMixedRoot(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "series"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "series", "book 1.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "loose.epub"), []byte("Fixture"), 0o644))
+	s := service.OPDS{TrustedRoot: dir}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+	// act
+	err := s.Handler(w, req)
+	require.NoError(t, err)
+
+	// verify the loose books and the folders are listed
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/atom+xml;profile=opds-catalog;kind=acquisition", w.Header().Get("Content-Type"))
+	assert.Equal(t, []string{"series", "loose.epub"}, entryTitles(t, w.Body.Bytes()))
+	assert.Contains(t, w.Body.String(), `<link rel="subsection" href="/shelf/series" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="series"></link>`)
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/acquisition" href="/shelf/loose.epub" type="application/epub+zip" title="loose.epub"></link>`)
+}
+
+func TestHandlerEbookExtensionsOnly(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "notes", "drafts"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes", "notes.txt"), []byte("Fixture"), 0o644))
+
+	tests := map[string]struct {
+		ebookExtensionsOnly bool
+		wantType            string
+	}{
+		"any file":    {ebookExtensionsOnly: false, wantType: "application/atom+xml;profile=opds-catalog;kind=acquisition"},
+		"ebooks only": {ebookExtensionsOnly: true, wantType: "application/atom+xml;profile=opds-catalog;kind=navigation"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, EbookExtensionsOnly: tc.ebookExtensionsOnly}
+
+			// act
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+			require.NoError(t, s.Handler(w, req))
+
+			// verify the parent links to the folder with its type
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), `<link rel="subsection" href="/shelf/notes" type="`+tc.wantType+`" title="notes"></link>`)
+
+			// act
+			w = httptest.NewRecorder()
+			req = httptest.NewRequest(http.MethodGet, "/shelf/notes", nil)
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.wantType, w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestHandlerAuthorFromFolder(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "Tolkien", "The Hobbit"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Tolkien", "The Hobbit", "book.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Tolkien", "silmarillion.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "loose.epub"), []byte("Fixture"), 0o644))
+
+	tests := map[string]struct {
+		input            string
+		authorFromFolder bool
+		wantAuthor       bool
+	}{
+		"title folder":       {input: "/shelf/Tolkien/The%20Hobbit", authorFromFolder: true, wantAuthor: true},
+		"author folder":      {input: "/shelf/Tolkien", authorFromFolder: true, wantAuthor: true},
+		"root":               {input: "/shelf", authorFromFolder: true, wantAuthor: false},
+		"newest":             {input: "/new", authorFromFolder: true, wantAuthor: true},
+		"search":             {input: "/search?q=book", authorFromFolder: true, wantAuthor: true},
+		"option not enabled": {input: "/shelf/Tolkien/The%20Hobbit", authorFromFolder: false, wantAuthor: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, AuthorFromFolder: tc.authorFromFolder}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			if tc.wantAuthor {
+				assert.Contains(t, w.Body.String(), "<author>\n              <name>Tolkien</name>\n          </author>")
+			} else {
+				assert.NotContains(t, w.Body.String(), "<author>")
+			}
+		})
+	}
+}
+
+func TestHandlerNewestPages(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	var newestFirst []string
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("book %02d.epub", i)
+		fPath := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(fPath, []byte("Fixture"), 0o644))
+		modTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Hour)
+		require.NoError(t, os.Chtimes(fPath, modTime, modTime))
+		newestFirst = append([]string{name}, newestFirst...)
+	}
+	s := service.OPDS{TrustedRoot: dir}
+
+	tests := map[string]struct {
+		input        string
+		wantTitles   []string
+		wantPrevious bool
+		wantNext     bool
+	}{
+		"first page":  {input: "/new", wantTitles: newestFirst[:14], wantNext: true},
+		"second page": {input: "/new?page=2", wantTitles: newestFirst[14:28], wantPrevious: true, wantNext: true},
+		"last page":   {input: "/new?page=3", wantTitles: newestFirst[28:], wantPrevious: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.wantTitles, entryTitles(t, w.Body.Bytes()))
+
+			previous := strings.Contains(w.Body.String(), `<link rel="previous"`)
+			next := strings.Contains(w.Body.String(), `<link rel="next"`)
+			assert.Equal(t, tc.wantPrevious, previous)
+			assert.Equal(t, tc.wantNext, next)
+		})
+	}
+
+	// the links point to the adjacent pages
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/new?page=2", nil)
+	require.NoError(t, s.Handler(w, req))
+	assert.Contains(t, w.Body.String(), `<link rel="previous" href="/new?page=1" type="application/atom+xml;profile=opds-catalog;kind=acquisition"></link>`)
+	assert.Contains(t, w.Body.String(), `<link rel="next" href="/new?page=3" type="application/atom+xml;profile=opds-catalog;kind=acquisition"></link>`)
+
+	// invalid pages are rejected
+	req = httptest.NewRequest(http.MethodGet, "/new?page=0", nil)
+	assert.Error(t, s.Handler(httptest.NewRecorder(), req))
+}
+
+func TestHandlerCoverIsNotAnEntry(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "mybook"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "artwork"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook", "mybook.epub"), []byte("Fixture"), 0o644))
+	writeJPEG(t, filepath.Join(dir, "mybook", "cover.jpg"), 10, 15)
+	writeJPEG(t, filepath.Join(dir, "artwork", "cover.jpg"), 10, 15)
+	s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true}
+
+	tests := map[string]struct {
+		input      string
+		wantTitles []string
+	}{
+		"book folder":          {input: "/shelf/mybook", wantTitles: []string{"mybook.epub"}},
+		"folder without books": {input: "/shelf/artwork", wantTitles: []string{"cover.jpg"}},
+		"search":               {input: "/search?q=.", wantTitles: []string{"cover.jpg", "mybook.epub"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.wantTitles, entryTitles(t, w.Body.Bytes()))
+		})
+	}
+
+	// the cover is still served
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf/mybook/cover.jpg", nil)
+	require.NoError(t, s.Handler(w, req))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHandlerEscapedHrefs(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "my folder", "sub#dir"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "my folder", "sub#dir", "my book?.epub"), []byte("Fixture"), 0o644))
+	s := service.OPDS{TrustedRoot: dir}
+	want := `href="/shelf/my%20folder/sub%23dir/my%20book%3F.epub"`
+
+	for _, input := range []string{"/search?q=book", "/new", "/shelf/my%20folder/sub%23dir"} {
+		t.Run(input, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify the slashes are preserved and the segments are escaped
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), want)
+
+			// the href is served
+			w = httptest.NewRecorder()
+			req = httptest.NewRequest(http.MethodGet, "/shelf/my%20folder/sub%23dir/my%20book%3F.epub", nil)
+			require.NoError(t, s.Handler(w, req))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "Fixture", w.Body.String())
+		})
+	}
+}
+
+func TestHandlerBasePath(t *testing.T) {
+	// setup
+	s := service.OPDS{TrustedRoot: "testdata", HideCalibreFiles: true, HideDotFiles: true, BasePath: "/opds"}
+
+	tests := map[string]struct {
+		input            string
+		wantedStatusCode int
+		wantID           string
+	}{
+		"bare base path":            {input: "/opds", wantedStatusCode: 200, wantID: "<id>/</id>"},
+		"base path with slash":      {input: "/opds/", wantedStatusCode: 200, wantID: "<id>/</id>"},
+		"folder under base path":    {input: "/opds/shelf/mybook", wantedStatusCode: 200, wantID: "<id>/shelf/mybook</id>"},
+		"outside base path":         {input: "/shelf", wantedStatusCode: 404},
+		"prefix is not a base path": {input: "/opdsx", wantedStatusCode: 404},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, tc.wantedStatusCode, w.Code)
+			if tc.wantedStatusCode != 200 {
+				return
+			}
+			assert.Contains(t, w.Body.String(), tc.wantID)
+			assert.Contains(t, w.Body.String(), `<link rel="start" href="/opds/" type="application/atom+xml;profile=opds-catalog;kind=navigation"></link>`)
+			assert.Contains(t, w.Body.String(), `<link rel="search" href="/opds/opensearch.xml" type="application/opensearchdescription+xml"></link>`)
+		})
+	}
+
+	// the hrefs of the root and folders are under the base path
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/opds", nil)
+	require.NoError(t, s.Handler(w, req))
+	assert.Contains(t, w.Body.String(), `href="/opds/shelf"`)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/opds/shelf/mybook", nil)
+	require.NoError(t, s.Handler(w, req))
+	assert.Contains(t, w.Body.String(), `href="/opds/shelf/mybook/mybook.epub"`)
+}
+
+func TestHandlerSearchDefinitionNames(t *testing.T) {
+	tests := map[string]struct {
+		title           string
+		description     string
+		wantShortName   string
+		wantDescription string
+	}{
+		"default":         {wantShortName: "dir2opds", wantDescription: "Search the books of dir2opds"},
+		"configured":      {title: "Home library", description: "Our family books", wantShortName: "Home library", wantDescription: "Our family books"},
+		"long short name": {title: "The library of the Müller family", wantShortName: "The library of t", wantDescription: "Search the books of The library of the Müller family"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// setup
+			s := service.OPDS{TrustedRoot: "testdata", Title: tc.title, Description: tc.description}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/opensearch.xml", nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			var got struct {
+				ShortName   string `xml:"ShortName"`
+				Description string `xml:"Description"`
+			}
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, tc.wantShortName, got.ShortName)
+			assert.Equal(t, tc.wantDescription, got.Description)
+		})
+	}
+}
+
+func TestHandlerProvider(t *testing.T) {
+	for name, provider := range map[string]atom.Person{
+		"configured": {Name: "Family library", URI: "https://library.example", Email: "books@library.example"},
+		"unset":      {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// setup
+			s := service.OPDS{TrustedRoot: "testdata", HideCalibreFiles: true, HideDotFiles: true, Provider: provider}
+
+			for _, input := range []string{"/", "/new", "/shelf", "/shelf/mybook", "/search?q=mybook"} {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, input, nil)
+
+				// act
+				require.NoError(t, s.Handler(w, req))
+
+				// verify
+				require.Equal(t, http.StatusOK, w.Code)
+				if provider.Name == "" {
+					assert.NotContains(t, w.Body.String(), "<author>", input)
+					continue
+				}
+				assert.Contains(t, w.Body.String(), `
+      <author>
+          <name>Family library</name>
+          <uri>https://library.example</uri>
+          <email>books@library.example</email>
+      </author>`, input)
+			}
+		})
+	}
+}
+
+func TestHandlerAuthorize(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for _, folder := range []string{"kids", "adults"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, folder), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, folder, folder+" book.epub"), []byte("Fixture"), 0o644))
+	}
+	s := service.OPDS{TrustedRoot: dir, Authorize: func(user, relPath string) bool {
+		return user != "child" || relPath != "adults"
+	}}
+
+	tests := map[string]struct {
+		user             string
+		input            string
+		wantedStatusCode int
+		wantTitles       []string
+	}{
+		"child shelf":        {user: "child", input: "/shelf", wantedStatusCode: 200, wantTitles: []string{"kids"}},
+		"child newest":       {user: "child", input: "/new", wantedStatusCode: 200, wantTitles: []string{"kids book.epub"}},
+		"child search":       {user: "child", input: "/search?q=book", wantedStatusCode: 200, wantTitles: []string{"kids book.epub"}},
+		"child hidden":       {user: "child", input: "/shelf/adults", wantedStatusCode: 403},
+		"child hidden book":  {user: "child", input: "/shelf/adults/adults%20book.epub", wantedStatusCode: 403},
+		"parent shelf":       {user: "parent", input: "/shelf", wantedStatusCode: 200, wantTitles: []string{"adults", "kids"}},
+		"parent hidden book": {user: "parent", input: "/shelf/adults/adults%20book.epub", wantedStatusCode: 200},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+			req.SetBasicAuth(tc.user, "s3cr3t")
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, tc.wantedStatusCode, w.Code)
+			if tc.wantTitles != nil {
+				titles := entryTitles(t, w.Body.Bytes())
+				sort.Strings(titles)
+				assert.Equal(t, tc.wantTitles, titles)
+			}
+		})
+	}
+}
+
+func TestHandlerBlockFunc(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "banned"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "banned", "banned book.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "banned book.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "free book.epub"), []byte("Fixture"), 0o644))
+	s := service.OPDS{TrustedRoot: dir, BlockFunc: func(relPath string, req *http.Request) bool {
+		return req.Header.Get("X-Region") == "xx" && (relPath == "banned" || relPath == "banned book.epub")
+	}}
+
+	tests := map[string]struct {
+		region           string
+		input            string
+		wantedStatusCode int
+		wantTitles       []string
+	}{
+		"blocked shelf":       {region: "xx", input: "/shelf", wantedStatusCode: 200, wantTitles: []string{"free book.epub"}},
+		"blocked newest":      {region: "xx", input: "/new", wantedStatusCode: 200, wantTitles: []string{"free book.epub"}},
+		"blocked search":      {region: "xx", input: "/search?q=book", wantedStatusCode: 200, wantTitles: []string{"free book.epub"}},
+		"blocked book":        {region: "xx", input: "/shelf/banned%20book.epub", wantedStatusCode: 451},
+		"blocked folder":      {region: "xx", input: "/shelf/banned", wantedStatusCode: 451},
+		"blocked folder book": {region: "xx", input: "/shelf/banned/banned%20book.epub", wantedStatusCode: 451},
+		"other region shelf":  {region: "yy", input: "/shelf", wantedStatusCode: 200, wantTitles: []string{"banned", "banned book.epub", "free book.epub"}},
+		"other region book":   {region: "yy", input: "/shelf/banned%20book.epub", wantedStatusCode: 200},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+			req.Header.Set("X-Region", tc.region)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, tc.wantedStatusCode, w.Code)
+			if tc.wantTitles != nil {
+				titles := entryTitles(t, w.Body.Bytes())
+				sort.Strings(titles)
+				assert.Equal(t, tc.wantTitles, titles)
+			}
+		})
+	}
+}
+
+func TestHandlerBackslashFileName(t *testing.T) {
+	if filepath.Separator == '\\' {
+		t.Skip("a backslash is a path separator on this os")
+	}
+
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, `sci\fi`), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, `sci\fi`, `dune\part one.epub`), []byte("Fixture"), 0o644))
+	s := service.OPDS{TrustedRoot: dir}
+
+	tests := map[string]struct {
+		input            string
+		wantedStatusCode int
+		want             string
+	}{
+		"folder":           {input: "/shelf", wantedStatusCode: 200, want: `href="/shelf/sci%5Cfi"`},
+		"book":             {input: "/shelf/sci%5Cfi", wantedStatusCode: 200, want: `href="/shelf/sci%5Cfi/dune%5Cpart%20one.epub"`},
+		"download":         {input: "/shelf/sci%5Cfi/dune%5Cpart%20one.epub", wantedStatusCode: 200, want: "Fixture"},
+		"trasversal check": {input: `/shelf/sci\fi\..\..\..\etc`, wantedStatusCode: 404},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, tc.wantedStatusCode, w.Code)
+			assert.Contains(t, w.Body.String(), tc.want)
+		})
+	}
+}
+
+func TestHandlerSiblingOfTrustedRoot(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "books"), 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "books-private"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "books-private", "secret.epub"), []byte("Fixture"), 0o644))
+	s := service.OPDS{TrustedRoot: filepath.Join(dir, "books")}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf/../books-private/secret.epub", nil)
+
+	// act
+	require.NoError(t, s.Handler(w, req))
+
+	// verify a folder sharing the prefix of the TrustedRoot is not inside it
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandlerMaxFeedBytes(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "small"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "small", "book.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "huge"), 0o755))
+	for i := 0; i < 300; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "huge", fmt.Sprintf("book %03d.epub", i)), []byte("Fixture"), 0o644))
+	}
+	s := service.OPDS{TrustedRoot: dir, HTML: true, MaxFeedBytes: 16384}
+
+	tests := map[string]struct {
+		input            string
+		accept           string
+		wantedStatusCode int
+	}{
+		"small feed":      {input: "/shelf/small", wantedStatusCode: 200},
+		"huge feed":       {input: "/shelf/huge", wantedStatusCode: 413},
+		"huge html page":  {input: "/shelf/huge", accept: "text/html", wantedStatusCode: 413},
+		"huge feed book":  {input: "/shelf/huge/book%20000.epub", wantedStatusCode: 200},
+		"paginated feeds": {input: "/new", wantedStatusCode: 200},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+			req.Header.Set("Accept", tc.accept)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, tc.wantedStatusCode, w.Code)
+			if tc.wantedStatusCode == http.StatusRequestEntityTooLarge {
+				assert.Contains(t, w.Body.String(), "larger than 16384 bytes")
+			}
+		})
+	}
+}
+
+func TestHandlerRobotsTxt(t *testing.T) {
+	tests := map[string]struct {
+		robotsTxt string
+		want      string
+	}{
+		"default disallows all": {want: "User-agent: *\nDisallow: /\n"},
+		"configured policy":     {robotsTxt: "User-agent: *\nDisallow: /shelf\n", want: "User-agent: *\nDisallow: /shelf\n"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// setup
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "robots.txt"), []byte("a book, not the policy"), 0o644))
+			s := service.OPDS{TrustedRoot: dir, RobotsTxt: tc.robotsTxt}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/robots.txt", nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+			assert.Equal(t, tc.want, w.Body.String())
+		})
+	}
+}
+
+func TestHandlerShelfEntry(t *testing.T) {
+	tests := map[string]struct {
+		shelfTitle string
+		wantTitle  string
+	}{
+		"default title":    {wantTitle: "All books"},
+		"configured title": {shelfTitle: "Library", wantTitle: "Library"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// setup
+			s := service.OPDS{TrustedRoot: "testdata", ShelfTitle: tc.shelfTitle}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify the shelf is linked with the rel of any other folder
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), `<title>`+tc.wantTitle+`</title>
+          <id>/shelf</id>
+          <link rel="subsection" href="/shelf" type="application/atom+xml;profile=opds-catalog;kind=acquisition"></link>`)
+		})
+	}
+}
+
+func TestHandlerIncludeOnly(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "notes"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes", "todo.txt"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "mybook"), 0o755))
+	for _, name := range []string{"mybook.epub", "mybook.pdf", "mybook.txt", ".hidden.epub"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook", name), []byte("Fixture"), 0o644))
+	}
+	writeJPEG(t, filepath.Join(dir, "mybook", "cover.jpg"), 10, 15)
+	s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true, HideDotFiles: true, IncludeOnly: regexp.MustCompile(`\.epub$`)}
+
+	tests := map[string]struct {
+		input            string
+		wantedStatusCode int
+		wantTitles       []string
+	}{
+		"folder":               {input: "/shelf/mybook", wantedStatusCode: 200, wantTitles: []string{"mybook.epub"}},
+		"folder without books": {input: "/shelf/notes", wantedStatusCode: 200, wantTitles: []string{}},
+		"newest":               {input: "/new", wantedStatusCode: 200, wantTitles: []string{"mybook.epub"}},
+		"search":               {input: "/search?q=mybook", wantedStatusCode: 200, wantTitles: []string{"mybook.epub"}},
+		"included book":        {input: "/shelf/mybook/mybook.epub", wantedStatusCode: 200},
+		"cover":                {input: "/shelf/mybook/cover.jpg", wantedStatusCode: 200},
+		"excluded pdf":         {input: "/shelf/mybook/mybook.pdf", wantedStatusCode: 404},
+		"excluded txt":         {input: "/shelf/mybook/mybook.txt", wantedStatusCode: 404},
+		"hidden epub":          {input: "/shelf/mybook/.hidden.epub", wantedStatusCode: 404},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, tc.wantedStatusCode, w.Code)
+			if tc.wantTitles != nil {
+				assert.ElementsMatch(t, tc.wantTitles, entryTitles(t, w.Body.Bytes()))
+			}
+		})
+	}
+}
+
+func TestHandlerFolderUpdated(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "saga", "volume 2"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "saga", "book 1.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "saga", "volume 2", "book 2.epub"), []byte("Fixture"), 0o644))
+	book1 := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	volume2 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	book2 := time.Date(2023, 8, 9, 10, 11, 12, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "saga", "book 1.epub"), book1, book1))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "saga", "volume 2", "book 2.epub"), book2, book2))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "saga", "volume 2"), volume2, volume2))
+
+	tests := map[string]struct {
+		s    service.OPDS
+		want string
+	}{
+		"disabled": {s: service.OPDS{TrustedRoot: dir}, want: "<updated></updated>"},
+		"shallow":  {s: service.OPDS{TrustedRoot: dir, FolderUpdated: true}, want: "<updated>2022-01-01T00:00:00+00:00</updated>"},
+		"deep":     {s: service.OPDS{TrustedRoot: dir, FolderUpdated: true, FolderUpdatedDeep: true}, want: "<updated>2023-08-09T10:11:12+00:00</updated>"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+			// act
+			require.NoError(t, tc.s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), `<id>/shelf/saga</id>
+          <link rel="subsection" href="/shelf/saga" type="application/atom+xml;profile=opds-catalog;kind=acquisition" title="saga"></link>
+          <published></published>
+          `+tc.want)
+		})
+	}
+}
+
+func TestHandlerUnreadableDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("the permissions of the directory can't deny reading it")
+	}
+
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "locked"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "locked", "book.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.Chmod(filepath.Join(dir, "locked"), 0o311))
+	t.Cleanup(func() { os.Chmod(filepath.Join(dir, "locked"), 0o755) })
+	s := service.OPDS{TrustedRoot: dir}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf/locked", nil)
+
+	// act
+	err := s.Handler(w, req)
+
+	// verify the error is returned instead of serving an empty feed
+	assert.ErrorIs(t, err, fs.ErrPermission)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestHandlerSearchLikeFolderFeed(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "saga"), 0o755))
+	writeEPUB(t, filepath.Join(dir, "saga", "cover story.epub"), `<meta name="calibre:series_index" content="2"/>`)
+	writeEPUB(t, filepath.Join(dir, "saga", "last.epub"), `<meta name="calibre:series_index" content="12"/>`)
+	writeJPEG(t, filepath.Join(dir, "saga", "cover.jpg"), 10, 15)
+	s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true, EpubMetadata: true, SeriesIndexTitles: true}
+
+	for _, input := range []string{"/shelf/saga", "/search?q=cover"} {
+		t.Run(input, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify the cover is not an entry but the cover of the book, titled from its metadata
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.NotContains(t, entryTitles(t, w.Body.Bytes()), "cover.jpg")
+			assert.Contains(t, entryTitles(t, w.Body.Bytes()), "02 - cover story.epub")
+			assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/image" href="/shelf/saga/cover.jpg" type="image/jpeg"></link>`)
+		})
+	}
+}
+
+func TestHandlerNewestFormat(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	modTime := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	var comics []string
+	for i := 0; i < 16; i++ {
+		name := fmt.Sprintf("comic %02d.cbz", i)
+		comics = append(comics, name)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("Fixture"), 0o644))
+		require.NoError(t, os.Chtimes(filepath.Join(dir, name), modTime.Add(time.Duration(-i)*time.Hour), modTime.Add(time.Duration(-i)*time.Hour)))
+	}
+	for _, name := range []string{"novel.epub", "manual.PDF"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("Fixture"), 0o644))
+		require.NoError(t, os.Chtimes(filepath.Join(dir, name), modTime.Add(time.Hour), modTime.Add(time.Hour)))
+	}
+	s := service.OPDS{TrustedRoot: dir}
+
+	tests := map[string]struct {
+		input    string
+		want     []string
+		wantNext string
+	}{
+		"comics":                {input: "/new?format=cbz", want: comics[:14], wantNext: `<link rel="next" href="/new?format=cbz&amp;page=2"`},
+		"comics second page":    {input: "/new?format=cbz&page=2", want: comics[14:]},
+		"upper case extensions": {input: "/new?format=pdf", want: []string{"manual.PDF"}},
+		"unknown format":        {input: "/new?format=mobi", want: []string{}},
+		"every format":          {input: "/new?days=365000", want: append([]string{"manual.PDF", "novel.epub"}, comics...)},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.want, entryTitles(t, w.Body.Bytes()))
+			if tc.wantNext != "" {
+				assert.Contains(t, w.Body.String(), tc.wantNext)
+			}
+		})
+	}
+}
+
+func TestHandlerMaxTitleLength(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	name := strings.Repeat("é", 120) + ".epub"
+	longTitle := strings.Repeat("ü", 300)
+	writeEPUB(t, filepath.Join(dir, name), `<dc:title xml:lang="en">`+longTitle+`</dc:title><dc:title xml:lang="fr">`+longTitle+`</dc:title>`)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "short.epub"), []byte("Fixture"), 0o644))
+	s := service.OPDS{TrustedRoot: dir, EpubMetadata: true, MaxTitleLength: 64}
+
+	for _, input := range []string{"/shelf", "/new", "/search?q=epub"} {
+		t.Run(input, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), `<title>`+strings.Repeat("é", 63)+`…</title>`)
+			assert.Contains(t, w.Body.String(), `<title>short.epub</title>`)
+			assert.Contains(t, w.Body.String(), `<title xml:lang="en">`+strings.Repeat("ü", 63)+`…</title>`)
+			assert.Contains(t, w.Body.String(), `<title xml:lang="fr">`+strings.Repeat("ü", 63)+`…</title>`)
+			assert.Contains(t, w.Body.String(), `href="/shelf/`+url.PathEscape(name)+`"`)
+		})
+	}
+
+	// act
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf/"+url.PathEscape(name), nil)
+	require.NoError(t, s.Handler(w, req))
+
+	// verify the download keeps the full file name
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `attachment; filename="`+name+`"`, w.Header().Get("Content-Disposition"))
+}
+
+func TestHandlerMetadataWorkersKeepOrder(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "folder"), 0o755))
+	want := []string{"folder"}
+	for i := range 50 {
+		name := fmt.Sprintf("book %02d.epub", i)
+		writeEPUB(t, filepath.Join(dir, name), `<dc:creator>Author `+strconv.Itoa(i)+`</dc:creator>`)
+		want = append(want, name)
+	}
+
+	for _, workers := range []int{1, 8} {
+		t.Run(strconv.Itoa(workers), func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, EpubMetadata: true, BookLength: true, MetadataWorkers: workers}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify the folders first and then the books in the order of the listing
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, want, entryTitles(t, w.Body.Bytes()))
+			assert.Less(t, strings.Index(w.Body.String(), "Author 1<"), strings.Index(w.Body.String(), "Author 2<"))
+		})
+	}
+}
+
+func BenchmarkHandlerMetadataWorkers(b *testing.B) {
+	dir := b.TempDir()
+	var names []string
+	for i := range 500 {
+		names = append(names, filepath.Join(dir, fmt.Sprintf("book %03d.epub", i)))
+		require.NoError(b, os.WriteFile(names[i], bytes.Repeat([]byte("Fixture"), 1024), 0o644))
+	}
+
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(strconv.Itoa(workers), func(b *testing.B) {
+			s := service.OPDS{TrustedRoot: dir, IncludeChecksums: true, MetadataWorkers: workers}
+			for i := range b.N {
+				// a new modification time invalidates the cached checksums
+				b.StopTimer()
+				modTime := time.Unix(int64(i), 0)
+				for _, name := range names {
+					require.NoError(b, os.Chtimes(name, modTime, modTime))
+				}
+				b.StartTimer()
+
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+				require.NoError(b, s.Handler(w, req))
+			}
+		})
+	}
+}
+
+func TestHandlerCompactOutput(t *testing.T) {
+	// setup
+	pretty := service.OPDS{TrustedRoot: "testdata"}
+	compact := service.OPDS{TrustedRoot: "testdata", CompactOutput: true}
+
+	for _, input := range []string{"/", "/shelf/mybook", "/new"} {
+		t.Run(input, func(t *testing.T) {
+			// act
+			prettyW := httptest.NewRecorder()
+			require.NoError(t, pretty.Handler(prettyW, httptest.NewRequest(http.MethodGet, input, nil)))
+			compactW := httptest.NewRecorder()
+			require.NoError(t, compact.Handler(compactW, httptest.NewRequest(http.MethodGet, input, nil)))
+
+			// verify the compact feed is the same feed without indentation
+			require.Equal(t, http.StatusOK, compactW.Code)
+			assert.Less(t, compactW.Body.Len(), prettyW.Body.Len())
+			assert.Equal(t, 1, strings.Count(compactW.Body.String(), "\n"), "only the xml header ends with a new line")
+			assert.Equal(t, entryTitles(t, prettyW.Body.Bytes()), entryTitles(t, compactW.Body.Bytes()))
+
+			var feed atom.Feed
+			require.NoError(t, xml.Unmarshal(compactW.Body.Bytes(), &feed))
+		})
+	}
+}
+
+func TestHandlerCompactOutputFixture(t *testing.T) {
+	// setup
+	nowFn := service.TimeNow
+	defer func() {
+		service.TimeNow = nowFn
+	}()
+	service.TimeNow = func() time.Time {
+		return time.Date(2020, 05, 25, 00, 00, 00, 0, time.UTC)
+	}
+	s := service.OPDS{TrustedRoot: "testdata", HideCalibreFiles: true, UseCalibreCovers: true, HideDotFiles: true, NoCache: true, CompactOutput: true}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf/mybook", nil)
+
+	// act
+	require.NoError(t, s.Handler(w, req))
+
+	// verify the fixture without its indentation
+	compactAcquisitionFeed := xml.Header + regexp.MustCompile(`>\s+<`).ReplaceAllString(strings.TrimSpace(strings.TrimPrefix(acquisitionFeed, xml.Header)), "><")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, compactAcquisitionFeed, w.Body.String())
+}
+
+func TestHandlerDisableSearch(t *testing.T) {
+	// setup
+	s := service.OPDS{TrustedRoot: "testdata", HideDotFiles: true, DisableSearch: true}
+
+	for _, input := range []string{"/search?q=mybook", "/opensearch.xml", "/suggest?q=my"} {
+		t.Run(input, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		})
+	}
+
+	for _, input := range []string{"/", "/shelf", "/shelf/mybook", "/new", "/crawlable", "/calendar"} {
+		t.Run(input, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.NotContains(t, w.Body.String(), `rel="search"`)
+			assert.NotContains(t, w.Body.String(), "opensearch")
+		})
+	}
+}
+
+func TestHandlerSort(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for _, folder := range []string{"Zines", "archive"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, folder), 0o755))
+	}
+	for _, name := range []string{"a.epub", "B.epub", "c.epub"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("Fixture"), 0o644))
+	}
+
+	tests := map[string]struct {
+		sort string
+		want []string
+	}{
+		"title by default": {sort: "", want: []string{"Zines", "archive", "a.epub", "B.epub", "c.epub"}},
+		"title":            {sort: "title", want: []string{"Zines", "archive", "a.epub", "B.epub", "c.epub"}},
+		"name":             {sort: "name", want: []string{"Zines", "archive", "B.epub", "a.epub", "c.epub"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, Sort: tc.sort}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify the folders are first in directory order
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.want, entryTitles(t, w.Body.Bytes()))
+		})
+	}
+
+	t.Run("sorted by the title from the metadata", func(t *testing.T) {
+		dir := t.TempDir()
+		writeEPUB(t, filepath.Join(dir, "a.epub"), `<meta name="calibre:series_index" content="2"/>`)
+		writeEPUB(t, filepath.Join(dir, "b.epub"), `<meta name="calibre:series_index" content="1"/>`)
+		s := service.OPDS{TrustedRoot: dir, EpubMetadata: true, SeriesIndexTitles: true}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"1 - b.epub", "2 - a.epub"}, entryTitles(t, w.Body.Bytes()))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		assert.Error(t, service.OPDS{TrustedRoot: dir, Sort: "size"}.Validate())
+	})
+}
+
+func TestHandlerUnknownRoute(t *testing.T) {
+	for _, urlPath := range []string{"/favicon.ico", "/random", "/shelfx", "/newest", "/root/shelf"} {
+		t.Run(urlPath, func(t *testing.T) {
+			// setup
+			s := service.OPDS{TrustedRoot: "testdata"}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, urlPath, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusNotFound, w.Code)
+			assert.Equal(t, "application/atom+xml;profile=opds-catalog;kind=navigation", w.Header().Get("Content-Type"))
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), new(struct{})))
+			assert.Contains(t, w.Body.String(), "<title>Not found</title>")
+			assert.Contains(t, w.Body.String(), `<link rel="start" href="/" type="application/atom+xml;profile=opds-catalog;kind=navigation"></link>`)
+			assert.Contains(t, w.Body.String(), "The page "+urlPath+" does not exist.")
+		})
+	}
+
+	t.Run("custom handler", func(t *testing.T) {
+		// setup
+		s := service.OPDS{TrustedRoot: "testdata", NotFound: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, "nothing here", http.StatusNotFound)
+		})}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/random", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		require.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "nothing here\n", w.Body.String())
+	})
+}
+
+func TestHandlerMaxEntriesPerFeed(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "archive"), 0o755))
+	modTime := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range []string{"a.epub", "b.epub", "c.epub", "d.epub"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("Fixture"), 0o644))
+		// b and d are the newest
+		modTime := modTime.AddDate(0, 0, (i%2)*10+i)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, name), modTime, modTime))
+	}
+
+	tests := map[string]struct {
+		sort string
+		want []string
+	}{
+		"newest by date": {sort: "date", want: []string{"archive", "d.epub", "b.epub"}},
+		"first by title": {sort: "title", want: []string{"archive", "a.epub", "b.epub"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, Sort: tc.sort, MaxEntriesPerFeed: 2}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify the folders are not counted
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.want, entryTitles(t, w.Body.Bytes()))
+		})
+	}
+}
+
+func TestHandlerBookInTrustedRoot(t *testing.T) {
+	// setup a root that would be hidden if it was a folder of the catalog
+	dir := filepath.Join(t.TempDir(), ".books")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, ".hidden"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden", "secret.epub"), []byte("Fixture"), 0o644))
+	s := service.OPDS{TrustedRoot: dir, HideDotFiles: true, HideCalibreFiles: true}
+
+	for _, input := range []string{"/shelf", "/new", "/search?q=book", "/crawlable"} {
+		t.Run(input, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, entryTitles(t, w.Body.Bytes()), "mybook.epub")
+			assert.NotContains(t, w.Body.String(), "secret.epub")
+		})
+	}
+}
+
+func TestHandlerUppercaseExtensions(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "mybook"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook", "BOOK.EPUB"), []byte("Fixture"), 0o644))
+	writeJPEG(t, filepath.Join(dir, "mybook", "cover.JPG"), 10, 15)
+	s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf/mybook", nil)
+
+	// act
+	require.NoError(t, s.Handler(w, req))
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/acquisition" href="/shelf/mybook/BOOK.EPUB" type="application/epub+zip" title="BOOK.EPUB"></link>`)
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/image" href="/shelf/mybook/cover.JPG" type="image/jpeg"></link>`)
+	assert.Equal(t, []string{"BOOK.EPUB"}, entryTitles(t, w.Body.Bytes()))
+}
+
+func TestHandlerCustomRootOnly(t *testing.T) {
+	// setup
+	s := service.OPDS{
+		TrustedRoot:    "testdata",
+		CustomRootOnly: true,
+		NavEntries: []service.NavEntry{
+			{Title: "Magazines", Href: "/shelf/magazines", Rel: "subsection"},
+			{Title: "Newest", Href: "/new", Rel: "http://opds-spec.org/sort/new", Type: "application/atom+xml;profile=opds-catalog;kind=acquisition"},
+		},
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	// act
+	err := s.Handler(w, req)
+	require.NoError(t, err)
+
+	// verify
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"Magazines", "Newest"}, entryTitles(t, w.Body.Bytes()))
+	assert.NotContains(t, w.Body.String(), "<id>/shelf</id>")
+
+	// the built-in feeds are still served
+	for _, input := range []string{"/new", "/shelf"} {
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, input, nil)))
+		assert.Equal(t, http.StatusOK, w.Code, input)
+	}
+}
+
+func TestHandlerHiddenTopLevelFolder(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, ".hidden"), 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "visible"), 0o755))
+	writeEPUB(t, filepath.Join(dir, ".hidden", "hidden book.epub"), `<dc:title>Hidden</dc:title>`)
+	writeEPUB(t, filepath.Join(dir, "visible", "visible book.epub"), `<dc:title>Visible</dc:title>`)
+
+	tests := map[string]string{
+		"clean root":     dir,
+		"trailing slash": dir + "/",
+		"dot segment":    dir + "/.",
+		"parent segment": dir + "/visible/..",
+	}
+
+	for name, root := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: root, HideDotFiles: true}
+			for _, input := range []string{"/new", "/search?q=book"} {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, input, nil)
+
+				// act
+				require.NoError(t, s.Handler(w, req))
+
+				// verify
+				require.Equal(t, http.StatusOK, w.Code, input)
+				assert.Equal(t, []string{"visible book.epub"}, entryTitles(t, w.Body.Bytes()), input)
+			}
+		})
+	}
+}
+
+func TestHandlerInlinePreview(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "mybook"), 0o755))
+	for _, name := range []string{"mybook.epub", "mybook.pdf", "mybook.mobi", "map.png"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook", name), []byte("Fixture"), 0o644))
+	}
+	writeJPEG(t, filepath.Join(dir, "mybook", "cover.jpg"), 10, 15)
+
+	tests := map[string]struct {
+		inlinePreview bool
+		want          map[string]string
+	}{
+		"inline preview": {inlinePreview: true, want: map[string]string{
+			"mybook.epub": `attachment; filename="mybook.epub"`,
+			"mybook.mobi": `attachment; filename="mybook.mobi"`,
+			"mybook.pdf":  `inline; filename="mybook.pdf"`,
+			"map.png":     `inline; filename="map.png"`,
+			"cover.jpg":   `inline; filename="cover.jpg"`,
+		}},
+		"without inline preview": {inlinePreview: false, want: map[string]string{
+			"mybook.epub": `attachment; filename="mybook.epub"`,
+			"mybook.pdf":  `attachment; filename="mybook.pdf"`,
+			"map.png":     `attachment; filename="map.png"`,
+			"cover.jpg":   "",
+		}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true, InlinePreview: tc.inlinePreview}
+			for file, want := range tc.want {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, "/shelf/mybook/"+file, nil)
+
+				// act
+				require.NoError(t, s.Handler(w, req))
+
+				// verify
+				require.Equal(t, http.StatusOK, w.Code, file)
+				assert.Equal(t, want, w.Header().Get("Content-Disposition"), file)
+			}
+		})
+	}
+
+	// the cover is served once
+	w := httptest.NewRecorder()
+	require.NoError(t, service.OPDS{TrustedRoot: dir, UseCalibreCovers: true}.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf/mybook/cover.jpg", nil)))
+	cover, err := os.ReadFile(filepath.Join(dir, "mybook", "cover.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, cover, w.Body.Bytes())
+}
+
+func TestHandlerFolderNotes(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "classics"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "classics", "mybook.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "classics", "README"), []byte("  The books of the <reading> club\n"), 0o644))
+
+	tests := map[string]struct {
+		folderNotes  bool
+		wantSubtitle string
+		wantTitles   []string
+	}{
+		"folder notes":         {folderNotes: true, wantSubtitle: `<subtitle type="text">The books of the &lt;reading&gt; club</subtitle>`, wantTitles: []string{"mybook.epub"}},
+		"without folder notes": {folderNotes: false, wantTitles: []string{"mybook.epub", "README"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, FolderNotes: tc.folderNotes}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf/classics", nil)
+
+			// act
+			err := s.Handler(w, req)
+
+			// verify
+			require.NoError(t, err)
+			body := w.Body.String()
+			if tc.wantSubtitle != "" {
+				assert.Contains(t, body, tc.wantSubtitle)
+			} else {
+				assert.NotContains(t, body, "<subtitle")
+			}
+			assert.Equal(t, tc.wantTitles, entryTitles(t, w.Body.Bytes()))
+		})
+	}
+}
+
+func TestHandlerTrustedRootTrailingSlash(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "mybook"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook", "mybook.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook", "mybook.pdf"), []byte("Fixture"), 0o644))
+	want := map[string]string{}
+	for _, input := range []string{"/shelf/mybook", "/new", "/search?q=mybook"} {
+		w := httptest.NewRecorder()
+		require.NoError(t, service.OPDS{TrustedRoot: dir}.Handler(w, httptest.NewRequest(http.MethodGet, input, nil)))
+		want[input] = w.Body.String()
+	}
+
+	for _, root := range []string{dir + "/", dir + "//", dir + "/mybook/.."} {
+		t.Run(root, func(t *testing.T) {
+			s, err := service.NewOPDS(service.OPDS{TrustedRoot: root})
+			require.NoError(t, err)
+			for input, body := range want {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, input, nil)
+
+				// act
+				err := s.Handler(w, req)
+
+				// verify
+				require.NoError(t, err)
+				assert.Equal(t, http.StatusOK, w.Code, input)
+				assert.Equal(t, body, w.Body.String(), input)
+			}
+
+			w := httptest.NewRecorder()
+			require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf/mybook/mybook.epub", nil)))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "Fixture", w.Body.String())
+		})
+	}
+}
+
+func TestHandlerWalkSkipsUnreadableDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("the permissions of the directory can't deny reading it")
+	}
+
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "locked"), 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "open"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "locked", "hidden book.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "open", "mybook.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.Chmod(filepath.Join(dir, "locked"), 0o311))
+	t.Cleanup(func() { os.Chmod(filepath.Join(dir, "locked"), 0o755) })
+	s := service.OPDS{TrustedRoot: dir}
+
+	for _, input := range []string{"/new", "/search?q=book", "/suggest?q=my"} {
+		t.Run(input, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, input, nil)
+
+			// act
+			err := s.Handler(w, req)
+
+			// verify the books of the readable folders are listed
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), "mybook")
+			assert.NotContains(t, w.Body.String(), "hidden book")
+		})
+	}
+}
+
+func TestHandlerMinFileSize(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stub.epub"), []byte("0123456789"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook.epub"), bytes.Repeat([]byte("x"), 2048), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cover.jpg"), []byte("tiny cover"), 0o644))
+
+	tests := map[string]struct {
+		minFileSize    int64
+		wantTitles     []string
+		wantStubStatus int
+	}{
+		"1KB threshold": {minFileSize: 1024, wantTitles: []string{"cover.jpg", "mybook.epub"}, wantStubStatus: http.StatusNotFound},
+		"no threshold":  {minFileSize: 0, wantTitles: []string{"cover.jpg", "mybook.epub", "stub.epub"}, wantStubStatus: http.StatusOK},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, MinFileSize: tc.minFileSize}
+
+			// act
+			feed := httptest.NewRecorder()
+			require.NoError(t, s.Handler(feed, httptest.NewRequest(http.MethodGet, "/shelf", nil)))
+			stub := httptest.NewRecorder()
+			require.NoError(t, s.Handler(stub, httptest.NewRequest(http.MethodGet, "/shelf/stub.epub", nil)))
+			cover := httptest.NewRecorder()
+			require.NoError(t, s.Handler(cover, httptest.NewRequest(http.MethodGet, "/shelf/cover.jpg", nil)))
+
+			// verify
+			require.Equal(t, http.StatusOK, feed.Code)
+			assert.Equal(t, tc.wantTitles, entryTitles(t, feed.Body.Bytes()))
+			assert.Equal(t, tc.wantStubStatus, stub.Code)
+			assert.Equal(t, http.StatusOK, cover.Code, "covers are never too small")
+		})
+	}
+}
+
+func TestHandlerMinFileSizeBookResources(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	writeJPEG(t, filepath.Join(dir, "cover.jpg"), 60, 90)
+	cover, err := os.ReadFile(filepath.Join(dir, "cover.jpg"))
+	require.NoError(t, err)
+	f, err := os.Create(filepath.Join(dir, "stub.epub"))
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for name, content := range map[string][]byte{
+		"META-INF/container.xml": []byte(`<container><rootfiles><rootfile full-path="content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`),
+		"content.opf":            []byte(`<package><manifest><item id="front" href="front.jpg" media-type="image/jpeg" properties="cover-image"/></manifest></package>`),
+		"front.jpg":              cover,
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+	// the epubs with their own cover are not rebuilt with the cover next to them
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "bare"), 0o755))
+	writeEPUB(t, filepath.Join(dir, "bare", "stub.epub"), `<dc:title>Stub</dc:title>`)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bare", "cover.jpg"), cover, 0o644))
+
+	for _, input := range []string{"/withcover/bare/stub.epub", "/manifest/stub.epub", "/cover/stub.epub", "/thumbnail/stub.epub"} {
+		fi, err := os.Stat(filepath.Join(dir, strings.SplitN(input, "/", 3)[2]))
+		require.NoError(t, err)
+
+		tests := map[string]struct {
+			minFileSize int64
+			wantStatus  int
+		}{
+			"above the threshold": {minFileSize: fi.Size(), wantStatus: http.StatusOK},
+			"below the threshold": {minFileSize: fi.Size() + 1, wantStatus: http.StatusNotFound},
+		}
+
+		for name, tc := range tests {
+			t.Run(input+" "+name, func(t *testing.T) {
+				s := service.OPDS{TrustedRoot: dir, MinFileSize: tc.minFileSize, UseCalibreCovers: true, EmbedCovers: true, EpubMetadata: true, Thumbnails: true, WebpubManifests: true}
+				w := httptest.NewRecorder()
+
+				// act
+				require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, input, nil)))
+
+				// verify
+				assert.Equal(t, tc.wantStatus, w.Code)
+			})
+		}
+	}
+}
+
+func TestHandlerFolderInTitles(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	modTime := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, name := range []string{"Tolkien/The Hobbit.epub", "Austen/The Hobbit.epub", "notes.txt"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("Fixture"), 0o644))
+		require.NoError(t, os.Chtimes(filepath.Join(dir, name), modTime.Add(time.Duration(-i)*time.Hour), modTime.Add(time.Duration(-i)*time.Hour)))
+	}
+
+	tests := map[string]struct {
+		folderInTitles string
+		input          string
+		want           []string
+	}{
+		"prefix newest": {folderInTitles: "prefix", input: "/new", want: []string{"Tolkien — The Hobbit.epub", "Austen — The Hobbit.epub", "notes.txt"}},
+		"suffix newest": {folderInTitles: "suffix", input: "/new", want: []string{"The Hobbit.epub — Tolkien", "The Hobbit.epub — Austen", "notes.txt"}},
+		"prefix search": {folderInTitles: "prefix", input: "/search?q=hobbit", want: []string{"Austen — The Hobbit.epub", "Tolkien — The Hobbit.epub"}},
+		"title search":  {folderInTitles: "prefix", input: "/search?q=title:tolkien", want: []string{}},
+		"disabled":      {input: "/new", want: []string{"The Hobbit.epub", "The Hobbit.epub", "notes.txt"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := service.OPDS{TrustedRoot: dir, FolderInTitles: tc.folderInTitles}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.want, entryTitles(t, w.Body.Bytes()))
+		})
+	}
+
+	t.Run("validate", func(t *testing.T) {
+		err := service.OPDS{TrustedRoot: dir, AllowUnsafeRoot: true, FolderInTitles: "middle"}.Validate()
+		assert.EqualError(t, err, `folder in titles "middle" must be "prefix" or "suffix"`)
+	})
+}
+
+func TestHandlerPrefix(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "Archive"), 0o755))
+	for _, name := range []string{"alice.epub", "Animal Farm.pdf", "Brave New World.epub", "émile.epub"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("Fixture"), 0o644))
+	}
+	s := service.OPDS{TrustedRoot: dir}
+
+	tests := map[string]struct {
+		input string
+		want  []string
+	}{
+		"letter":          {input: "/shelf?prefix=a", want: []string{"Archive", "alice.epub", "Animal Farm.pdf"}},
+		"word":            {input: "/shelf?prefix=ANIMAL%20f", want: []string{"Animal Farm.pdf"}},
+		"accented letter": {input: "/shelf?prefix=%C3%89", want: []string{"émile.epub"}},
+		"no match":        {input: "/shelf?prefix=z", want: []string{}},
+		"every entry":     {input: "/shelf", want: []string{"Archive", "alice.epub", "Animal Farm.pdf", "Brave New World.epub", "émile.epub"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.want, entryTitles(t, w.Body.Bytes()))
+		})
+	}
+}
+
 var root = `<?xml version="1.0" encoding="UTF-8"?>
   <feed xmlns="http://www.w3.org/2005/Atom">
       <title>Home</title>
       <id>/</id>
       <link rel="start" href="/" type="application/atom+xml;profile=opds-catalog;kind=navigation"></link>
       <link rel="search" href="/opensearch.xml" type="application/opensearchdescription+xml"></link>
+      <link rel="http://opds-spec.org/crawlable" href="/crawlable" type="application/atom+xml;profile=opds-catalog;kind=acquisition"></link>
       <updated>2020-05-25T00:00:00+00:00</updated>
       <entry>
           <title>Newest books</title>
@@ -89,7 +1983,7 @@ var root = `<?xml version="1.0" encoding="UTF-8"?>
       <entry>
           <title>All books</title>
           <id>/shelf</id>
-          <link rel="http://opds-spec.org/subsection" href="/shelf" type="application/atom+xml;profile=opds-catalog;kind=acquisition"></link>
+          <link rel="subsection" href="/shelf" type="application/atom+xml;profile=opds-catalog;kind=acquisition"></link>
           <published></published>
           <updated></updated>
           <content type="text">All books.</content>
@@ -97,7 +1991,7 @@ var root = `<?xml version="1.0" encoding="UTF-8"?>
   </feed>`
 
 var newest = `<?xml version="1.0" encoding="UTF-8"?>
-  <feed xmlns="http://www.w3.org/2005/Atom">
+  <feed xmlns="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/terms/" xmlns:opds="http://opds-spec.org/2010/catalog">
       <title>Newest books</title>
       <id>/new</id>
       <link rel="start" href="/" type="application/atom+xml;profile=opds-catalog;kind=navigation"></link>
@@ -106,57 +2000,58 @@ var newest = `<?xml version="1.0" encoding="UTF-8"?>
       <entry>
           <title>mybook.epub</title>
           <id>/shelf/with cover/mybook.epub</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/with%20cover%2Fmybook.epub" type="application/epub+zip" title="mybook.epub"></link>
-          <link rel="http://opds-spec.org/image" href="/shelf/with%20cover%2Fcover.jpg" type="image/jpeg"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/with%20cover/mybook.epub" type="application/epub+zip" title="mybook.epub"></link>
+          <link rel="http://opds-spec.org/image" href="/shelf/with%20cover/cover.jpg" type="image/jpeg"></link>
+          <link rel="http://opds-spec.org/image/thumbnail" href="/shelf/with%20cover/cover.jpg" type="image/jpeg"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>nomatch.txt</title>
           <id>/shelf/nomatch/nomatch.txt</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/nomatch%2Fnomatch.txt" type="text/plain; charset=utf-8" title="nomatch.txt"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/nomatch/nomatch.txt" type="text/plain; charset=utf-8" title="nomatch.txt"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>mybook copy.epub</title>
           <id>/shelf/mybook/mybook copy.epub</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook%2Fmybook%20copy.epub" type="application/epub+zip" title="mybook copy.epub"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook/mybook%20copy.epub" type="application/epub+zip" title="mybook copy.epub"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>mybook copy.txt</title>
           <id>/shelf/mybook/mybook copy.txt</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook%2Fmybook%20copy.txt" type="text/plain; charset=utf-8" title="mybook copy.txt"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook/mybook%20copy.txt" type="text/plain; charset=utf-8" title="mybook copy.txt"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>mybook.txt</title>
           <id>/shelf/new folder/mybook.txt</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/new%20folder%2Fmybook.txt" type="text/plain; charset=utf-8" title="mybook.txt"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/new%20folder/mybook.txt" type="text/plain; charset=utf-8" title="mybook.txt"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>mybook.epub</title>
           <id>/shelf/mybook/mybook.epub</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook%2Fmybook.epub" type="application/epub+zip" title="mybook.epub"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook/mybook.epub" type="application/epub+zip" title="mybook.epub"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>mybook.pdf</title>
           <id>/shelf/mybook/mybook.pdf</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook%2Fmybook.pdf" type="application/pdf" title="mybook.pdf"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook/mybook.pdf" type="application/pdf" title="mybook.pdf"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>mybook.txt</title>
           <id>/shelf/mybook/mybook.txt</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook%2Fmybook.txt" type="text/plain; charset=utf-8" title="mybook.txt"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook/mybook.txt" type="text/plain; charset=utf-8" title="mybook.txt"></link>
           <published></published>
           <updated></updated>
       </entry>
@@ -252,9 +2147,13 @@ var acquisitionFeed = `<?xml version="1.0" encoding="UTF-8"?>
 
 var searchDefinition = `<?xml version="1.0" encoding="UTF-8"?>
   <OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">
+      <ShortName>dir2opds</ShortName>
+      <Description>Search the books of dir2opds</Description>
+      <!-- Prefix a term with author: or title: to match that field of the books, e.g. author:tolkien title:"the hobbit". -->
       <InputEncoding>UTF-8</InputEncoding>
       <OutputEncoding>UTF-8</OutputEncoding>
       <Url type="application/atom+xml;profile=opds-catalog;kind=acquisition" template="/search?q={searchTerms}"></Url>
+      <Url type="application/x-suggestions+json" template="/suggest?q={searchTerms}"></Url>
   </OpenSearchDescription>`
 
 var searchResult = `<?xml version="1.0" encoding="UTF-8"?>
@@ -267,50 +2166,51 @@ var searchResult = `<?xml version="1.0" encoding="UTF-8"?>
       <entry>
           <title>mybook copy.epub</title>
           <id>/shelf/mybook/mybook copy.epub</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook%2Fmybook%20copy.epub" type="application/epub+zip"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook/mybook%20copy.epub" type="application/epub+zip"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>mybook copy.txt</title>
           <id>/shelf/mybook/mybook copy.txt</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook%2Fmybook%20copy.txt" type="text/plain; charset=utf-8"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook/mybook%20copy.txt" type="text/plain; charset=utf-8"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>mybook.epub</title>
           <id>/shelf/mybook/mybook.epub</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook%2Fmybook.epub" type="application/epub+zip"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook/mybook.epub" type="application/epub+zip"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>mybook.pdf</title>
           <id>/shelf/mybook/mybook.pdf</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook%2Fmybook.pdf" type="application/pdf"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook/mybook.pdf" type="application/pdf"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>mybook.txt</title>
           <id>/shelf/mybook/mybook.txt</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook%2Fmybook.txt" type="text/plain; charset=utf-8"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/mybook/mybook.txt" type="text/plain; charset=utf-8"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>mybook.txt</title>
           <id>/shelf/new folder/mybook.txt</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/new%20folder%2Fmybook.txt" type="text/plain; charset=utf-8"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/new%20folder/mybook.txt" type="text/plain; charset=utf-8"></link>
           <published></published>
           <updated></updated>
       </entry>
       <entry>
           <title>mybook.epub</title>
           <id>/shelf/with cover/mybook.epub</id>
-          <link rel="http://opds-spec.org/acquisition" href="/shelf/with%20cover%2Fmybook.epub" type="application/epub+zip"></link>
-          <link rel="http://opds-spec.org/image" href="/shelf/with%20cover%2Fcover.jpg" type="image/jpeg"></link>
+          <link rel="http://opds-spec.org/acquisition" href="/shelf/with%20cover/mybook.epub" type="application/epub+zip"></link>
+          <link rel="http://opds-spec.org/image" href="/shelf/with%20cover/cover.jpg" type="image/jpeg"></link>
+          <link rel="http://opds-spec.org/image/thumbnail" href="/shelf/with%20cover/cover.jpg" type="image/jpeg"></link>
           <published></published>
           <updated></updated>
       </entry>
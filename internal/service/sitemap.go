@@ -0,0 +1,119 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+const sitemapPath = "/sitemap.xml"
+
+// sitemapMaxURLs is the maximum number of urls of a sitemap, a catalog with more feeds is
+// split in pages listed by a sitemap index, see https://www.sitemaps.org/protocol.html
+const sitemapMaxURLs = 50000
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapLoc `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// serveSitemap serves the urls of the feeds of the catalog: the root, its sections and every folder.
+// Over sitemapMaxURLs urls /sitemap.xml is an index of its pages, /sitemap.xml?page=1 and on.
+func (s OPDS) serveSitemap(w http.ResponseWriter, req *http.Request) error {
+	urls := s.sitemapURLs(req)
+	pages := (len(urls) + sitemapMaxURLs - 1) / sitemapMaxURLs
+
+	var sitemap any
+	switch p := req.URL.Query().Get("page"); {
+	case p == "" && pages <= 1:
+		sitemap = sitemapURLSet{URLs: sitemapLocs(urls)}
+	case p == "":
+		index := sitemapIndex{}
+		for page := 1; page <= pages; page++ {
+			index.Sitemaps = append(index.Sitemaps, sitemapLoc{Loc: absoluteURL(req, s.href(fmt.Sprintf("%s?page=%d", sitemapPath, page)))})
+		}
+		sitemap = index
+	default:
+		page, err := strconv.Atoi(p)
+		if err != nil || page < 1 || page > pages {
+			w.WriteHeader(http.StatusNotFound)
+			return nil
+		}
+		start := (page - 1) * sitemapMaxURLs
+		sitemap = sitemapURLSet{URLs: sitemapLocs(urls[start:min(start+sitemapMaxURLs, len(urls))])}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if !s.CompactOutput {
+		enc.Indent("", "  ")
+	}
+	if err := enc.Encode(sitemap); err != nil {
+		return err
+	}
+
+	w.Header().Add("Content-Type", "application/xml")
+	http.ServeContent(w, req, "sitemap.xml", TimeNow(), bytes.NewReader(buf.Bytes()))
+	return nil
+}
+
+// sitemapURLs returns the absolute urls of the feeds of the catalog: the root, the feeds of
+// its sections and the feed of every folder listed for req
+func (s OPDS) sitemapURLs(req *http.Request) []string {
+	hrefs := []string{"/", "/shelf"}
+	for _, section := range s.rootSections() {
+		if href := s.rootSectionEntry(req, section).ID; href != "/shelf" {
+			hrefs = append(hrefs, href)
+		}
+	}
+
+	filepath.WalkDir(s.TrustedRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return walkError(path, d, err)
+		}
+		if err := req.Context().Err(); err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		pathRelativeToContentRoot := s.relativePath(path)
+		if pathRelativeToContentRoot == "" {
+			return nil
+		}
+		if s.fileShouldBeIgnored(pathRelativeToContentRoot) || !s.authorized(req, pathRelativeToContentRoot) {
+			return filepath.SkipDir
+		}
+		hrefs = append(hrefs, "/shelf/"+escapePath(pathRelativeToContentRoot))
+		return nil
+	})
+
+	urls := make([]string, 0, len(hrefs))
+	for _, href := range hrefs {
+		urls = append(urls, absoluteURL(req, s.href(href)))
+	}
+	return urls
+}
+
+func sitemapLocs(urls []string) []sitemapLoc {
+	locs := make([]sitemapLoc, 0, len(urls))
+	for _, u := range urls {
+		locs = append(locs, sitemapLoc{Loc: u})
+	}
+	return locs
+}
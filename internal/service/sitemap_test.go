@@ -0,0 +1,101 @@
+package service_test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerSitemap(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	for _, folder := range []string{"fiction/classics", "my folder", ".hidden/secret"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, folder), 0o755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fiction", "mybook.epub"), []byte("Fixture"), 0o644))
+
+	tests := map[string]struct {
+		s    service.OPDS
+		want []string
+	}{
+		"feeds": {
+			s: service.OPDS{TrustedRoot: dir, HideDotFiles: true},
+			want: []string{
+				"http://example.com/",
+				"http://example.com/shelf",
+				"http://example.com/new",
+				"http://example.com/shelf/fiction",
+				"http://example.com/shelf/fiction/classics",
+				"http://example.com/shelf/my%20folder",
+			},
+		},
+		"sections and base path": {
+			s: service.OPDS{TrustedRoot: dir, HideDotFiles: true, BasePath: "/opds", RootSections: []string{"authors", "new"}},
+			want: []string{
+				"http://example.com/opds/",
+				"http://example.com/opds/shelf",
+				"http://example.com/opds/authors",
+				"http://example.com/opds/new",
+				"http://example.com/opds/shelf/fiction",
+				"http://example.com/opds/shelf/fiction/classics",
+				"http://example.com/opds/shelf/my%20folder",
+			},
+		},
+		"authorized folders": {
+			s: service.OPDS{TrustedRoot: dir, HideDotFiles: true, Authorize: func(user, path string) bool { return path != "fiction" }},
+			want: []string{
+				"http://example.com/",
+				"http://example.com/shelf",
+				"http://example.com/new",
+				"http://example.com/shelf/my%20folder",
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.s.BasePath+"/sitemap.xml", nil)
+
+			// act
+			err := tc.s.Handler(w, req)
+
+			// verify
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "application/xml", w.Header().Get("Content-Type"))
+
+			var sitemap struct {
+				XMLName xml.Name
+				URLs    []struct {
+					Loc string `xml:"loc"`
+				} `xml:"url"`
+			}
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &sitemap))
+			assert.Equal(t, xml.Name{Space: "http://www.sitemaps.org/schemas/sitemap/0.9", Local: "urlset"}, sitemap.XMLName)
+			var got []string
+			for _, u := range sitemap.URLs {
+				got = append(got, u.Loc)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	t.Run("missing page", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		// act
+		err := service.OPDS{TrustedRoot: dir}.Handler(w, httptest.NewRequest(http.MethodGet, "/sitemap.xml?page=2", nil))
+
+		// verify
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const suggestPath = "/suggest"
+
+const suggestionsType = "application/x-suggestions+json"
+
+// maxSuggestions caps the number of titles returned by /suggest
+const maxSuggestions = 10
+
+// serveSuggestions serves the titles starting with the query param q in the OpenSearch suggestions format:
+//
+//	["hob", ["hobbit", "hobbit illustrated"]]
+func (s OPDS) serveSuggestions(w http.ResponseWriter, req *http.Request) error {
+	query := req.URL.Query().Get("q")
+
+	suggestions := []string{}
+	if query != "" {
+		suggestions = s.suggestTitles(req, query)
+	}
+
+	content, err := json.Marshal([]any{query, suggestions})
+	if err != nil {
+		return err
+	}
+	w.Header().Add("Content-Type", suggestionsType)
+	http.ServeContent(w, req, "suggestions.json", TimeNow(), bytes.NewReader(content))
+	return nil
+}
+
+// suggestTitles returns the sorted names without extension of the books starting with prefix, ignoring case
+func (s OPDS) suggestTitles(req *http.Request, prefix string) []string {
+	prefix = strings.ToLower(prefix)
+	found := map[string]bool{}
+
+	filepath.WalkDir(s.TrustedRoot, func(path string, file fs.DirEntry, err error) error {
+		if err != nil {
+			return walkError(path, file, err)
+		}
+		if err := req.Context().Err(); err != nil {
+			return err
+		}
+
+		pathRelativeToContentRoot := s.relativePath(path)
+		if s.fileShouldBeIgnored(pathRelativeToContentRoot) || !s.authorized(req, pathRelativeToContentRoot) {
+			if file.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if file.IsDir() || isCover(file.Name()) || !s.included(file.Name()) || s.tooSmall(path) {
+			return nil
+		}
+
+		title := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+		if strings.HasPrefix(strings.ToLower(title), prefix) {
+			found[title] = true
+		}
+		return nil
+	})
+
+	titles := make([]string, 0, len(found))
+	for title := range found {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	if len(titles) > maxSuggestions {
+		titles = titles[:maxSuggestions]
+	}
+	return titles
+}
@@ -0,0 +1,65 @@
+package service_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerSuggestions(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "Tolkien", ".hidden"), 0o755))
+	for _, name := range []string{"Tolkien/The Hobbit.epub", "Tolkien/The Hobbit.pdf", "Tolkien/the hollow men.epub", "Tolkien/.hidden/The Hidden.epub", "Tolkien/cover.jpg", "Dune.epub"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("Fixture"), 0o644))
+	}
+	for i := 0; i < 12; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("series %02d.epub", i)), []byte("Fixture"), 0o644))
+	}
+	s := service.OPDS{TrustedRoot: dir, HideDotFiles: true, HideCalibreFiles: true}
+
+	tests := map[string]struct {
+		query string
+		want  []string
+	}{
+		"prefix ignoring case":    {query: "THE HO", want: []string{"The Hobbit", "the hollow men"}},
+		"no match":                {query: "zzz", want: []string{}},
+		"empty query":             {query: "", want: []string{}},
+		"capped number of titles": {query: "series", want: []string{"series 00", "series 01", "series 02", "series 03", "series 04", "series 05", "series 06", "series 07", "series 08", "series 09"}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/suggest?q="+url.QueryEscape(tc.query), nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify the OpenSearch suggestions format: [query, [titles]]
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "application/x-suggestions+json", w.Header().Get("Content-Type"))
+
+			var got []json.RawMessage
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			require.Len(t, got, 2)
+
+			var query string
+			require.NoError(t, json.Unmarshal(got[0], &query))
+			assert.Equal(t, tc.query, query)
+
+			var titles []string
+			require.NoError(t, json.Unmarshal(got[1], &titles))
+			assert.Equal(t, tc.want, titles)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dubyte/dir2opds/opds"
+)
+
+// addCategories adds a category to the entry of a book for each of its tags, see bookTags
+func (s OPDS) addCategories(filePath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	for _, tag := range s.bookTags(filePath) {
+		builder = builder.AddCategory(opds.Category{Term: tag, Label: tag})
+	}
+	return builder
+}
+
+// bookTags returns the tags of a book: the dc:subject of its epub metadata, like the tags of
+// calibre, followed by the tags of the metadata sidecar of its folder. A tag is listed once
+// whatever its case.
+func (s OPDS) bookTags(filePath string) []string {
+	var tags []string
+	add := func(tag string) {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.ContainsFunc(tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			tags = append(tags, tag)
+		}
+	}
+
+	if meta, ok := s.readEpubMetadata(filePath); ok {
+		for _, subject := range meta.Subjects {
+			add(subject)
+		}
+	}
+	if s.SidecarMetadata {
+		if meta, ok := readSidecar(filepath.Dir(filePath)); ok {
+			for _, tag := range meta.Tags {
+				add(tag)
+			}
+		}
+	}
+	return tags
+}
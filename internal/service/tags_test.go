@@ -0,0 +1,69 @@
+package service_test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerCategories(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	writeEPUB(t, filepath.Join(dir, "hobbit.epub"), `<dc:subject>Fantasy</dc:subject><dc:subject>Classics</dc:subject>`)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "metadata.json"), []byte(`{"tags": ["classics", "Adventure"]}`), 0o644))
+
+	type category struct {
+		Term  string `xml:"term,attr"`
+		Label string `xml:"label,attr"`
+	}
+	tests := map[string]struct {
+		s    service.OPDS
+		want []category
+	}{
+		"epub subjects": {
+			s:    service.OPDS{TrustedRoot: dir, EpubMetadata: true},
+			want: []category{{Term: "Fantasy", Label: "Fantasy"}, {Term: "Classics", Label: "Classics"}},
+		},
+		"epub subjects and sidecar tags": {
+			s:    service.OPDS{TrustedRoot: dir, EpubMetadata: true, SidecarMetadata: true},
+			want: []category{{Term: "Fantasy", Label: "Fantasy"}, {Term: "Classics", Label: "Classics"}, {Term: "Adventure", Label: "Adventure"}},
+		},
+		"without metadata": {
+			s: service.OPDS{TrustedRoot: dir},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+			// act
+			require.NoError(t, tc.s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			var feed struct {
+				Entry []struct {
+					Title    string     `xml:"title"`
+					Category []category `xml:"category"`
+				} `xml:"entry"`
+			}
+			require.NoError(t, xml.Unmarshal(w.Body.Bytes(), &feed))
+			var got []category
+			for _, entry := range feed.Entry {
+				if entry.Title == "hobbit.epub" {
+					got = entry.Category
+				}
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
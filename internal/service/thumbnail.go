@@ -0,0 +1,231 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif" // register gif decoder for covers
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const thumbnailPathPrefix = "/thumbnail/"
+
+// thumbnailsDir holds pre-generated thumbnails as .thumbnails/<path of the book>.jpg
+const thumbnailsDir = ".thumbnails"
+
+const (
+	thumbnailMaxWidth  = 200
+	thumbnailMaxHeight = 300
+)
+
+const defaultThumbnailFormat = "image/jpeg"
+
+// ThumbnailEncoder writes img to w in a given image format.
+type ThumbnailEncoder func(w io.Writer, img image.Image) error
+
+var (
+	thumbnailEncodersMu sync.RWMutex
+	thumbnailEncoders   = map[string]ThumbnailEncoder{
+		"image/jpeg": func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+		},
+		"image/png": png.Encode,
+	}
+)
+
+// RegisterThumbnailEncoder makes a thumbnail format available for ThumbnailFormat.
+// The standard library only provides jpeg and png, formats like image/webp or
+// image/avif have to be registered by the program using an external encoder.
+func RegisterThumbnailEncoder(mimeType string, encode ThumbnailEncoder) {
+	thumbnailEncodersMu.Lock()
+	defer thumbnailEncodersMu.Unlock()
+	thumbnailEncoders[mimeType] = encode
+}
+
+func thumbnailEncoder(mimeType string) (ThumbnailEncoder, bool) {
+	thumbnailEncodersMu.RLock()
+	defer thumbnailEncodersMu.RUnlock()
+	encode, ok := thumbnailEncoders[mimeType]
+	return encode, ok
+}
+
+// thumbnailType negotiates the format of the thumbnails for a request,
+// ThumbnailFormat is used only when the client explicitly accepts it, otherwise jpeg.
+func (s OPDS) thumbnailType(req *http.Request) string {
+	if s.ThumbnailFormat == "" || s.ThumbnailFormat == defaultThumbnailFormat {
+		return defaultThumbnailFormat
+	}
+
+	if _, ok := thumbnailEncoder(s.ThumbnailFormat); !ok {
+		return defaultThumbnailFormat
+	}
+
+	for _, accepted := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accepted, ";")
+		if strings.TrimSpace(mediaType) == s.ThumbnailFormat {
+			return s.ThumbnailFormat
+		}
+	}
+
+	return defaultThumbnailFormat
+}
+
+// isThumbnailsDir reports if the path is the pre-generated thumbnails folder or is inside it
+func isThumbnailsDir(path string) bool {
+	for _, name := range strings.Split(filepath.ToSlash(path), "/") {
+		if name == thumbnailsDir {
+			return true
+		}
+	}
+	return false
+}
+
+// pregeneratedThumbnail returns the path of the pre-generated thumbnail of a book or "" when there is none
+func (s OPDS) pregeneratedThumbnail(bookPath string) string {
+	_, pathRelativeToContentRoot, found := strings.Cut(bookPath, s.TrustedRoot+"/")
+	if !found {
+		return ""
+	}
+
+	thumbnailPath := filepath.Join(s.TrustedRoot, thumbnailsDir, pathRelativeToContentRoot+".jpg")
+	if fi, err := os.Stat(thumbnailPath); err != nil || fi.IsDir() {
+		return ""
+	}
+	return thumbnailPath
+}
+
+// serveThumbnail serves the pre-generated thumbnail of the book in urlPath
+// or a resized version of its cover
+func (s OPDS) serveThumbnail(w http.ResponseWriter, req *http.Request, urlPath string) error {
+	bookPath := filepath.Join(s.TrustedRoot, strings.TrimPrefix(urlPath, thumbnailPathPrefix))
+
+	bookPath, err := verifyPath(bookPath, s.TrustedRoot)
+	if err != nil {
+		log.Printf("thumbnail %q err: %s", bookPath, err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	_, pathRelativeToContentRoot, _ := strings.Cut(bookPath, s.TrustedRoot+"/")
+	if s.fileShouldBeIgnored(pathRelativeToContentRoot) || s.tooSmall(bookPath) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	if s.accessDenied(w, req, pathRelativeToContentRoot) {
+		return nil
+	}
+
+	if thumbnailPath := s.pregeneratedThumbnail(bookPath); thumbnailPath != "" {
+		// the resized covers served in its place, once it is removed, follow the Accept header
+		w.Header().Add("Vary", "Accept")
+		return serveCover(w, req, cover{mimeType: "image/jpeg", localPath: thumbnailPath})
+	}
+
+	if !s.Thumbnails {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	c, ok := s.resolveCover(bookPath)
+	if !ok || !c.local() {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	thumbType := s.thumbnailType(req)
+
+	// the thumbnails kept in the CacheDir are named after the image of their cover
+	var key string
+	var thumbnail []byte
+	if s.CacheDir != "" {
+		if sum, err := s.coverChecksum(c); err == nil {
+			key = artifactKey(sum, thumbType, thumbnailMaxWidth, thumbnailMaxHeight)
+			thumbnail, _ = s.readArtifact(thumbnailArtifacts, key)
+		}
+	}
+
+	if thumbnail == nil {
+		f, err := c.open()
+		if err != nil {
+			log.Printf("thumbnail cover of %q err: %s", bookPath, err)
+			w.WriteHeader(http.StatusNotFound)
+			return nil
+		}
+		thumbnail, err = makeThumbnail(f, thumbType)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("thumbnail of %s: %w", bookPath, err)
+		}
+		if key != "" {
+			s.writeArtifact(thumbnailArtifacts, key, thumbnail)
+		}
+	}
+
+	w.Header().Add("Content-Type", thumbType)
+	w.Header().Add("Vary", "Accept")
+	http.ServeContent(w, req, "", TimeNow(), bytes.NewReader(thumbnail))
+	return nil
+}
+
+// makeThumbnail resizes the image of a cover and encodes it in thumbType
+func makeThumbnail(r io.Reader, thumbType string) ([]byte, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("decode cover: %w", err)
+	}
+
+	encode, _ := thumbnailEncoder(thumbType)
+
+	var buf bytes.Buffer
+	if err := encode(&buf, resize(img, thumbnailMaxWidth, thumbnailMaxHeight)); err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// resize scales img down to fit in maxWidth x maxHeight keeping its aspect ratio,
+// each pixel is the average of the source pixels it covers.
+func resize(img image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW <= maxWidth && srcH <= maxHeight {
+		return img
+	}
+
+	dstW, dstH := maxWidth, srcH*maxWidth/srcW
+	if dstH > maxHeight {
+		dstW, dstH = srcW*maxHeight/srcH, maxHeight
+	}
+	dstW, dstH = max(dstW, 1), max(dstH, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := bounds.Min.Y+y*srcH/dstH, bounds.Min.Y+max((y+1)*srcH/dstH, y*srcH/dstH+1)
+		for x := 0; x < dstW; x++ {
+			x0, x1 := bounds.Min.X+x*srcW/dstW, bounds.Min.X+max((x+1)*srcW/dstW, x*srcW/dstW+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
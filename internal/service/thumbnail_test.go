@@ -0,0 +1,240 @@
+package service_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerThumbnail(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "mybook"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook", "mybook.epub"), []byte("Fixture"), 0o644))
+	writeJPEG(t, filepath.Join(dir, "mybook", "cover.jpg"), 600, 900)
+
+	// a fake encoder, the standard library can't encode webp
+	service.RegisterThumbnailEncoder("image/webp", func(w io.Writer, img image.Image) error {
+		_, err := w.Write([]byte("RIFF....WEBP"))
+		return err
+	})
+
+	s := service.OPDS{TrustedRoot: dir, HideCalibreFiles: true, UseCalibreCovers: true, Thumbnails: true, ThumbnailFormat: "image/webp"}
+
+	tests := map[string]struct {
+		accept   string
+		wantType string
+	}{
+		"client does not accept webp": {accept: "image/png,image/jpeg;q=0.8,*/*;q=0.5", wantType: "image/jpeg"},
+		"client without accept":       {accept: "", wantType: "image/jpeg"},
+		"client accepts webp":         {accept: "image/avif,image/webp,*/*", wantType: "image/webp"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// act
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf/mybook", nil)
+			req.Header.Set("Accept", tc.accept)
+			require.NoError(t, s.Handler(w, req))
+
+			// verify the link type matches what is served
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/image/thumbnail" href="/thumbnail/mybook/mybook.epub" type="`+tc.wantType+`"></link>`)
+
+			w = httptest.NewRecorder()
+			req = httptest.NewRequest(http.MethodGet, "/thumbnail/mybook/mybook.epub", nil)
+			req.Header.Set("Accept", tc.accept)
+			require.NoError(t, s.Handler(w, req))
+
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.wantType, w.Header().Get("Content-Type"))
+			if tc.wantType == "image/jpeg" {
+				img, err := jpeg.Decode(w.Body)
+				require.NoError(t, err)
+				assert.Equal(t, image.Rect(0, 0, 200, 300), img.Bounds())
+			}
+		})
+	}
+}
+
+func TestHandlerThumbnailDisabled(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook.epub"), []byte("Fixture"), 0o644))
+	writeJPEG(t, filepath.Join(dir, "cover.jpg"), 60, 90)
+	s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/thumbnail/mybook.epub", nil)
+
+	// act
+	err := s.Handler(w, req)
+	require.NoError(t, err)
+
+	// verify
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandlerCoverWithoutThumbnails(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook.epub"), []byte("Fixture"), 0o644))
+	writeJPEG(t, filepath.Join(dir, "cover.jpg"), 60, 90)
+	s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+	// act
+	require.NoError(t, s.Handler(w, req))
+
+	// verify the full cover is the thumbnail too
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/image" href="/shelf/cover.jpg" type="image/jpeg"></link>`)
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/image/thumbnail" href="/shelf/cover.jpg" type="image/jpeg"></link>`)
+}
+
+func TestHandlerPregeneratedThumbnail(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "mybook"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".thumbnails", "mybook"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook", "mybook.epub"), []byte("Fixture"), 0o644))
+	writeJPEG(t, filepath.Join(dir, "mybook", "cover.jpg"), 600, 900)
+	writeJPEG(t, filepath.Join(dir, ".thumbnails", "mybook", "mybook.epub.jpg"), 20, 30)
+	pregenerated, err := os.ReadFile(filepath.Join(dir, ".thumbnails", "mybook", "mybook.epub.jpg"))
+	require.NoError(t, err)
+
+	for name, s := range map[string]service.OPDS{
+		"without thumbnails": {TrustedRoot: dir, HideCalibreFiles: true, UseCalibreCovers: true},
+		"with thumbnails":    {TrustedRoot: dir, HideCalibreFiles: true, UseCalibreCovers: true, Thumbnails: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// act
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/shelf/mybook", nil)
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/image/thumbnail" href="/thumbnail/mybook/mybook.epub" type="image/jpeg"></link>`)
+
+			// act
+			w = httptest.NewRecorder()
+			req = httptest.NewRequest(http.MethodGet, "/thumbnail/mybook/mybook.epub", nil)
+			require.NoError(t, s.Handler(w, req))
+
+			// verify the pre-generated thumbnail is served as is
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+			assert.Equal(t, "Accept", w.Header().Get("Vary"))
+			assert.Equal(t, pregenerated, w.Body.Bytes())
+
+			// the thumbnails are not listed
+			for _, input := range []string{"/shelf", "/new"} {
+				w = httptest.NewRecorder()
+				req = httptest.NewRequest(http.MethodGet, input, nil)
+				require.NoError(t, s.Handler(w, req))
+				assert.NotContains(t, w.Body.String(), ".thumbnails")
+			}
+		})
+	}
+}
+
+func TestHandlerThumbnailFallsBackToCover(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook.epub"), []byte("Fixture"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "metadata.json"), []byte(`{"cover": "https://covers.example/mybook.jpg"}`), 0o644))
+	s := service.OPDS{TrustedRoot: dir, SidecarMetadata: true, Thumbnails: true}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/shelf", nil)
+
+	// act
+	require.NoError(t, s.Handler(w, req))
+
+	// verify a remote cover can't be resized so it is the thumbnail too
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/image/thumbnail" href="https://covers.example/mybook.jpg" type="image/jpeg"></link>`)
+}
+
+// writeJPEG writes a width x height jpeg image in fPath
+func writeJPEG(t *testing.T, fPath string, width, height int) {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, gradient(width, height), nil))
+	require.NoError(t, os.WriteFile(fPath, buf.Bytes(), 0o644))
+}
+
+func gradient(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestHandlerCoverContentLength(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "mybook"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook", "mybook.epub"), []byte("Fixture"), 0o644))
+	// a png named like a calibre cover
+	var cover bytes.Buffer
+	require.NoError(t, png.Encode(&cover, gradient(600, 900)))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mybook", "cover.jpg"), cover.Bytes(), 0o644))
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "mybook", "cover.jpg"), modified, modified))
+	s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true, Thumbnails: true, HashedCovers: true, HashedCoverIndex: service.NewHashedCoverIndex(0)}
+	// the hashed covers are served once a feed links to them
+	require.NoError(t, s.Handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/shelf/mybook", nil)))
+
+	tests := map[string]struct {
+		input            string
+		wantType         string
+		wantBody         []byte
+		wantLastModified string
+	}{
+		"cover":        {input: "/shelf/mybook/cover.jpg", wantType: "image/png", wantBody: cover.Bytes(), wantLastModified: modified.Format(http.TimeFormat)},
+		"hashed cover": {input: fmt.Sprintf("/covers/%x.jpg", sha256.Sum256(cover.Bytes())), wantType: "image/png", wantBody: cover.Bytes(), wantLastModified: modified.Format(http.TimeFormat)},
+		"thumbnail":    {input: "/thumbnail/mybook/mybook.epub", wantType: "image/jpeg"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.input, nil)
+
+			// act
+			require.NoError(t, s.Handler(w, req))
+
+			// verify
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.wantType, w.Header().Get("Content-Type"))
+			assert.Equal(t, strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+			if tc.wantBody != nil {
+				assert.Equal(t, tc.wantBody, w.Body.Bytes())
+			}
+			if tc.wantLastModified != "" {
+				assert.Equal(t, tc.wantLastModified, w.Header().Get("Last-Modified"))
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dubyte/dir2opds/internal/epub"
+	"github.com/dubyte/dir2opds/opds"
+)
+
+// manifestPathPrefix serves in /manifest/<path of the epub> the Readium Web Publication Manifest
+// of an epub and in /manifest/<path of the epub>/<path in the epub> the resources it lists
+const manifestPathPrefix = "/manifest/"
+
+const webpubType = "application/webpub+json"
+
+// https://readium.org/webpub-manifest/
+type webpubManifest struct {
+	Context      string         `json:"@context"`
+	Metadata     webpubMetadata `json:"metadata"`
+	Links        []webpubLink   `json:"links"`
+	ReadingOrder []webpubLink   `json:"readingOrder"`
+	Resources    []webpubLink   `json:"resources"`
+}
+
+type webpubMetadata struct {
+	Type     string   `json:"@type"`
+	Title    string   `json:"title"`
+	Author   []string `json:"author,omitempty"`
+	Language string   `json:"language,omitempty"`
+}
+
+type webpubLink struct {
+	Rel  string `json:"rel,omitempty"`
+	Href string `json:"href"`
+	Type string `json:"type,omitempty"`
+}
+
+// addWebpub links the entry of an epub to its manifest for the readers streaming it
+func (s OPDS) addWebpub(filePath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	if !s.WebpubManifests || strings.ToLower(filepath.Ext(filePath)) != ".epub" {
+		return builder
+	}
+
+	_, pathRelativeToContentRoot, _ := strings.Cut(filePath, s.TrustedRoot+"/")
+	return builder.AddLink(opds.LinkBuilder.
+		Rel("http://opds-spec.org/acquisition/open-access").
+		Href(s.href(manifestPathPrefix + escapePath(pathRelativeToContentRoot))).
+		Type(webpubType).
+		Build())
+}
+
+// serveManifest serves the manifest of the epub in urlPath or one of its resources
+func (s OPDS) serveManifest(w http.ResponseWriter, req *http.Request, urlPath string) error {
+	bookPath, resource := strings.TrimPrefix(urlPath, manifestPathPrefix), ""
+	if i := strings.Index(strings.ToLower(bookPath), ".epub/"); i >= 0 {
+		bookPath, resource = bookPath[:i+len(".epub")], bookPath[i+len(".epub/"):]
+	}
+
+	filePath, err := verifyPath(filepath.Join(s.TrustedRoot, bookPath), s.TrustedRoot)
+	if err != nil {
+		log.Printf("manifest %q err: %s", filePath, err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	_, pathRelativeToContentRoot, _ := strings.Cut(filePath, s.TrustedRoot+"/")
+	if !s.WebpubManifests || strings.ToLower(filepath.Ext(filePath)) != ".epub" || s.fileShouldBeIgnored(pathRelativeToContentRoot) || !s.included(filepath.Base(filePath)) || s.tooSmall(filePath) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	if s.accessDenied(w, req, pathRelativeToContentRoot) {
+		return nil
+	}
+
+	pkg, err := epub.ReadPackage(filePath)
+	if err != nil {
+		log.Printf("manifest %q err: %s", filePath, err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	if resource != "" {
+		return s.serveManifestResource(w, filePath, pkg, resource)
+	}
+
+	content, err := json.Marshal(s.makeManifest(filePath, pkg))
+	if err != nil {
+		return err
+	}
+	w.Header().Add("Content-Type", webpubType)
+	http.ServeContent(w, req, "manifest.json", TimeNow(), bytes.NewReader(content))
+	return nil
+}
+
+// makeManifest returns the manifest of the epub in filePath, the hrefs of its resources are
+// served by serveManifestResource
+func (s OPDS) makeManifest(filePath string, pkg epub.Package) webpubManifest {
+	_, pathRelativeToContentRoot, _ := strings.Cut(filePath, s.TrustedRoot+"/")
+	self := manifestPathPrefix + escapePath(pathRelativeToContentRoot)
+
+	title := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	if len(pkg.Metadata.Titles) > 0 {
+		title = pkg.Metadata.Titles[0].Value
+	}
+
+	manifest := webpubManifest{
+		Context:      "https://readium.org/webpub-manifest/context.jsonld",
+		Metadata:     webpubMetadata{Type: "http://schema.org/Book", Title: title, Author: pkg.Metadata.Creators, Language: pkg.Language},
+		Links:        []webpubLink{{Rel: "self", Href: s.href(self), Type: webpubType}},
+		ReadingOrder: []webpubLink{},
+		Resources:    []webpubLink{},
+	}
+
+	for _, resource := range pkg.ReadingOrder {
+		manifest.ReadingOrder = append(manifest.ReadingOrder, webpubLink{Href: s.href(self + "/" + escapePath(resource.Href)), Type: resource.MediaType})
+	}
+	for _, resource := range pkg.Resources {
+		if !slices.Contains(pkg.ReadingOrder, resource) {
+			manifest.Resources = append(manifest.Resources, webpubLink{Href: s.href(self + "/" + escapePath(resource.Href)), Type: resource.MediaType})
+		}
+	}
+	return manifest
+}
+
+// serveManifestResource serves a resource listed in the manifest of the epub in filePath
+func (s OPDS) serveManifestResource(w http.ResponseWriter, filePath string, pkg epub.Package, resource string) error {
+	i := slices.IndexFunc(pkg.Resources, func(r epub.Resource) bool {
+		return r.Href == resource
+	})
+	if i < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	mediaType := pkg.Resources[i].MediaType
+	if mediaType == "" {
+		mediaType = mime.TypeByExtension(strings.ToLower(path.Ext(resource)))
+	}
+
+	r, err := zip.OpenReader(filePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := r.Open(resource)
+	if err != nil {
+		log.Printf("manifest resource %q err: %s", resource, err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	defer f.Close()
+
+	w.Header().Add("Content-Type", mediaType)
+	_, err = io.Copy(w, f)
+	return err
+}
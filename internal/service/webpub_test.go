@@ -0,0 +1,129 @@
+package service_test
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerWebpubManifest(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "fiction"), 0o755))
+	f, err := os.Create(filepath.Join(dir, "fiction", "good omens.epub"))
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for name, content := range map[string]string{
+		"META-INF/container.xml": `<container><rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`,
+		"OEBPS/content.opf": `<package xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <metadata><dc:title>Good Omens</dc:title><dc:creator>Terry Pratchett</dc:creator><dc:language>en</dc:language></metadata>
+  <manifest>
+    <item id="css" href="style.css" media-type="text/css"/>
+    <item id="c2" href="chapter2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c1" href="chapter1.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine><itemref idref="c1"/><itemref idref="c2"/></spine>
+</package>`,
+		"OEBPS/chapter1.xhtml": "<html><body>In the beginning</body></html>",
+		"OEBPS/chapter2.xhtml": "<html><body>Eleven years later</body></html>",
+		"OEBPS/style.css":      "body {}",
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	s := service.OPDS{TrustedRoot: dir, WebpubManifests: true}
+
+	t.Run("entry links the manifest", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/shelf/fiction", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/acquisition/open-access" href="/manifest/fiction/good%20omens.epub" type="application/webpub+json"></link>`)
+	})
+
+	t.Run("manifest", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/manifest/fiction/good%20omens.epub", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify the reading order is the spine
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/webpub+json", w.Header().Get("Content-Type"))
+		var manifest struct {
+			Metadata struct {
+				Title    string   `json:"title"`
+				Author   []string `json:"author"`
+				Language string   `json:"language"`
+			} `json:"metadata"`
+			ReadingOrder []struct {
+				Href string `json:"href"`
+				Type string `json:"type"`
+			} `json:"readingOrder"`
+			Resources []struct {
+				Href string `json:"href"`
+			} `json:"resources"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &manifest))
+		assert.Equal(t, "Good Omens", manifest.Metadata.Title)
+		assert.Equal(t, []string{"Terry Pratchett"}, manifest.Metadata.Author)
+		assert.Equal(t, "en", manifest.Metadata.Language)
+		require.Len(t, manifest.ReadingOrder, 2)
+		assert.Equal(t, "/manifest/fiction/good%20omens.epub/OEBPS/chapter1.xhtml", manifest.ReadingOrder[0].Href)
+		assert.Equal(t, "application/xhtml+xml", manifest.ReadingOrder[0].Type)
+		assert.Equal(t, "/manifest/fiction/good%20omens.epub/OEBPS/chapter2.xhtml", manifest.ReadingOrder[1].Href)
+		require.Len(t, manifest.Resources, 1)
+		assert.Equal(t, "/manifest/fiction/good%20omens.epub/OEBPS/style.css", manifest.Resources[0].Href)
+
+		// act
+		w = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, manifest.ReadingOrder[0].Href, nil)
+		require.NoError(t, s.Handler(w, req))
+
+		// verify the resources are served from the epub
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/xhtml+xml", w.Header().Get("Content-Type"))
+		assert.Equal(t, "<html><body>In the beginning</body></html>", w.Body.String())
+	})
+
+	t.Run("not a resource of the manifest", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/manifest/fiction/good%20omens.epub/META-INF/container.xml", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/manifest/fiction/good%20omens.epub", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/dubyte/dir2opds/internal/epub"
+	"github.com/dubyte/dir2opds/opds"
+)
+
+// withCoverPathPrefix serves in /withcover/<path of the epub> an epub without a cover with the
+// cover.jpg of its folder added to it, for the readers showing only the covers inside the books
+const withCoverPathPrefix = "/withcover/"
+
+// coverToEmbed returns the cover.jpg of the epub in filePath when EmbedCovers is enabled and
+// the package document of the epub declares no cover
+func (s OPDS) coverToEmbed(filePath string) (cover, bool) {
+	if !s.EmbedCovers || strings.ToLower(filepath.Ext(filePath)) != ".epub" {
+		return cover{}, false
+	}
+
+	c, ok := s.resolveCover(filePath)
+	if !ok || c.localPath == "" {
+		return cover{}, false
+	}
+
+	if meta, ok := cachedEpubMetadata(filePath); !ok || meta.hasCover {
+		return cover{}, false
+	}
+	return c, true
+}
+
+// addWithCover links the entry of an epub without a cover to the epub with its cover.jpg added
+func (s OPDS) addWithCover(req *http.Request, filePath string, builder opds.EntryBuilder) opds.EntryBuilder {
+	if _, ok := s.coverToEmbed(filePath); !ok {
+		return builder
+	}
+
+	_, pathRelativeToContentRoot, _ := strings.Cut(filePath, s.TrustedRoot+"/")
+	return builder.AddLink(opds.LinkBuilder.
+		Rel(s.acquisitionRel()).
+		Title(translate(req, "%s with cover", filepath.Base(filePath))).
+		Href(s.href(withCoverPathPrefix + escapePath(pathRelativeToContentRoot))).
+		Type(getType(filePath, pathTypeFile)).
+		Build())
+}
+
+// serveWithCover serves the epub in urlPath with its cover.jpg added to it
+func (s OPDS) serveWithCover(w http.ResponseWriter, req *http.Request, urlPath string) error {
+	bookPath, err := verifyPath(filepath.Join(s.TrustedRoot, strings.TrimPrefix(urlPath, withCoverPathPrefix)), s.TrustedRoot)
+	if err != nil {
+		log.Printf("with cover %q err: %s", bookPath, err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	_, pathRelativeToContentRoot, _ := strings.Cut(bookPath, s.TrustedRoot+"/")
+	if s.fileShouldBeIgnored(pathRelativeToContentRoot) || !s.included(filepath.Base(bookPath)) || s.tooSmall(bookPath) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	if s.accessDenied(w, req, pathRelativeToContentRoot) {
+		return nil
+	}
+
+	c, ok := s.coverToEmbed(bookPath)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	f, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	image, err := io.ReadAll(io.LimitReader(f, maxEmbeddedCoverBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(image) > maxEmbeddedCoverBytes {
+		log.Printf("with cover %q err: cover larger than %d bytes", bookPath, maxEmbeddedCoverBytes)
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	// the epub is written as it is rebuilt, its size is not known before
+	w.Header().Add("Content-Type", getType(bookPath, pathTypeFile))
+	w.Header().Add("Content-Disposition", s.contentDisposition(bookPath))
+	if req.Method == http.MethodHead {
+		return nil
+	}
+	return epub.AddCover(w, bookPath, image, c.mimeType)
+}
@@ -0,0 +1,104 @@
+package service_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dubyte/dir2opds/internal/epub"
+	"github.com/dubyte/dir2opds/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerEmbedCovers(t *testing.T) {
+	// setup
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "mybook"), 0o755))
+	writeEPUB(t, filepath.Join(dir, "mybook", "mybook.epub"), `<dc:title>My book</dc:title>`)
+	writeJPEG(t, filepath.Join(dir, "mybook", "cover.jpg"), 60, 90)
+	image, err := os.ReadFile(filepath.Join(dir, "mybook", "cover.jpg"))
+	require.NoError(t, err)
+
+	t.Run("linked from the entry", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true, EmbedCovers: true}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/shelf/mybook", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `<link rel="http://opds-spec.org/acquisition" href="/withcover/mybook/mybook.epub" type="application/epub+zip" title="mybook.epub with cover"></link>`)
+	})
+
+	t.Run("the epub contains the cover", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true, EmbedCovers: true}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/withcover/mybook/mybook.epub", nil)
+
+		// act
+		require.NoError(t, s.Handler(w, req))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/epub+zip", w.Header().Get("Content-Type"))
+		assert.Empty(t, w.Header().Get("Content-Length"), "the epub is written as it is rebuilt")
+		withCover := filepath.Join(t.TempDir(), "mybook.epub")
+		require.NoError(t, os.WriteFile(withCover, w.Body.Bytes(), 0o644))
+
+		pkg, err := epub.ReadPackage(withCover)
+		require.NoError(t, err)
+		assert.Equal(t, epub.Resource{Href: "dir2opds-cover.jpg", MediaType: "image/jpeg"}, pkg.Cover)
+		assert.Equal(t, []epub.Title{{Value: "My book"}}, pkg.Metadata.Titles)
+
+		r, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		require.NoError(t, err)
+		f, err := r.Open("dir2opds-cover.jpg")
+		require.NoError(t, err)
+		defer f.Close()
+		content, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, image, content)
+	})
+
+	t.Run("an epub with a cover", func(t *testing.T) {
+		dir := t.TempDir()
+		// the metadata is closed to declare the cover in the manifest
+		writeEPUB(t, filepath.Join(dir, "mybook.epub"), `<meta name="cover" content="cover"/></metadata><manifest><item id="cover" href="cover.png" media-type="image/png"/></manifest><metadata>`)
+		writeJPEG(t, filepath.Join(dir, "cover.jpg"), 60, 90)
+		s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true, EmbedCovers: true}
+
+		// act
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/shelf", nil)))
+
+		// verify
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "/withcover/")
+
+		// act
+		w = httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/withcover/mybook.epub", nil)))
+
+		// verify
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		s := service.OPDS{TrustedRoot: dir, UseCalibreCovers: true}
+
+		// act
+		w := httptest.NewRecorder()
+		require.NoError(t, s.Handler(w, httptest.NewRequest(http.MethodGet, "/withcover/mybook/mybook.epub", nil)))
+
+		// verify
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -25,21 +25,126 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	runtimedebug "runtime/debug"
+	"strings"
+	"time"
 
 	"github.com/dubyte/dir2opds/internal/service"
+	"golang.org/x/tools/blog/atom"
 )
 
+// version is set by goreleaser using ldflags
+var version = "dev"
+
 var (
 	port             = flag.String("port", "8080", "The server will listen in this port.")
 	host             = flag.String("host", "0.0.0.0", "The server will listen in this host.")
 	dirRoot          = flag.String("dir", "./books", "A directory with books.")
+	check            = flag.Bool("check", false, "Check the whole catalog, report the files that fail and exit.")
+	randomBooks      = flag.Int("random-books", 0, "The number of books of the /random feed, new ones every day. Zero disables it.")
+	prewarmOnStart   = flag.Bool("prewarm", false, "Walk the whole catalog at startup to fill the caches and log the books found.")
 	debug            = flag.Bool("debug", false, "If it is set it will log the requests.")
 	calibre          = flag.Bool("calibre", false, "Hide files stored by calibre (except covers if enabled)")
 	useCalibreCovers = flag.Bool("use-calibre-covers", false, "Use covers stored by calibre.")
 	hideDotFiles     = flag.Bool("hide-dot-files", false, "Hide files that starts with dot.")
 	noCache          = flag.Bool("no-cache", false, "adds reponse headers to avoid client from caching.")
+	thumbnails       = flag.Bool("thumbnails", false, "Add thumbnails of the covers, resized on the fly (requires -use-calibre-covers).")
+	externalReader   = flag.String("external-reader", "", "Link the books to a web reader, {href} is replaced with their download url, e.g. https://reader.example/open?url={href}.")
+	readerRel        = flag.String("external-reader-rel", "", "The rel of the links to the web reader (default alternate).")
+	sidecarMetadata  = flag.Bool("sidecar-metadata", false, "Read the metadata of the books in a folder from its metadata.json.")
+	folderNotes      = flag.Bool("folder-notes", false, "Show the README or about.txt of a folder as the subtitle of its feed.")
+	hashedCovers     = flag.Bool("hashed-covers", false, "Link the covers at urls made of their sha-256 that clients can cache forever.")
+	hashedCoversSize = flag.Int("hashed-covers-size", 10000, "Number of hashed covers whose books are remembered to serve them. Zero means no limit.")
+	mosaics          = flag.Bool("mosaics", false, "Add thumbnails to folders made from the covers of their books (requires -use-calibre-covers).")
+	bookFolders      = flag.Bool("book-folders", false, "Present a folder holding one book in several formats as a single book.")
+	formatFacets     = flag.Bool("book-format-facets", false, "Link a facet per format in the feed of a book folder (requires -book-folders).")
+	folderUpdated    = flag.Bool("folder-updated", false, "Set the updated time of the folders from the newest file or folder they hold.")
+	folderDeep       = flag.Bool("folder-updated-deep", false, "Look into every subfolder for the updated time of the folders (requires -folder-updated).")
+	magazineMode     = flag.Bool("magazine-mode", false, "List the folders named after a date holding a single pdf, like Magazine/2023-01/issue.pdf, as magazine issues.")
+	webpub           = flag.Bool("webpub", false, "Serve a Readium Web Publication Manifest of the epubs for streaming readers.")
+	libraryStats     = flag.Bool("library-stats", false, "Show the number of books and their size in the root feed, counting them walks the whole library.")
+	folderInTitles   = flag.String("folder-in-titles", "", "Qualify the titles of the books of the flat feeds, like /new or the search results, with the name of their folder, as a \"prefix\" or a \"suffix\".")
+	groupBy          = flag.String("group-by", "", "Group the books of the folders by \"format\" or by the first \"letter\" of their title.")
+	sortBy           = flag.String("sort", "title", "Sort the books of a folder by \"title\" case-insensitively, by \"date\" the most recently modified first or by file \"name\".")
+	maxEntries       = flag.Int("max-entries", 0, "Maximum number of books listed in the feed of a folder, the first ones once sorted. Zero means no limit.")
+	disableSearch    = flag.Bool("disable-search", false, "Don't serve the search and remove the search links from the feeds.")
+	streamSearch     = flag.Bool("stream-search", false, "Write the search results as they are found, their total goes after them.")
+	html             = flag.Bool("html", false, "Serve the feeds as html pages to browsers.")
+	inlinePreview    = flag.Bool("inline-preview", false, "Serve the images and pdfs inline so browsers show them, the books are still downloaded.")
+	openAccess       = flag.Bool("open-access", false, "Mark the downloads as open-access acquisitions.")
+	ebooksOnly       = flag.Bool("ebook-extensions-only", false, "Classify a folder as a folder of books only when it holds ebooks.")
+	authorFolder     = flag.Bool("author-from-folder", false, "Set the author of the books from their folders (Author/Title/book.epub or Author/book.epub).")
+	epubMetadata     = flag.Bool("epub-metadata", false, "Read the metadata of the epubs, like their authors, their cover or their identifier.")
+	defaultRights    = flag.String("default-rights", "", "The license of the books without one in their epub metadata, like \"CC BY-SA 4.0\".")
+	embedCovers      = flag.Bool("embed-covers", false, "Offer the epubs without a cover with the cover.jpg of their folder added to them (requires -use-calibre-covers).")
+	authorSeparator  = flag.String("author-separator", "", "Split an epub author like \"A & B\" in several authors on it, e.g. &. Empty keeps the author whole.")
+	checksums        = flag.Bool("checksums", false, "Add the sha-256 of the books to their entries.")
+	seriesTitles     = flag.Bool("series-index-titles", false, "Prefix the titles of the epubs with their zero-padded calibre series index (requires -epub-metadata).")
+	seriesFormat     = flag.String("series-title-format", "%s - %s", "The format of the padded series index and the title.")
+	seriesWidth      = flag.Int("series-index-width", 0, "The width the series indices are zero-padded to, zero pads them to the largest index of the folder.")
+	comicZips        = flag.Bool("comic-zips", false, "List the zips holding only images as comics.")
+	extensionless    = flag.String("extensionless", "", "What to do with the files without extension: \"skip\" them, give them the \"default\" type or \"sniff\" their type from their content. They are listed without type otherwise.")
+	noExtType        = flag.String("extensionless-type", "", "The type of the files without extension (default application/octet-stream).")
+	archiveFormats   = flag.Bool("archive-formats", false, "Tell the types of the books inside the zips with opds:indirectAcquisition.")
+	bookLength       = flag.Bool("book-length", false, "Add the page count of pdfs and the approximate word count of epubs to their summary.")
+	metadataWorkers  = flag.Int("metadata-workers", 0, "The number of books of a folder whose metadata is read at the same time. Zero uses one per CPU.")
+	buildTimeout     = flag.Duration("build-timeout", 0, "Maximum time to build a feed, 503 is returned when it expires. Zero means no limit.")
+	maxTitleLength   = flag.Int("max-title-length", 0, "Truncate the titles longer than that many characters with an ellipsis. Zero means no limit.")
+	compactOutput    = flag.Bool("compact-output", false, "Encode the feeds without indentation for smaller responses.")
+	readerQuirks     = flag.Bool("reader-quirks", false, "Tweak the feeds for the quirks of some known readers by their User-Agent, like FBReader without escaped slashes in the hrefs.")
+	feedCacheSize    = flag.Int("feed-cache-size", 0, "Number of folder feeds kept in memory until their folder changes. Zero disables the cache unless -feed-cache-bytes is set.")
+	feedCacheBytes   = flag.Int("feed-cache-bytes", 0, "Maximum size in bytes of the folder feeds kept in memory. Zero means no limit.")
+	cacheDir         = flag.String("cache-dir", "", "A directory outside of dir keeping the checksums, extracted covers, thumbnails and mosaics across restarts.")
+	firstSeen        = flag.Bool("first-seen", false, "Date the books by the time they were first listed, kept in -cache-dir, and by their modification time.")
+	minFileSize      = flag.Int64("min-file-size", 0, "Hide the files smaller than that many bytes, like placeholders. Zero lists every file.")
+	placeholders     = flag.String("placeholder-covers", "", "A directory with the covers of the books without one, named after their format like epub.png or pdf.png, default.png covers the other formats.")
+	maxFeedBytes     = flag.Int("max-feed-bytes", 0, "Maximum size of a feed, 413 is returned for larger feeds. Zero means no limit.")
+	allowUnsafeRoot  = flag.Bool("allow-unsafe-root", false, "Allow to serve the filesystem root or the home directory.")
+	basePath         = flag.String("base-path", "", "The path the catalog is mounted at, e.g. /opds.")
+	title            = flag.String("title", "dir2opds", "The name of the catalog shown by clients.")
+	description      = flag.String("description", "", "The description of the catalog shown by clients.")
+	providerName     = flag.String("provider-name", "", "The name of the catalog provider, the author of every feed.")
+	providerURI      = flag.String("provider-uri", "", "The uri of the catalog provider.")
+	providerEmail    = flag.String("provider-email", "", "The email of the catalog provider.")
+	robotsTxt        = flag.String("robots-txt", "", "A file with the policy served in /robots.txt, crawlers are disallowed when empty.")
+	shelfTitle       = flag.String("shelf-title", "", "The title of the root entry linking to every folder. Empty shows \"All books\" in the language of the client.")
+	startHref        = flag.String("start-href", "/", "The target of the start link of every feed.")
+	customRootOnly   = flag.Bool("custom-root", false, "Show only the -nav entries in the root feed, without the newest and all books ones.")
+	navEntries       []service.NavEntry
+	rootSections     []string
+	queryParams      []string
+	includeOnly      *regexp.Regexp
 )
 
+func init() {
+	flag.Func("nav", "Extra root entry as \"title|href|rel\" (can be repeated).", func(v string) error {
+		nav, err := parseNavEntry(v)
+		if err != nil {
+			return err
+		}
+		navEntries = append(navEntries, nav)
+		return nil
+	})
+	flag.Func("root-sections", "The built-in feeds listed in the root feed in order, separated by commas: new, shelf, random, calendar, authors and series (default new,shelf).", func(v string) error {
+		for _, section := range strings.Split(v, ",") {
+			rootSections = append(rootSections, strings.TrimSpace(section))
+		}
+		return nil
+	})
+	flag.Func("query-params", "Query params kept besides the ones of the built-in routes like q or page, separated by commas. The other ones are dropped.", func(v string) error {
+		for _, param := range strings.Split(v, ",") {
+			queryParams = append(queryParams, strings.TrimSpace(param))
+		}
+		return nil
+	})
+	flag.Func("include-only", "A regular expression, only the files whose name matches it are listed and served, e.g. \\.epub$.", func(v string) error {
+		var err error
+		includeOnly, err = regexp.Compile(v)
+		return err
+	})
+}
+
 func main() {
 
 	flag.Parse()
@@ -58,9 +163,48 @@ func main() {
 
 	log.Printf("%q will be used as your trusted root", absolutePath)
 
+	var robots []byte
+	if *robotsTxt != "" {
+		robots, err = os.ReadFile(*robotsTxt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	s := service.OPDS{TrustedRoot: absolutePath, HideCalibreFiles: *calibre, UseCalibreCovers: *useCalibreCovers, HideDotFiles: *hideDotFiles, NoCache: *noCache, CacheDir: *cacheDir, FirstSeen: *firstSeen, PlaceholderCovers: *placeholders, StartHref: *startHref, NavEntries: navEntries, CustomRootOnly: *customRootOnly, RootSections: rootSections, Version: buildVersion(), Thumbnails: *thumbnails, HashedCovers: *hashedCovers, Mosaics: *mosaics, SidecarMetadata: *sidecarMetadata, FolderNotes: *folderNotes, AllowUnsafeRoot: *allowUnsafeRoot, BuildTimeout: *buildTimeout, MetadataWorkers: *metadataWorkers, MaxFeedBytes: *maxFeedBytes, CompactOutput: *compactOutput, MaxTitleLength: *maxTitleLength, RobotsTxt: string(robots), BookLength: *bookLength, ComicZips: *comicZips, ArchiveFormats: *archiveFormats, Extensionless: *extensionless, ExtensionlessType: *noExtType, IncludeChecksums: *checksums, BookFolders: *bookFolders, BookFormatFacets: *formatFacets, HTML: *html, InlinePreview: *inlinePreview, RandomBooks: *randomBooks, DisableSearch: *disableSearch, StreamSearch: *streamSearch, Sort: *sortBy, GroupBy: *groupBy, FolderInTitles: *folderInTitles, QueryParams: queryParams, MaxEntriesPerFeed: *maxEntries, WebpubManifests: *webpub, OpenAccess: *openAccess, EbookExtensionsOnly: *ebooksOnly, IncludeOnly: includeOnly, MinFileSize: *minFileSize, FolderUpdated: *folderUpdated, FolderUpdatedDeep: *folderDeep, MagazineMode: *magazineMode, AuthorFromFolder: *authorFolder, BasePath: *basePath, EpubMetadata: *epubMetadata, EmbedCovers: *embedCovers, DefaultRights: *defaultRights, ExternalReaderTemplate: *externalReader, ExternalReaderRel: *readerRel, SeriesIndexTitles: *seriesTitles, SeriesTitleFormat: *seriesFormat, SeriesIndexWidth: *seriesWidth, AuthorSeparator: *authorSeparator, Title: *title, ShelfTitle: *shelfTitle, Description: *description, Provider: atom.Person{Name: *providerName, URI: *providerURI, Email: *providerEmail}}
+
+	if *libraryStats {
+		s.LibraryStats = service.NewLibraryStats()
+	}
+
+	if *hashedCovers {
+		s.HashedCoverIndex = service.NewHashedCoverIndex(*hashedCoversSize)
+	}
+
+	if *readerQuirks {
+		s.UserAgentQuirks = service.KnownQuirks
+	}
+
+	if *feedCacheSize > 0 || *feedCacheBytes > 0 {
+		s.FeedCache = service.NewFeedCache(*feedCacheSize, *feedCacheBytes)
+	}
+
+	s, err = service.NewOPDS(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	if *check {
+		os.Exit(runCheck(s))
+	}
+
 	fmt.Println(startValues())
 
-	s := service.OPDS{TrustedRoot: absolutePath, HideCalibreFiles: *calibre, UseCalibreCovers: *useCalibreCovers, HideDotFiles: *hideDotFiles, NoCache: *noCache}
+	if *prewarmOnStart {
+		go prewarm(s)
+	}
 
 	http.HandleFunc("/", errorHandler(s.Handler))
 
@@ -82,6 +226,55 @@ func errorHandler(f func(http.ResponseWriter, *http.Request) error) http.Handler
 	}
 }
 
+// runCheck prints the issues of the catalog and returns the exit code, 1 when there are issues
+func runCheck(s service.OPDS) int {
+	report, err := service.Diagnose(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	for _, issue := range report.Issues {
+		fmt.Println(issue)
+	}
+	if len(report.Issues) > 0 {
+		fmt.Fprintf(os.Stderr, "%d issues found\n", len(report.Issues))
+		return 1
+	}
+	return 0
+}
+
+// prewarm fills the caches of s walking the whole catalog and logs its summary
+func prewarm(s service.OPDS) {
+	start := time.Now()
+	summary, err := service.Prewarm(s)
+	if err != nil {
+		log.Printf("prewarm err: %s", err)
+		return
+	}
+	log.Printf("prewarm: %s in %s", summary, time.Since(start).Round(time.Millisecond))
+}
+
+// buildVersion returns the version set at build time or the module version when installed with go install
+func buildVersion() string {
+	if version != "dev" {
+		return version
+	}
+	if info, ok := runtimedebug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return version
+}
+
+// parseNavEntry parses a root navigation entry in the form title|href|rel
+func parseNavEntry(v string) (service.NavEntry, error) {
+	parts := strings.Split(v, "|")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return service.NavEntry{}, fmt.Errorf("nav entry %q: expected title|href|rel", v)
+	}
+	return service.NavEntry{Title: parts[0], Href: parts[1], Rel: parts[2]}, nil
+}
+
 // absoluteCanonicalPath returns the canonical path of the absolute path that was passed
 func absoluteCanonicalPath(aPath string) (string, error) {
 	// get absolute path
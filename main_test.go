@@ -89,3 +89,14 @@ func Test_absoluteCannnonicalPath(t *testing.T) {
 		})
 	}
 }
+
+func TestParseNavEntry(t *testing.T) {
+	nav, err := parseNavEntry("Magazines|/shelf/magazines|subsection")
+	assert.NoError(t, err)
+	assert.Equal(t, "Magazines", nav.Title)
+	assert.Equal(t, "/shelf/magazines", nav.Href)
+	assert.Equal(t, "subsection", nav.Rel)
+
+	_, err = parseNavEntry("Magazines")
+	assert.Error(t, err)
+}
@@ -1,6 +1,9 @@
 package opds
 
 import (
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/lann/builder"
@@ -13,12 +16,33 @@ func (e EntryBuilder) Title(title string) EntryBuilder {
 	return builder.Set(e, "Title", title).(EntryBuilder)
 }
 
+// AddTitle adds the translation of the title in lang, it follows the main title
+func (e EntryBuilder) AddTitle(lang string, title string) EntryBuilder {
+	return builder.Append(e, "Titles", Title{Lang: lang, Value: title}).(EntryBuilder)
+}
+
 func (e EntryBuilder) ID(id string) EntryBuilder {
 	return builder.Set(e, "ID", id).(EntryBuilder)
 }
 
 func (e EntryBuilder) AddLink(link atom.Link) EntryBuilder {
-	return builder.Append(e, "Link", link).(EntryBuilder)
+	return builder.Append(e, "Link", Link{Link: link}).(EntryBuilder)
+}
+
+// AddIndirectAcquisition adds to the links of the entry with rel the types of the resources inside them
+func (e EntryBuilder) AddIndirectAcquisition(rel string, indirect ...IndirectAcquisition) EntryBuilder {
+	value, ok := builder.Get(e, "Link")
+	if !ok {
+		return e
+	}
+
+	links := append([]Link(nil), value.([]Link)...)
+	for i := range links {
+		if links[i].Rel == rel {
+			links[i].IndirectAcquisition = append(slices.Clip(links[i].IndirectAcquisition), indirect...)
+		}
+	}
+	return builder.Extend(builder.Delete(e, "Link"), "Link", links).(EntryBuilder)
 }
 
 func (e EntryBuilder) Published(published time.Time) EntryBuilder {
@@ -29,8 +53,24 @@ func (e EntryBuilder) Updated(updated time.Time) EntryBuilder {
 	return builder.Set(e, "Updated", atom.Time(updated)).(EntryBuilder)
 }
 
+// Author sets the only author of the entry
 func (e EntryBuilder) Author(author *atom.Person) EntryBuilder {
-	return builder.Set(e, "Author", author).(EntryBuilder)
+	return builder.Set(e, "Author", []atom.Person{*author}).(EntryBuilder)
+}
+
+// AddAuthor adds an author to the entry, an entry may have several authors
+func (e EntryBuilder) AddAuthor(author atom.Person) EntryBuilder {
+	return builder.Append(e, "Author", author).(EntryBuilder)
+}
+
+// AddCategory adds a category to the entry, an entry may have several categories
+func (e EntryBuilder) AddCategory(category Category) EntryBuilder {
+	return builder.Append(e, "Category", category).(EntryBuilder)
+}
+
+// AddIdentifier adds a dc:identifier to the entry, like urn:isbn:9780000000000
+func (e EntryBuilder) AddIdentifier(identifier string) EntryBuilder {
+	return builder.Append(e, "Identifier", identifier).(EntryBuilder)
 }
 
 func (e EntryBuilder) Summary(summary *atom.Text) EntryBuilder {
@@ -41,9 +81,37 @@ func (e EntryBuilder) Content(content *atom.Text) EntryBuilder {
 	return builder.Set(e, "Content", content).(EntryBuilder)
 }
 
-func (e EntryBuilder) Build() atom.Entry {
-	return builder.GetStruct(e).(atom.Entry)
+// Rights sets the license of the entry
+func (e EntryBuilder) Rights(rights *atom.Text) EntryBuilder {
+	return builder.Set(e, "Rights", rights).(EntryBuilder)
+}
+
+// Build returns the entry with its main title followed by its translations
+// and its links in canonical order: acquisition, image, thumbnail, alternate and then any other link.
+func (e EntryBuilder) Build() Entry {
+	entry := builder.GetStruct(e).(Entry)
+	entry.Titles = append([]Title{{Value: entry.Title}}, entry.Titles...)
+	entry.Link = append([]Link(nil), entry.Link...)
+	sort.SliceStable(entry.Link, func(i, j int) bool {
+		return linkRank(entry.Link[i].Rel) < linkRank(entry.Link[j].Rel)
+	})
+	return entry
+}
+
+func linkRank(rel string) int {
+	switch {
+	case strings.HasPrefix(rel, "http://opds-spec.org/acquisition"):
+		return 0
+	case rel == "http://opds-spec.org/image":
+		return 1
+	case rel == "http://opds-spec.org/image/thumbnail":
+		return 2
+	case rel == "alternate":
+		return 3
+	default:
+		return 4
+	}
 }
 
 // Builder is a fluent immutable builder to build OPDS entries
-var Builder = builder.Register(EntryBuilder{}, atom.Entry{}).(EntryBuilder)
+var Builder = builder.Register(EntryBuilder{}, Entry{}).(EntryBuilder)
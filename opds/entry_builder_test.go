@@ -0,0 +1,58 @@
+package opds
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/blog/atom"
+)
+
+func TestEntryBuilderLinkOrder(t *testing.T) {
+	acquisition := LinkBuilder.Rel("http://opds-spec.org/acquisition").Href("/shelf/book.epub").Build()
+	openAccess := LinkBuilder.Rel("http://opds-spec.org/acquisition/open-access").Href("/shelf/book.pdf").Build()
+	image := LinkBuilder.Rel("http://opds-spec.org/image").Href("/shelf/cover.jpg").Build()
+	thumbnail := LinkBuilder.Rel("http://opds-spec.org/image/thumbnail").Href("/thumbnail/book.epub").Build()
+	alternate := LinkBuilder.Rel("alternate").Href("https://reader.example/book.epub").Build()
+	related := LinkBuilder.Rel("related").Href("/authors/someone").Build()
+
+	want := []atom.Link{acquisition, openAccess, image, thumbnail, alternate, related}
+
+	tests := map[string][]atom.Link{
+		"canonical order": {acquisition, openAccess, image, thumbnail, alternate, related},
+		"reversed order":  {related, alternate, thumbnail, image, acquisition, openAccess},
+		"shuffled order":  {thumbnail, acquisition, related, image, alternate, openAccess},
+	}
+
+	for name, links := range tests {
+		t.Run(name, func(t *testing.T) {
+			builder := EntryBuilder{}.Title("book")
+			for _, link := range links {
+				builder = builder.AddLink(link)
+			}
+
+			entry := builder.Build()
+
+			var got []atom.Link
+			for _, link := range entry.Link {
+				got = append(got, link.Link)
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestEntryBuilderAddIndirectAcquisition(t *testing.T) {
+	acquisition := LinkBuilder.Rel("http://opds-spec.org/acquisition").Href("/shelf/book.zip").Type("application/zip").Build()
+	image := LinkBuilder.Rel("http://opds-spec.org/image").Href("/shelf/cover.jpg").Build()
+	epub := IndirectAcquisition{Type: "application/epub+zip"}
+	pdf := IndirectAcquisition{Type: "application/pdf"}
+
+	builder := EntryBuilder{}.Title("book").AddLink(acquisition)
+	withFormats := builder.AddIndirectAcquisition("http://opds-spec.org/acquisition", epub, pdf).AddLink(image)
+
+	entry := withFormats.Build()
+
+	assert.Equal(t, []Link{{Link: acquisition, IndirectAcquisition: []IndirectAcquisition{epub, pdf}}, {Link: image}}, entry.Link)
+	assert.Equal(t, []Link{{Link: acquisition}}, builder.Build().Link, "the builder is immutable")
+	assert.Empty(t, EntryBuilder{}.Title("book").AddIndirectAcquisition("http://opds-spec.org/acquisition", epub).Build().Link, "an entry without links")
+}
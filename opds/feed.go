@@ -0,0 +1,68 @@
+package opds
+
+import (
+	"encoding/xml"
+
+	"golang.org/x/tools/blog/atom"
+)
+
+// Feed is an atom feed holding OPDS entries
+type Feed struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string   `xml:"title"`
+	// Subtitle describes the feed, like the notes of a folder
+	Subtitle *atom.Text   `xml:"subtitle"`
+	ID       string       `xml:"id"`
+	Link     []atom.Link  `xml:"link"`
+	Updated  atom.TimeStr `xml:"updated"`
+	Author   *atom.Person `xml:"author"`
+	Entry    []*Entry     `xml:"entry"`
+}
+
+// Category is an atom category, the Term identifies it and the Label is shown to the readers
+type Category struct {
+	Term   string `xml:"term,attr"`
+	Scheme string `xml:"scheme,attr,omitempty"`
+	Label  string `xml:"label,attr,omitempty"`
+}
+
+// Title is a title in the language of Lang, any language when it is empty
+type Title struct {
+	Lang  string `xml:"http://www.w3.org/XML/1998/namespace lang,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+// Entry is an atom entry, unlike atom.Entry it may have several authors and titles
+type Entry struct {
+	// Title is the main title, EntryBuilder.Build puts it first in the Titles
+	Title string `xml:"-"`
+	// Titles are the title elements of the entry, the Title and its translations
+	Titles    []Title       `xml:"title"`
+	ID        string        `xml:"id"`
+	Link      []Link        `xml:"link"`
+	Published atom.TimeStr  `xml:"published"`
+	Updated   atom.TimeStr  `xml:"updated"`
+	Author    []atom.Person `xml:"author"`
+	// Category are the subjects of the entry, like the tags of a book
+	Category []Category `xml:"category"`
+	Summary  *atom.Text `xml:"summary"`
+	Content  *atom.Text `xml:"content"`
+	// Rights are the license of the entry, like a Creative Commons license
+	Rights *atom.Text `xml:"rights"`
+	// Identifier are the dc:identifier of the entry, like urn:isbn:9780000000000
+	Identifier []string `xml:"http://purl.org/dc/terms/ identifier"`
+}
+
+// Link is an atom link of an entry, an acquisition link may tell the types of the resources
+// obtained from an archive with opds:indirectAcquisition
+type Link struct {
+	atom.Link
+	IndirectAcquisition []IndirectAcquisition `xml:"http://opds-spec.org/2010/catalog indirectAcquisition"`
+}
+
+// IndirectAcquisition is the type of a resource inside the one linked, like an epub in a zip
+type IndirectAcquisition struct {
+	Type string `xml:"type,attr"`
+	// IndirectAcquisition are the types of the resources inside this one
+	IndirectAcquisition []IndirectAcquisition `xml:"http://opds-spec.org/2010/catalog indirectAcquisition"`
+}
@@ -8,7 +8,7 @@ import (
 )
 
 type AcquisitionFeed struct {
-	*atom.Feed
+	*Feed
 	Dc   string `xml:"xmlns:dc,attr"`
 	Opds string `xml:"xmlns:opds,attr"`
 }
@@ -19,6 +19,10 @@ func (f feedBuilder) Title(title string) feedBuilder {
 	return builder.Set(f, "Title", title).(feedBuilder)
 }
 
+func (f feedBuilder) Subtitle(subtitle string) feedBuilder {
+	return builder.Set(f, "Subtitle", &atom.Text{Type: "text", Body: subtitle}).(feedBuilder)
+}
+
 func (f feedBuilder) ID(id string) feedBuilder {
 	return builder.Set(f, "ID", id).(feedBuilder)
 }
@@ -35,13 +39,13 @@ func (f feedBuilder) Author(author atom.Person) feedBuilder {
 	return builder.Set(f, "Author", &author).(feedBuilder)
 }
 
-func (f feedBuilder) AddEntry(entry atom.Entry) feedBuilder {
+func (f feedBuilder) AddEntry(entry Entry) feedBuilder {
 	return builder.Append(f, "Entry", &entry).(feedBuilder)
 }
 
-func (f feedBuilder) Build() atom.Feed {
-	return builder.GetStruct(f).(atom.Feed)
+func (f feedBuilder) Build() Feed {
+	return builder.GetStruct(f).(Feed)
 }
 
 // FeedBuilder is a fluent immutable builder to build OPDS Feeds
-var FeedBuilder = builder.Register(feedBuilder{}, atom.Feed{}).(feedBuilder)
+var FeedBuilder = builder.Register(feedBuilder{}, Feed{}).(feedBuilder)
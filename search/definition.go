@@ -4,8 +4,15 @@ import "encoding/xml"
 
 // OpenSearchDefinition See https://github.com/dewitt/opensearch/blob/master/opensearch-1-1-draft-6.md
 type OpenSearchDefinition struct {
-	XMLName        xml.Name      `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
-	InputEncoding  string        `xml:"InputEncoding"`
-	OutputEncoding string        `xml:"OutputEncoding"`
-	OpenSearchUrl  OpenSearchUrl `xml:"Url"`
+	XMLName xml.Name `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	// ShortName is the name of the search engine shown by clients, at most 16 characters
+	ShortName string `xml:"ShortName"`
+	// Description of the search engine, at most 1024 characters
+	Description string `xml:"Description"`
+	// Comment is written as an xml comment after the Description, like the syntax of the queries
+	Comment        string `xml:",comment"`
+	InputEncoding  string `xml:"InputEncoding"`
+	OutputEncoding string `xml:"OutputEncoding"`
+	// OpenSearchUrls are the templates of the search results and suggestions
+	OpenSearchUrls []OpenSearchUrl `xml:"Url"`
 }
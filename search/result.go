@@ -1,13 +1,14 @@
 package search
 
 import (
+	"github.com/dubyte/dir2opds/opds"
 	"github.com/lann/builder"
 	"golang.org/x/tools/blog/atom"
 	"time"
 )
 
 type SearchResultFeed struct {
-	*atom.Feed
+	*opds.Feed
 	Dc   string `xml:"xmlns:dc,attr"`
 	Opds string `xml:"xmlns:opds,attr"`
 	OS   string `xml:"xmlns:opensearch,attr"`
@@ -40,13 +41,13 @@ func (f feedBuilder) Author(author atom.Person) feedBuilder {
 	return builder.Set(f, "Author", &author).(feedBuilder)
 }
 
-func (f feedBuilder) AddEntry(entry atom.Entry) feedBuilder {
+func (f feedBuilder) AddEntry(entry opds.Entry) feedBuilder {
 	return builder.Append(f, "Entry", &entry).(feedBuilder)
 }
 
-func (f feedBuilder) Build() atom.Feed {
-	return builder.GetStruct(f).(atom.Feed)
+func (f feedBuilder) Build() opds.Feed {
+	return builder.GetStruct(f).(opds.Feed)
 }
 
 // FeedBuilder is a fluent immutable builder to build Search result Feeds
-var FeedBuilder = builder.Register(feedBuilder{}, atom.Feed{}).(feedBuilder)
+var FeedBuilder = builder.Register(feedBuilder{}, opds.Feed{}).(feedBuilder)